@@ -7,12 +7,25 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/9tmark/avly-trader/internal/cleanup"
+	"github.com/9tmark/avly-trader/internal/config"
+	"github.com/9tmark/avly-trader/internal/display"
 	hlp "github.com/9tmark/avly-trader/internal/helpers"
 	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+	"github.com/9tmark/avly-trader/internal/prefix"
+	"github.com/9tmark/avly-trader/internal/status"
+	"github.com/9tmark/avly-trader/internal/vnc"
 )
 
 type FlagInfo struct {
@@ -26,17 +39,22 @@ type FlagInfo struct {
 var env = []string{
 	"USER=root",
 	"AVL_LOGS=/var/log/avly-trader",
+	"AVL_RUNTIME=/dev/shm/avly-trader",
+	"AVL_STATE=/var/lib/avly-trader",
+	"AVL_SECRETS_DIR=/run/secrets",
 	"THIRD_PARTY=/opt/third-party",
+	"AVL_MQL5_SOURCE=/opt/mql5",
+	"AVL_MQL5_BUILD=/var/tmp/avly-mql5",
+	"AVL_TESTER=/var/tmp/avly-tester",
 	"WINEPREFIX=/opt/.mtprfx",
 	"WINEDEBUG=-all",
 	"DISPLAY=:1",
 	"SCREEN_NUM=0",
-	"SCREEN_WHD=1366x768x16",
 	"PATH=/usr/local/bin:/usr/bin:/usr/local/sbin:/usr/sbin:/opt/avly-trader/bin",
 }
 
 func main() {
-	var isPrepare, isFledge, isLaunch, isStop, isDrain, isCleanUp, isEnter, isMute bool
+	var isPrepare, isFledge, isLaunch, isStop, isDrain, isCleanUp, isEnter, isDeploy, isCompile, isBacktest, isFarm, isStatus, isScreenshot, isVncOTP, isVncHook, isReplayExport, isPrefix, isDoctor, isMute, isDryRun, isPrune, isRestart, isWithDeploy, isViewOnly bool
 	mp := &ifc.FmtMsgPrinter{}
 	lp := &ifc.LogMsgPrinter{}
 	runner := &ifc.SafeCmdRunner{}
@@ -47,21 +65,57 @@ func main() {
 		{p: &isLaunch, fName: "launch", sName: "l", defVal: false, usage: "(safely) launch target executable"},
 		{p: &isStop, fName: "stop", sName: "s", defVal: false, usage: "stop target process"},
 		{p: &isDrain, fName: "drain", sName: "d", defVal: false, usage: "shut down VNC server"},
-		{p: &isCleanUp, fName: "clean-up", sName: "c", defVal: false, usage: "dispose remains of target process"},
+		{p: &isCleanUp, fName: "clean-up", sName: "c", defVal: false, usage: "dispose of files in the terminal data dir by the cleanup rules (see -dry-run)"},
 		{p: &isEnter, fName: "enter", sName: "e", defVal: false, usage: "run startup routine as container process"},
+		{p: &isDeploy, fName: "deploy", defVal: false, usage: "sync MQL5 artifacts from $AVL_MQL5_SOURCE into the terminal"},
+		{p: &isCompile, fName: "compile", defVal: false, usage: "compile MQL5 sources of $AVL_MQL5_SOURCE (optional argument: file or folder inside)"},
+		{p: &isBacktest, fName: "backtest", defVal: false, usage: "run the Strategy Tester on a separate instance (argument: tester spec JSON)"},
+		{p: &isStatus, fName: "status", defVal: false, usage: "print the status reported by the watching container process"},
+		{p: &isScreenshot, fName: "screenshot", defVal: false, usage: "capture the virtual display to PNG (see -instance)"},
+		{p: &isVncOTP, fName: "vnc-otp", defVal: false, usage: "issue a one-time VNC password (see -view-only)"},
+		{p: &isVncHook, fName: "vnc-hook", defVal: false, usage: "(internal) called by the VNC server for accepted clients"},
+		{p: &isReplayExport, fName: "replay-export", defVal: false, usage: "export the display recording between -from and -to (argument: file ending in .gif or folder for PNG frames)"},
+		{p: &isPrefix, fName: "prefix", defVal: false, usage: "check, repair or snapshot the Wine prefix (arguments: check, repair, snapshot, restore [name|latest], list or prune, see -keep)"},
+		{p: &isDoctor, fName: "doctor", defVal: false, usage: "diagnose the Wine prefix and drift of the configured registry settings"},
+		{p: &isFarm, fName: "farm", defVal: false, usage: "run a matrix of tester jobs on concurrent instances (argument: farm spec JSON)"},
 		// options
 		{p: &isMute, fName: "mute", sName: "m", defVal: false, usage: "mute output unless error occurs"}, // not supported yet
+		{p: &isDryRun, fName: "dry-run", defVal: false, usage: "only print what would change"},
+		{p: &isPrune, fName: "prune", defVal: false, usage: "remove previously deployed files missing in the source"},
+		{p: &isRestart, fName: "restart", defVal: false, usage: "restart target process if an attached expert changed"},
+		{p: &isWithDeploy, fName: "with-deploy", defVal: false, usage: "deploy the compile output afterwards"},
+		{p: &isViewOnly, fName: "view-only", defVal: false, usage: "one-time password only allows watching"},
 	}
-	verbs := []*bool{&isPrepare, &isFledge, &isLaunch, &isStop, &isDrain, &isCleanUp, &isEnter}
+	verbs := []*bool{&isPrepare, &isFledge, &isLaunch, &isStop, &isDrain, &isCleanUp, &isEnter, &isDeploy, &isCompile, &isBacktest, &isFarm, &isStatus, &isScreenshot, &isVncOTP, &isVncHook, &isReplayExport, &isPrefix, &isDoctor}
 	opts := []*bool{&isMute}
 
 	for i := 0; i < len(flags); i++ {
 		v := flags[i]
 		flag.BoolVar(v.p, v.fName, v.defVal, v.usage)
-		flag.BoolVar(v.p, v.sName, v.defVal, "")
+		if len(v.sName) > 0 {
+			flag.BoolVar(v.p, v.sName, v.defVal, "")
+		}
 	}
 
+	var instance string
+	flag.StringVar(&instance, "instance", "", "tester instance to capture instead of the live terminal, e.g. backtest or farm-0")
+	var replayFrom, replayTo string
+	flag.StringVar(&replayFrom, "from", "1h", "start of the replay: RFC 3339 time or duration back from now")
+	flag.StringVar(&replayTo, "to", "", "end of the replay: RFC 3339 time or duration back from now (default now)")
+	var keep int
+	flag.IntVar(&keep, "keep", defaultSnapshotsKept, "number of newest prefix snapshots to keep")
+
 	flag.Parse()
+	hlp.InheritEnv(&env, "AVL_")
+	if len(hlp.EnvValue(&env, "AVL_PROCESS_LOGS")) == 0 {
+		hlp.SetEnvValue(&env, "AVL_PROCESS_LOGS", filepath.Join(hlp.EnvValue(&env, "AVL_LOGS"), "processes"))
+	}
+	if err := loadConfig(); err != nil {
+		mp.Errorfln("avly: %s", err.Error())
+	}
+	if err := loadRunAs(); err != nil {
+		mp.Errorfln("avly: %s", err.Error())
+	}
 	mp.Printfln("Avly Trader | Cloud Trading CLI")
 
 	switch true {
@@ -75,26 +129,45 @@ func main() {
 	case isLaunch:
 		launchHandler(mp, lp, runner, opts...)
 	case isCleanUp:
-		cleanUpHandler(mp, lp, runner, opts...)
+		cleanUpHandler(mp, lp, runner, isDryRun)
 	case isEnter:
 		enterHandler(mp, lp, runner, opts...)
+	case isDeploy:
+		deployHandler(mp, lp, runner, mt5.DeployOptions{DryRun: isDryRun, Prune: isPrune, Restart: isRestart})
+	case isCompile:
+		compileHandler(mp, lp, runner, flag.Arg(0), isWithDeploy, mt5.DeployOptions{DryRun: isDryRun, Prune: isPrune, Restart: isRestart})
+	case isBacktest:
+		backtestHandler(mp, lp, runner, flag.Arg(0))
+	case isFarm:
+		farmHandler(mp, lp, runner, flag.Arg(0))
+	case isStatus:
+		statusHandler(mp, lp, runner)
+	case isScreenshot:
+		screenshotHandler(mp, lp, runner, instance)
+	case isVncOTP:
+		vncOTPHandler(mp, lp, runner, isViewOnly)
+	case isVncHook:
+		vncHookHandler(mp, lp, runner)
+	case isReplayExport:
+		replayExportHandler(mp, lp, runner, replayFrom, replayTo, flag.Arg(0))
+	case isPrefix:
+		prefixHandler(mp, lp, runner, flag.Arg(0), flag.Arg(1), keep)
+	case isDoctor:
+		doctorHandler(mp, lp, runner)
 	}
 }
 
 func prepareHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, opts ...*bool) {
-	if !hlp.WasRunAsRoot(runner) {
-		msgPrinter.Errorfln("avly: flag 'prepare' needs to be executed as root")
-	}
+	requireCapability(msgPrinter, "prepare", capManage)
 	_, _, err := prepare(msgPrinter, logPrinter, runner)
 	if err != nil {
+		captureScreen(logPrinter, runner, "prepare-failed")
 		logPrinter.Errorfln("avly: %s", err.Error())
 	}
 }
 
 func fledgeHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, opts ...*bool) {
-	if !hlp.WasRunAsRoot(runner) {
-		msgPrinter.Errorfln("avly: flag 'fledge' needs to be executed as root")
-	}
+	requireCapability(msgPrinter, "fledge", capManage)
 	framebufferAlive, vncServerAlive, err := fledge(msgPrinter, logPrinter, runner)
 	if err != nil {
 		logPrinter.Errorfln("avly: %s", err.Error())
@@ -108,10 +181,11 @@ func fledgeHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner
 }
 
 func launchHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, opts ...*bool) {
-	if !hlp.WasRunAsRoot(runner) {
-		msgPrinter.Errorfln("avly: flag 'launch' needs to be executed as root")
-	}
+	requireCapability(msgPrinter, "launch", capManage)
 	targetProcessAlive, err := launch(msgPrinter, logPrinter, runner)
+	if err != nil || !targetProcessAlive {
+		captureScreen(logPrinter, runner, "launch-failed")
+	}
 	if err != nil {
 		logPrinter.Errorfln("avly: %s", err.Error())
 	}
@@ -120,11 +194,9 @@ func launchHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner
 	}
 }
 
-func cleanUpHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, opts ...*bool) {
-	if !hlp.WasRunAsRoot(runner) {
-		msgPrinter.Errorfln("avly: flag 'clean-up' needs to be executed as root")
-	}
-	cleanedUp, err := cleanUp(msgPrinter, logPrinter, runner)
+func cleanUpHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, dryRun bool) {
+	requireCapability(msgPrinter, "clean-up", capManage)
+	cleanedUp, err := cleanUp(msgPrinter, logPrinter, runner, dryRun)
 	if err != nil {
 		logPrinter.Errorfln("avly: %s", err.Error())
 	}
@@ -136,9 +208,7 @@ func cleanUpHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner
 }
 
 func stopHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, opts ...*bool) {
-	if !hlp.WasRunAsRoot(runner) {
-		msgPrinter.Errorfln("avly: flag 'stop' needs to be executed as root")
-	}
+	requireCapability(msgPrinter, "stop", capManage)
 	targetProcessDead, err := stop(msgPrinter, logPrinter, runner)
 	if err != nil {
 		logPrinter.Errorfln("avly: %s", err.Error())
@@ -149,9 +219,7 @@ func stopHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner if
 }
 
 func drainHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, opts ...*bool) {
-	if !hlp.WasRunAsRoot(runner) {
-		msgPrinter.Errorfln("avly: flag 'stop' needs to be executed as root")
-	}
+	requireCapability(msgPrinter, "stop", capManage)
 	vncServerDrained, err := drain(msgPrinter, logPrinter, runner)
 	if err != nil {
 		logPrinter.Errorfln("avly: %s", err.Error())
@@ -162,9 +230,7 @@ func drainHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner i
 }
 
 func enterHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, opts ...*bool) {
-	if !hlp.WasRunAsRoot(runner) {
-		msgPrinter.Errorfln("avly: flag 'enter' needs to be executed as root")
-	}
+	requireCapability(msgPrinter, "enter", capSystem)
 	logging, wine, _, _, _, err := enter(msgPrinter, logPrinter, runner)
 	if err != nil {
 		logPrinter.Errorfln("avly: %s", err.Error())
@@ -177,42 +243,45 @@ func enterHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner i
 	}
 	logPrinter.Printfln("All set. Watching...")
 
-	var issueMeter, countsUpTo1Day, countsUpTo1Week uint32
+	statusStore = status.NewStore(mt5.RuntimeDir(&env))
+	statusStore.Update(func(s *status.Status) {
+		s.Broker.Healthy = true
+	})
+	go watchJournal(logPrinter, statusStore)
+	serveControl(logPrinter)
+	startRecorder(logPrinter, runner)
+	brokerMonitor := newBrokerMonitor(logPrinter)
+	hungDetector := newHungDetector()
+	var supervisedDialogs *display.DialogHandler
+	if hlp.EnvBool(&env, "AVL_DIALOGS_SUPERVISE") {
+		supervisedDialogs = newDialogHandler(logPrinter)
+	}
+
+	// a container stop ends the terminal and its Wine session before the runtime kills everything
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	scheduler := newScheduler(logPrinter)
+	var issueMeter uint32
 	for {
-		time.Sleep(60 * time.Second)
-		countsUpTo1Day++
-		countsUpTo1Week++
-		if countsUpTo1Day >= 1440 {
-			cleanUpHandler(msgPrinter, logPrinter, runner)
-			countsUpTo1Day = 0
-		}
-		if countsUpTo1Week >= 10080 {
-			var locIssueMeter uint32
-		COPYTOBAKLOG:
-			_, _, errPrToBk := runner.RunCmdSync("cat $AVL_LOGS/avly.log >> $AVL_LOGS/avly.bak-$(date +\"%Y_%m\").log", &env)
-			if errPrToBk != nil {
-				locIssueMeter++
-				if locIssueMeter > 3 {
-					logPrinter.Printfln("avly: warn: critical: could not backup logs")
-					countsUpTo1Week = 0
-					continue
-				}
-				goto COPYTOBAKLOG
-			}
-			runner.RunCmdSync("cat /dev/null > $AVL_LOGS/avly.log", &env)
-			runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") For older logs, see: $(echo $AVL_LOGS/avly.bak-$(date +\"%Y_%m\").log) >> $AVL_LOGS/avly.log", &env)
-			countsUpTo1Week = 0
+		select {
+		case received := <-signals:
+			shutdown(msgPrinter, logPrinter, runner, received)
+			return
+		case <-time.After(60 * time.Second):
 		}
+		scheduler = runSchedule(msgPrinter, logPrinter, runner, scheduler)
+		// a planned restart stops and launches the terminal itself
+		_, restarting := tasksRunning()
 	WATCH:
-		pidTarget, _, _ := runner.RunCmdSync("pidof \"terminal64.exe\" | cut -d \" \" -f 1", &env)
-		if len(pidTarget) == 0 {
+		if !restarting && targetPid() == 0 {
 			if issueMeter >= 3 {
 				break
 			}
 			issueMeter++
 			logPrinter.Printfln("Force stop before relaunch")
-			stopHandler(msgPrinter, logPrinter, runner)
-			launchHandler(msgPrinter, logPrinter, runner)
+			captureScreen(logPrinter, runner, "relaunch")
+			relaunchTarget(msgPrinter, logPrinter, runner)
 			goto WATCH
 		}
 		pidX11, _, _ := runner.RunCmdSync("pidof \"x11vnc\" | cut -d \" \" -f 1", &env)
@@ -223,15 +292,39 @@ func enterHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner i
 			}
 			issueMeter++
 			logPrinter.Printfln("Force drain before re-fledge")
-			drainHandler(msgPrinter, logPrinter, runner)
-			fledgeHandler(msgPrinter, logPrinter, runner)
+			captureScreen(logPrinter, runner, "refledge")
+			refledgeDisplay(msgPrinter, logPrinter, runner)
 			goto WATCH
 		}
 		if issueMeter > 0 {
 			issueMeter = 0
 			logPrinter.Printfln("All set. Watching...")
 		}
+		if supervisedDialogs != nil {
+			dismissDialogs(logPrinter, runner, supervisedDialogs)
+		}
+		superviseVNC(logPrinter)
+		if restarting {
+			continue
+		}
+		superviseBroker(msgPrinter, logPrinter, runner, brokerMonitor)
+		superviseTerminal(msgPrinter, logPrinter, runner, hungDetector)
+		superviseDisplay(msgPrinter, logPrinter, runner)
+		superviseRestart(logPrinter)
+	}
+}
+
+// shutdown stops the terminal, tears down its Wine session and drains the VNC server.
+func shutdown(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, received os.Signal) {
+	logPrinter.Printfln("Shutting down on %s...", received.String())
+	hlp.AppendLog(&env, "Shutting down on %s", received.String())
+	if _, err := stop(msgPrinter, logPrinter, runner); err != nil {
+		logPrinter.Printfln("avly: warn: %s", err.Error())
+	}
+	if _, err := drain(msgPrinter, logPrinter, runner); err != nil {
+		logPrinter.Printfln("avly: warn: %s", err.Error())
 	}
+	logPrinter.Printfln("Shut down")
 }
 
 func prepare(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) (finishedWineSetup, installedExecutables bool, err error) {
@@ -239,25 +332,33 @@ func prepare(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.Cm
 	defer dq.LetDie(runner, &env)
 
 	logPrinter.Printfln("Bee preparation...")
+	stopDialogs := watchDialogs(logPrinter, runner)
+	defer stopDialogs()
 
-	// STEP 1: Setting up Wine prefix
-	err = hlp.PrepareWineprefix(runner, &env)
+	// STEP 1: Setting up Wine prefix, skipping the steps of the recipe done before
+	err = applyRecipe(logPrinter, runner)
 	if err != nil {
 		return
 	}
-	logPrinter.Printfln("prepare: step 1/2")
+	logPrinter.Printfln("prepare: step 1/3")
 	finishedWineSetup = true
 
 	// STEP 2: Install target executable(s)
-	_, pIns, errIns := runner.RunCmdAsync("wine $THIRD_PARTY/mt5setup.exe /auto", &env)
+	pIns, errIns := prefix.InstallTerminal(managed(runner), &env, mt5.InstallDir(&env), stepTimeout())
 	dq.Add(pIns)
 	if errIns != nil {
+		err = errIns
 		return
 	}
-	time.Sleep(45 * time.Second)
-	logPrinter.Printfln("prepare: step 2/2")
+	logPrinter.Printfln("prepare: step 2/3")
 	installedExecutables = true
 
+	// STEP 3: Apply registry settings
+	if err = applyRegistry(logPrinter, runner); err != nil {
+		return
+	}
+	logPrinter.Printfln("prepare: step 3/3")
+
 	_, pLgR, _ := runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") Bee is ready and set >> $AVL_LOGS/avly.log", &env)
 	dq.Add(pLgR)
 	logPrinter.Printfln("Bee preparation successful")
@@ -276,7 +377,7 @@ func fledge(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.Cmd
 	if len(xvfbPid) == 0 {
 		logPrinter.Printfln("Framebuffer is not running...")
 		runner.RunCmdSync("killall -9 \"i3*\"", &env)
-		_, _, errCmd = runner.RunCmdAsync("Xvfb $DISPLAY -screen $SCREEN_NUM $SCREEN_WHD +extension DPMS +extension GLX +extension RANDR +extension RENDER &> $AVL_LOGS/xvfb.log", &env)
+		_, _, errCmd = managed(runner).RunCmdAsync("Xvfb $DISPLAY -screen $SCREEN_NUM $SCREEN_WHD -dpi $SCREEN_DPI +extension DPMS +extension GLX +extension RANDR +extension RENDER &> $AVL_PROCESS_LOGS/xvfb.log", &env)
 		if errCmd != nil {
 			err = errCmd
 			return
@@ -293,15 +394,23 @@ func fledge(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.Cmd
 	}
 	if len(x11vncPid) == 0 {
 		logPrinter.Printfln("VNC server is not running...")
-		_, _, errCmd = runner.RunCmdAsync("x11vnc -display $DISPLAY -bg -forever -nopw -quiet -rfbport 5900 -xkb -o $AVL_LOGS/x11vnc.log", &env)
+		vncCmd, errVnc := vncCmdLine()
+		if errVnc != nil {
+			err = errVnc
+			return
+		}
+		if errAudit := vnc.EndAllSessions(&env, time.Now(), "server restarted"); errAudit != nil {
+			logPrinter.Printfln("avly: warn: could not audit VNC sessions: %s", errAudit.Error())
+		}
+		_, _, errCmd = managed(runner).RunCmdAsync(vncCmd, &env)
 		if errCmd != nil {
 			err = errCmd
 			return
 		}
-		runner.RunCmdSync("xset -dpms", &env)
-		runner.RunCmdSync("xset s noblank", &env)
-		runner.RunCmdSync("xset s off", &env)
-		_, _, errCmd = runner.RunCmdAsync("i3 &> $AVL_LOGS/i3.log", &env)
+		managed(runner).RunCmdSync("xset -dpms", &env)
+		managed(runner).RunCmdSync("xset s noblank", &env)
+		managed(runner).RunCmdSync("xset s off", &env)
+		_, _, errCmd = managed(runner).RunCmdAsync("i3 &> $AVL_PROCESS_LOGS/i3.log", &env)
 		if errCmd != nil {
 			err = errCmd
 			return
@@ -314,32 +423,78 @@ func fledge(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.Cmd
 	return
 }
 
+// targetPid returns the pid of the live terminal, the terminal64.exe bound to WINEPREFIX, or 0 if it
+// does not run. Tester instances run in prefixes of their own and are never taken for it.
+func targetPid() int {
+	procs, _ := prefix.SessionProcesses("/proc", hlp.EnvValue(&env, "WINEPREFIX"))
+	for _, proc := range procs {
+		if strings.EqualFold(proc.Name, mt5.TerminalExecutable) {
+			return proc.Pid
+		}
+	}
+
+	return 0
+}
+
 func launch(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) (isTargetProcessRunning bool, err error) {
 	var tcfErr error
 
 	hlp.GetTCF(
 		func() {
 			// Check for running instances
-			targetPid, _ := runner.PanicCmdSync("pidof \"terminal64.exe\" | cut -d \" \" -f 1", &env)
-			if len(targetPid) > 0 {
+			if targetPid() > 0 {
 				logPrinter.Printfln("Target process is running")
 				isTargetProcessRunning = true
 				return
 			}
 
+			if failures := launchFailures(); failures > 0 {
+				// repairing may rebuild the prefix, so it is left to 'avly -prefix repair'
+				logPrinter.Printfln("Previous launch failed %d time(s), check Wine prefix...", failures)
+				checkPrefix(msgPrinter, logPrinter, runner, false)
+			}
+
+			// Broker login from secret files (never passed on the command line)
+			creds, hasCreds, errCreds := mt5.LoadCredentials(&env)
+			if errCreds != nil {
+				panic(errCreds)
+			}
+			var configArg string
+			if hasCreds {
+				iniPath, errIni := mt5.WriteStartupIni(&env, creds)
+				defer mt5.RemoveStartupIni(&env)
+				if errIni != nil {
+					panic(errIni)
+				}
+				configArg = fmt.Sprintf(" '/config:%s'", hlp.WinePath(iniPath))
+			}
+			journalOffset := mt5.JournalSize(&env)
+			if errDPI := hlp.SetWineDPI(managed(runner), &env, conf.DisplayOf(config.LiveInstance).DPI); errDPI != nil {
+				logPrinter.Printfln("avly: warn: could not set DPI of the Wine prefix: %s", errDPI.Error())
+			}
+			stopDialogs := watchDialogs(logPrinter, runner)
+			defer stopDialogs()
+			attempts := hlp.EnvInt(&env, "AVL_LAUNCH_ATTEMPTS", defaultLaunchAttempts)
+
 		TARGETRUN:
 			// Launch a new instance
 			logPrinter.Printfln("Target process is not running...")
-			runner.PanicCmdAsync("wine $WINEPREFIX/dosdevices/c\\:/Program\\ Files/MetaTrader\\ 5/terminal64.exe /portable &> $AVL_LOGS/target.log", &env)
+			managed(runner).PanicCmdAsync("wine $WINEPREFIX/dosdevices/c\\:/Program\\ Files/MetaTrader\\ 5/terminal64.exe /portable"+configArg+" &> $AVL_PROCESS_LOGS/target.log", &env)
 			time.Sleep(30 * time.Second)
-			targetPid, _ = runner.PanicCmdSync("pidof \"terminal64.exe\" | cut -d \" \" -f 1", &env)
-			if len(targetPid) > 0 {
+			if targetPid() > 0 {
 				runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") Launched target executable >> $AVL_LOGS/avly.log", &env)
 				logPrinter.Printfln("Target process is running")
 				isTargetProcessRunning = true
+				if hasCreds {
+					awaitBrokerLogin(logPrinter, runner, journalOffset)
+				}
 				return
 			}
-			goto TARGETRUN
+			attempts--
+			if attempts > 0 {
+				goto TARGETRUN
+			}
+			tcfErr = errors.New("launch error: target executable did not start")
 
 		},
 		func(caught error) {
@@ -352,70 +507,103 @@ func launch(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.Cmd
 	} else {
 		logPrinter.Printfln("Target process: OK")
 	}
+	recordLaunch(isTargetProcessRunning)
 
 	return
 }
 
-func cleanUp(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) (cleanedUp bool, err error) {
-	var dq hlp.ProcDeathQueue
-	defer dq.LetDie(runner, &env)
+// awaitBrokerLogin watches the terminal journal until the login from the startup ini was answered.
+// The caller removes the startup ini afterwards, no matter the outcome.
+func awaitBrokerLogin(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, journalOffset int64) {
+	deadline := time.Now().Add(2 * time.Minute)
+	for time.Now().Before(deadline) {
+		state, errJournal := mt5.LastLoginState(&env, journalOffset)
+		if errJournal != nil {
+			logPrinter.Printfln("avly: warn: could not read terminal journal: %s", errJournal.Error())
+		}
+		switch state {
+		case mt5.LoginAuthorized:
+			runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") Broker login authorized >> $AVL_LOGS/avly.log", &env)
+			logPrinter.Printfln("Broker login: OK")
+			return
+		case mt5.LoginFailed:
+			runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") Broker login failed >> $AVL_LOGS/avly.log", &env)
+			logPrinter.Printfln("avly: warn: broker login failed, check the credential secrets")
+			return
+		}
+		time.Sleep(5 * time.Second)
+	}
+	logPrinter.Printfln("avly: warn: broker login was not confirmed in time")
+}
 
+// cleanUp applies the cleanup rules of the config file to the terminal data dir. A dry run only
+// prints what would be disposed of.
+func cleanUp(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, dryRun bool) (cleanedUp bool, err error) {
 	logPrinter.Printfln("Clean up...")
 
-	var tcfError error
-	hlp.GetTCF(
-		func() {
-			_, pRmLg := runner.PanicCmdSync("rm -rf $PREFIX/dosdevices/c\\:/Program\\ Files/MetaTrader\\ 5/logs/*", &env)
-			dq.Add(pRmLg)
-			_, pRmHs := runner.PanicCmdSync("rm -rf $PREFIX/dosdevices/c\\:/Program\\ Files/MetaTrader\\ 5/history/*", &env)
-			dq.Add(pRmHs)
-			_, pRmCs := runner.PanicCmdSync("rm -rf $PREFIX/dosdevices/c\\:/Program\\ Files/MetaTrader\\ 5/*.csv", &env)
-			dq.Add(pRmCs)
-		},
-		func(caught error) {
-			tcfError = caught
-			_, logProc, _ := runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") Problems during cleanup >> $AVL_LOGS/avly.log", &env)
-			dq.Add(logProc)
-		},
-		func() {
-			if tcfError == nil {
-				_, logProc, _ := runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") Cleaned up >> $AVL_LOGS/avly.log", &env)
-				dq.Add(logProc)
+	engine := cleanup.Engine{DataDir: mt5.InstallDir(&env), Roots: conf.Cleanup.Roots, Now: time.Now()}
+	results, err := engine.Run(conf.Cleanup, dryRun)
+	var freed int64
+	for _, result := range results {
+		freed += result.Bytes
+		if dryRun {
+			for _, item := range result.Items {
+				msgPrinter.Printfln("%s: would %s %s (%d bytes)", result.Rule.Name, result.Rule.Action, item.Rel, item.Size)
 			}
-			dq.LetDie(runner, &env)
-		},
-	).Run()
-	err = tcfError
+			if len(result.Archive) > 0 {
+				msgPrinter.Printfln("%s: would write %s", result.Rule.Name, result.Archive)
+			}
+		} else if len(result.Items) > 0 {
+			hlp.AppendLog(&env, "Cleanup %s: %s %d item(s), %d bytes", result.Rule.Name, result.Rule.Action, len(result.Items), result.Bytes)
+		}
+	}
+	if dryRun {
+		msgPrinter.Printfln("would free %d bytes", freed)
+	} else if err != nil {
+		hlp.AppendLog(&env, "Problems during cleanup: %s", err.Error())
+	} else {
+		hlp.AppendLog(&env, "Cleaned up, freed %d bytes", freed)
+	}
+	cleanedUp = err == nil
 
 	return
 }
 
+// defaultStopTimeout bounds waiting for the target process to exit on SIGTERM unless
+// AVL_STOP_TIMEOUT says otherwise
+const defaultStopTimeout = 30 * time.Second
+
 func stop(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) (targetProcessDead bool, err error) {
 	var dq hlp.ProcDeathQueue
 	defer dq.LetDie(runner, &env)
 
 	logPrinter.Printfln("Stop target process(es)...")
 
-	var targetProcessNames, targetPids []string
-	targetProcessNames = append(targetProcessNames, "terminal64.exe")
+	// only the live terminal, tester instances keep running; a frozen one may ignore SIGTERM
+	timeout := hlp.EnvDuration(&env, "AVL_STOP_TIMEOUT", defaultStopTimeout)
+	deadline := time.Now().Add(timeout)
 	for {
-		targetPids = nil
-		for targetProcCount := 0; targetProcCount < len(targetProcessNames); targetProcCount++ {
-			pid, pidOfProc, _ := runner.RunCmdSync(fmt.Sprintf("pidof \"%s\" | cut -d \" \" -f 1", targetProcessNames[targetProcCount]), &env)
-			dq.Add(pidOfProc)
-			if len(pid) > 0 {
-				targetPids = append(targetPids, pid)
-			}
-		}
-		if targetPids == nil {
+		pid := targetPid()
+		if pid == 0 {
 			targetProcessDead = true
 			break
 		}
-		for targetPidCount := 0; targetPidCount < len(targetPids); targetPidCount++ {
-			_, pkillProc, _ := runner.RunCmdSync(fmt.Sprintf("pkill -15 \"%s\"", targetPids[targetPidCount]), &env)
-			dq.Add(pkillProc)
+		if time.Now().After(deadline) {
+			logPrinter.Printfln("avly: warn: target process did not exit within %s, killing it", timeout)
+			hlp.AppendLog(&env, "Killed target process (pid %d), it did not exit within %s", pid, timeout)
+			_, killProc, _ := runner.RunCmdSync(fmt.Sprintf("kill -9 %d", pid), &env)
+			dq.Add(killProc)
+			time.Sleep(time.Second)
+			// what survives is left to the teardown of the Wine session
+			targetProcessDead = targetPid() == 0
+			break
 		}
+		_, killProc, _ := runner.RunCmdSync(fmt.Sprintf("kill -15 %d", pid), &env)
+		dq.Add(killProc)
+		time.Sleep(time.Second)
 	}
+	// the wineserver and Wine's services otherwise keep holding the prefix, failing relaunches
+	teardownWine(logPrinter, runner)
 	_, logProc, _ := runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") Stopped target process(es) >> $AVL_LOGS/avly.log", &env)
 	dq.Add(logProc)
 	logPrinter.Printfln("Stopped target process(es)")
@@ -463,13 +651,16 @@ func enter(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdR
 	logPrinter.Printfln("Start initialization...")
 
 	// STEP 1: Prepare logging
+	if err = handOver(); err != nil {
+		return
+	}
 	_, pPrLg, errAvLog := runner.RunCmdSync("cat /dev/null > $AVL_LOGS/avly.log", &env)
 	dq.Add(pPrLg)
 	if errAvLog != nil {
 		err = errAvLog
 		return
 	}
-	logPrinter.Printfln("enter: step 1/5")
+	logPrinter.Printfln("enter: step 1/6")
 	enabledLogging = true
 
 	// STEP 2: Install Wine
@@ -477,22 +668,40 @@ func enter(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdR
 	if errWineInstall != nil {
 		return
 	}
-	logPrinter.Printfln("enter: step 2/5")
+	logPrinter.Printfln("enter: step 2/6")
 	installedWine = true
 
 	// STEP 3: Fledge VNC server
 	fledgeHandler(msgPrinter, logPrinter, runner)
-	logPrinter.Printfln("enter: step 3/5")
+	logPrinter.Printfln("enter: step 3/6")
 	isFledged = true
 
-	// STEP 4: Prepare bee
-	prepareHandler(msgPrinter, logPrinter, runner)
-	logPrinter.Printfln("enter: step 4/5")
+	// STEP 4: Prepare bee, unless seeded from a prefix snapshot
+	if !seedPrefix(logPrinter, runner) {
+		prepareHandler(msgPrinter, logPrinter, runner)
+	} else {
+		// the snapshot may predate steps of the recipe or registry settings
+		if errRecipe := applyRecipe(logPrinter, runner); errRecipe != nil {
+			logPrinter.Printfln("avly: warn: could not complete the Wine prefix recipe: %s", errRecipe.Error())
+		}
+		if errRegistry := applyRegistry(logPrinter, runner); errRegistry != nil {
+			logPrinter.Printfln("avly: warn: could not apply registry settings: %s", errRegistry.Error())
+		}
+	}
+	logPrinter.Printfln("enter: step 4/6")
 	isPrepared = true
 
-	// STEP 5: Initial target launch
+	// STEP 5: Deploy MQL5 artifacts (optional)
+	if _, errStat := os.Stat(hlp.EnvValue(&env, "AVL_MQL5_SOURCE")); errStat == nil {
+		if _, errDeploy := deploy(msgPrinter, logPrinter, hlp.EnvValue(&env, "AVL_MQL5_SOURCE"), mt5.DeployOptions{}); errDeploy != nil {
+			logPrinter.Printfln("avly: warn: could not deploy MQL5 artifacts: %s", errDeploy.Error())
+		}
+	}
+	logPrinter.Printfln("enter: step 5/6")
+
+	// STEP 6: Initial target launch
 	launchHandler(msgPrinter, logPrinter, runner)
-	logPrinter.Printfln("enter: step 5/5")
+	logPrinter.Printfln("enter: step 6/6")
 	isLaunched = true
 
 	_, pLgW, _ := runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") Bee is now working >> $AVL_LOGS/avly.log", &env)
@@ -0,0 +1,124 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+)
+
+func backtestHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, specPath string) {
+	requireCapability(msgPrinter, "backtest", capManage)
+	report, err := backtest(msgPrinter, logPrinter, runner, specPath)
+	if err != nil {
+		logPrinter.Errorfln("avly: %s", err.Error())
+	}
+	summary, _ := json.MarshalIndent(report, "", "  ")
+	msgPrinter.Printfln("%s", string(summary))
+}
+
+// backtest runs a tester spec on a dedicated tester instance. The live trading instance is never touched.
+func backtest(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, specPath string) (report mt5.TesterReport, err error) {
+	if len(specPath) == 0 {
+		err = errors.New("backtest error: path of a tester spec (JSON) is required")
+		return
+	}
+	spec, err := mt5.LoadTesterSpec(specPath)
+	if err != nil {
+		return
+	}
+	creds, hasCreds, err := mt5.LoadCredentials(&env)
+	if err != nil {
+		return
+	}
+	var credsPtr *mt5.Credentials
+	if hasCreds {
+		credsPtr = &creds
+	}
+
+	logPrinter.Printfln("Prepare tester instance...")
+	instance, err := mt5.PrepareTesterInstance(&env, "backtest", hlp.FreeDisplay(mt5.TesterFirstDisplay))
+	if err != nil {
+		return
+	}
+	logPrinter.Printfln("Run backtest of %s on %s %s...", spec.Expert, spec.Symbol, spec.Period)
+	if report, err = mt5.RunBacktest(managed(runner), &env, instance, spec, credsPtr); err != nil {
+		return
+	}
+	hlp.AppendLog(&env, "Backtested %s on %s %s: net profit %.2f, %d trade(s)", spec.Expert, spec.Symbol, spec.Period, report.NetProfit, report.Trades)
+	logPrinter.Printfln("Backtest: OK")
+
+	return
+}
+
+func farmHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, specPath string) {
+	requireCapability(msgPrinter, "farm", capManage)
+	failed, err := farm(msgPrinter, logPrinter, runner, specPath)
+	if err != nil {
+		logPrinter.Errorfln("avly: %s", err.Error())
+	}
+	if failed > 0 {
+		logPrinter.Errorfln("avly: %d tester job(s) failed", failed)
+	}
+}
+
+// farm runs a matrix of tester jobs on concurrent tester instances. Calling it again with the same
+// spec resumes the jobs which are not done yet.
+func farm(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, specPath string) (failed int, err error) {
+	if len(specPath) == 0 {
+		err = errors.New("farm error: path of a farm spec (JSON) is required")
+		return
+	}
+	spec, err := mt5.LoadFarmSpec(specPath)
+	if err != nil {
+		return
+	}
+	creds, hasCreds, err := mt5.LoadCredentials(&env)
+	if err != nil {
+		return
+	}
+	var credsPtr *mt5.Credentials
+	if hasCreds {
+		credsPtr = &creds
+	}
+	testerFarm, err := mt5.OpenFarm(&env, spec)
+	if err != nil {
+		return
+	}
+
+	var open int
+	for _, job := range testerFarm.Jobs {
+		if job.State != mt5.JobDone {
+			open++
+		}
+	}
+	instances := spec.Concurrency()
+	logPrinter.Printfln("Run %d of %d tester job(s) on %d instance(s)...", open, len(testerFarm.Jobs), instances)
+	err = testerFarm.Run(managed(runner), &env, instances, credsPtr, func(job *mt5.FarmJob) {
+		if job.State == mt5.JobFailed {
+			logPrinter.Printfln("avly: warn: job %s failed: %s", job.ID, job.Error)
+			return
+		}
+		logPrinter.Printfln("Job %s: net profit %.2f, %d trade(s)", job.ID, job.Report.NetProfit, job.Report.Trades)
+	})
+	if err != nil {
+		return
+	}
+	for _, job := range testerFarm.Jobs {
+		if job.State == mt5.JobFailed {
+			failed++
+		}
+	}
+	hlp.AppendLog(&env, "Tester farm %s finished: %d job(s), %d failed", spec.Name, len(testerFarm.Jobs), failed)
+	logPrinter.Printfln("Summary: %s", testerFarm.Dir())
+
+	return
+}
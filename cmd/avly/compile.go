@@ -0,0 +1,94 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+)
+
+func compileHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, target string, withDeploy bool, deployOpts mt5.DeployOptions) {
+	requireCapability(msgPrinter, "compile", capManage)
+	result, err := compile(msgPrinter, logPrinter, runner, target)
+	if err != nil {
+		logPrinter.Errorfln("avly: %s", err.Error())
+	}
+	for _, msg := range result.Messages {
+		msgPrinter.Printfln("%s", msg.String())
+	}
+	if result.Errors > 0 {
+		logPrinter.Errorfln("avly: compilation failed: %d error(s), %d warning(s)", result.Errors, result.Warnings)
+	}
+	logPrinter.Printfln("Compile: OK (%d warning(s))", result.Warnings)
+
+	if withDeploy {
+		expertsChanged, errDeploy := deploy(msgPrinter, logPrinter, hlp.EnvValue(&env, "AVL_MQL5_BUILD"), deployOpts)
+		if errDeploy != nil {
+			logPrinter.Errorfln("avly: %s", errDeploy.Error())
+		}
+		restartOnExpertChange(msgPrinter, logPrinter, runner, expertsChanged, deployOpts)
+	}
+}
+
+// compile mirrors $AVL_MQL5_SOURCE into $AVL_MQL5_BUILD (the source may be mounted read-only) and lets
+// MetaEditor compile target, a file or folder relative to the source tree. An empty target compiles everything.
+func compile(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, target string) (result mt5.CompileResult, err error) {
+	sourceDir, buildDir := hlp.EnvValue(&env, "AVL_MQL5_SOURCE"), hlp.EnvValue(&env, "AVL_MQL5_BUILD")
+	if _, errStat := os.Stat(sourceDir); errStat != nil {
+		err = errors.New("compile error: MQL5 source folder " + sourceDir + " is not mounted")
+		return
+	}
+	if strings.HasPrefix(filepath.Clean(target), "..") || filepath.IsAbs(target) {
+		err = errors.New("compile error: target needs to be relative to the MQL5 source folder")
+		return
+	}
+	if _, errStat := os.Stat(filepath.Join(sourceDir, target)); errStat != nil {
+		err = errors.New("compile error: target " + target + " does not exist")
+		return
+	}
+
+	logPrinter.Printfln("Compile MQL5 sources...")
+	if err = os.MkdirAll(buildDir, 0755); err != nil {
+		return
+	}
+	mirror, err := mt5.PlanDeploy(sourceDir, buildDir, true)
+	if err != nil {
+		return
+	}
+	if err = mirror.Apply(func(mt5.Change) {}); err != nil {
+		return
+	}
+	// MetaEditor writes its output next to the sources
+	if err = handOverTree(buildDir); err != nil {
+		return
+	}
+
+	// MetaEditor needs Wine's display
+	if _, _, err = fledge(msgPrinter, logPrinter, runner); err != nil {
+		return
+	}
+
+	logPath := filepath.Join(buildDir, "avly-compile.log")
+	os.Remove(logPath)
+	// MetaEditor's exit code does not tell about the result, the log does
+	managed(runner).RunCmdSync(mt5.CompileCmdLine(&env, filepath.Join(buildDir, target), buildDir, logPath)+" >> $AVL_PROCESS_LOGS/wine.log 2>&1", &env)
+	raw, errLog := os.ReadFile(logPath)
+	if errLog != nil {
+		err = errors.New("compile error: MetaEditor did not write a log")
+		return
+	}
+	result = mt5.ParseCompileLog(mt5.DecodeText(raw), buildDir)
+	hlp.AppendLog(&env, "Compiled %s: %d error(s), %d warning(s)", filepath.Join(sourceDir, target), result.Errors, result.Warnings)
+
+	return
+}
@@ -0,0 +1,31 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/9tmark/avly-trader/internal/config"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+// conf is the config file of $AVL_CONFIG, loaded at startup and reloaded by the supervisor.
+var conf config.Config
+
+// loadConfig reads the config file and exports the live display geometry to the commands' environment.
+func loadConfig() (err error) {
+	loaded, err := config.Load(&env)
+	if err != nil {
+		return
+	}
+	conf = loaded
+	geometry := conf.DisplayOf(config.LiveInstance)
+	hlp.SetEnvValue(&env, "SCREEN_WHD", geometry.WHD())
+	hlp.SetEnvValue(&env, "SCREEN_DPI", strconv.Itoa(geometry.DPI))
+
+	return
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/9tmark/avly-trader/internal/control"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/webvnc"
+)
+
+// serveControl runs the control API on AVL_HTTP_ADDR, protected by the token in AVL_HTTP_TOKEN_FILE
+// (default $AVL_SECRETS_DIR/avly_http_token). Without token, it only listens on loopback.
+func serveControl(logPrinter ifc.MsgPrinter) *control.Server {
+	server := &control.Server{Addr: hlp.EnvValue(&env, "AVL_HTTP_ADDR"), Env: &env, Store: statusStore}
+	if len(server.Addr) == 0 {
+		server.Addr = control.DefaultAddr
+	}
+	token, err := control.LoadToken(&env)
+	if err != nil {
+		logPrinter.Printfln("avly: warn: %s", err.Error())
+	}
+	server.Token = token
+	if len(token) == 0 && !control.IsLoopback(server.Addr) {
+		logPrinter.Printfln("avly: warn: control API needs a token to listen on %s, falling back to %s", server.Addr, control.DefaultAddr)
+		server.Addr = control.DefaultAddr
+	}
+	if conf.VNC.Web.Enabled {
+		bridge, errBridge := newWebBridge(logPrinter)
+		if errBridge != nil {
+			logPrinter.Printfln("avly: warn: web VNC disabled: %s", errBridge.Error())
+		} else {
+			server.HandlePublic("/vnc/", http.StripPrefix("/vnc/", webvnc.Client()))
+			server.Handle("/vnc/websockify", bridge)
+		}
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			logPrinter.Printfln("avly: warn: control API stopped: %s", err.Error())
+		}
+	}()
+
+	return server
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+)
+
+func deployHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, deployOpts mt5.DeployOptions) {
+	requireCapability(msgPrinter, "deploy", capManage)
+	expertsChanged, err := deploy(msgPrinter, logPrinter, hlp.EnvValue(&env, "AVL_MQL5_SOURCE"), deployOpts)
+	if err != nil {
+		logPrinter.Errorfln("avly: %s", err.Error())
+	}
+	restartOnExpertChange(msgPrinter, logPrinter, runner, expertsChanged, deployOpts)
+}
+
+func restartOnExpertChange(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, expertsChanged bool, deployOpts mt5.DeployOptions) {
+	if !expertsChanged || !deployOpts.Restart || deployOpts.DryRun {
+		return
+	}
+	logPrinter.Printfln("Attached expert changed, restart target process")
+	stopHandler(msgPrinter, logPrinter, runner)
+	launchHandler(msgPrinter, logPrinter, runner)
+}
+
+// deploy syncs sourceDir ($AVL_MQL5_SOURCE or the compile output) into the MQL5 folder of the terminal.
+// expertsChanged tells whether the binary of an expert attached to a chart was touched.
+func deploy(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, sourceDir string, deployOpts mt5.DeployOptions) (expertsChanged bool, err error) {
+	if _, errStat := os.Stat(sourceDir); errStat != nil {
+		err = errors.New("deploy error: MQL5 source folder " + sourceDir + " is not mounted")
+		return
+	}
+
+	logPrinter.Printfln("Deploy MQL5 artifacts...")
+	plan, err := mt5.PlanDeploy(sourceDir, mt5.Mql5Dir(&env), deployOpts.Prune)
+	if err != nil {
+		return
+	}
+	if len(plan.Changes) == 0 {
+		logPrinter.Printfln("Deploy: nothing changed")
+		return
+	}
+
+	if deployOpts.DryRun {
+		for _, change := range plan.Changes {
+			msgPrinter.Printfln("%s", change.String())
+		}
+		logPrinter.Printfln("Deploy: %d change(s) (dry run)", len(plan.Changes))
+		return
+	}
+
+	err = plan.Apply(func(change mt5.Change) {
+		logPrinter.Printfln("%s", change.String())
+		hlp.AppendLog(&env, "Deployed %s", change.String())
+	})
+	if err != nil {
+		return
+	}
+	logPrinter.Printfln("Deploy: %d change(s)", len(plan.Changes))
+
+	attached, errAttached := mt5.AttachedExperts(&env)
+	if errAttached != nil {
+		logPrinter.Printfln("avly: warn: could not read chart profiles: %s", errAttached.Error())
+	}
+	for _, changed := range plan.ChangedExperts() {
+		for _, running := range attached {
+			if strings.EqualFold(changed, running) {
+				expertsChanged = true
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"time"
+
+	"github.com/9tmark/avly-trader/internal/display"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+// newDialogHandler combines the rules of AVL_DIALOG_RULES (optional JSON file) with the built-in ones.
+// Invalid custom rules are skipped with a warning, so bootstrap never depends on them.
+func newDialogHandler(logPrinter ifc.MsgPrinter) *display.DialogHandler {
+	var rules []display.DialogRule
+	if path := hlp.EnvValue(&env, "AVL_DIALOG_RULES"); len(path) > 0 {
+		custom, err := display.LoadDialogRules(path)
+		if err != nil {
+			logPrinter.Printfln("avly: warn: %s", err.Error())
+		}
+		if _, err = display.NewDialogHandler(custom); err != nil {
+			logPrinter.Printfln("avly: warn: %s, ignoring custom dialog rules", err.Error())
+			custom = nil
+		}
+		rules = append(rules, custom...)
+	}
+	handler, _ := display.NewDialogHandler(append(rules, display.BuiltinDialogRules...))
+
+	return handler
+}
+
+// dismissDialogs handles the known dialogs currently shown and logs each dismissal.
+func dismissDialogs(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, handler *display.DialogHandler) {
+	dismissals, err := handler.Dismiss(runner, &env)
+	if err != nil {
+		logPrinter.Printfln("avly: warn: could not inspect windows: %s", err.Error())
+		return
+	}
+	for _, dismissal := range dismissals {
+		if dismissal.Err != nil {
+			logPrinter.Printfln("avly: warn: could not dismiss dialog %q: %s", dismissal.Window.Title, dismissal.Err.Error())
+			hlp.AppendLog(&env, "Failed to dismiss dialog %q by rule %s (screenshot: %s)", dismissal.Window.Title, dismissal.Rule, dismissal.Screenshot)
+			continue
+		}
+		logPrinter.Printfln("Dismissed dialog %q (%s)", dismissal.Window.Title, dismissal.Rule)
+		hlp.AppendLog(&env, "Dismissed dialog %q by rule %s (screenshot: %s)", dismissal.Window.Title, dismissal.Rule, dismissal.Screenshot)
+	}
+}
+
+// watchDialogs dismisses known dialogs every few seconds until the returned stop function is called.
+func watchDialogs(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) (stop func()) {
+	handler := newDialogHandler(logPrinter)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(3 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				dismissDialogs(logPrinter, runner, handler)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
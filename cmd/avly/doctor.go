@@ -0,0 +1,89 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/9tmark/avly-trader/internal/config"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/prefix"
+)
+
+// doctorHandler diagnoses the installation without changing it: the Wine prefix, its recipe and the
+// registry settings of the config file. It exits non-zero if anything needs attention.
+func doctorHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) {
+	requireCapability(msgPrinter, "doctor", capManage)
+	problems := 0
+	report := func(area string, findings []string) {
+		if len(findings) == 0 {
+			msgPrinter.Printfln("%s: OK", area)
+			return
+		}
+		for _, finding := range findings {
+			msgPrinter.Printfln("%s: %s", area, finding)
+		}
+		problems += len(findings)
+	}
+
+	msgPrinter.Printfln("config: %s", config.Path(&env))
+
+	var prefixFindings []string
+	for _, issue := range newPrefixChecker(runner).Check() {
+		prefixFindings = append(prefixFindings, issue.String())
+	}
+	report("prefix", prefixFindings)
+
+	var recipeFindings []string
+	pending, err := conf.Wine.Recipe.Pending(hlp.EnvValue(&env, "WINEPREFIX"))
+	if err != nil {
+		recipeFindings = append(recipeFindings, err.Error())
+	}
+	for _, step := range pending {
+		recipeFindings = append(recipeFindings, fmt.Sprintf("%s (%s) is pending, run 'avly -prepare'", step.Name, step.Spec))
+	}
+	report("recipe", recipeFindings)
+
+	drifts, err := registryDrift()
+	var registryFindings []string
+	if err != nil {
+		registryFindings = append(registryFindings, err.Error())
+	}
+	for _, drift := range drifts {
+		registryFindings = append(registryFindings, drift.String())
+	}
+	report("registry", registryFindings)
+
+	if problems > 0 {
+		msgPrinter.Errorfln("avly: doctor found %d problem(s)", problems)
+	}
+}
+
+// registryDrift compares the registry settings of the config file and its .reg files with the hives
+// of the prefix. The wineserver writes the hives lazily, so changes of a running terminal may show late.
+func registryDrift() (drifts []prefix.Drift, err error) {
+	desired, err := conf.Wine.DesiredState(&env)
+	if err != nil {
+		return
+	}
+
+	return prefix.DetectDrift(hlp.EnvValue(&env, "WINEPREFIX"), desired)
+}
+
+// applyRegistry writes the registry settings which drifted into the prefix.
+func applyRegistry(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) error {
+	applied, err := prefix.ApplyRegistry(managed(runner), &env, conf.Wine)
+	for _, setting := range applied {
+		hlp.AppendLog(&env, "Applied registry setting: %s", setting)
+	}
+	if len(applied) > 0 {
+		logPrinter.Printfln("Applied %d registry setting(s)", len(applied))
+	}
+
+	return err
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"strconv"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// watchJournal tails the journal and expert log of the live terminal for the lifetime of the process.
+func watchJournal(logPrinter ifc.MsgPrinter, store *status.Store) {
+	tailer := mt5.NewJournalTailer(&env)
+	for {
+		time.Sleep(5 * time.Second)
+		events, err := tailer.Poll()
+		if err != nil {
+			logPrinter.Printfln("avly: warn: could not read terminal logs: %s", err.Error())
+		}
+		for _, event := range events {
+			recordJournalEvent(logPrinter, store, event)
+		}
+	}
+}
+
+// recordJournalEvent puts a journal event into avly's logs and the status document, whose counters
+// the control API serves as metrics.
+func recordJournalEvent(logPrinter ifc.MsgPrinter, store *status.Store, event mt5.Event) {
+	line := string(event.Kind) + " [" + event.Source + "] " + event.Detail
+	if event.Retcode > 0 {
+		line += " (retcode " + strconv.Itoa(event.Retcode) + ")"
+	}
+	logPrinter.Printfln("Journal: %s", line)
+	hlp.AppendLog(&env, "Journal: %s", line)
+
+	store.Update(func(s *status.Status) {
+		j := &s.Journal
+		j.AddEvent(status.Event{Time: event.Time, Kind: string(event.Kind), Source: event.Source, Detail: event.Detail, Retcode: event.Retcode})
+		switch event.Kind {
+		case mt5.EventAuthorized, mt5.EventConnectionRestored:
+			j.Connected, j.ConnectionKnown = true, true
+		case mt5.EventConnectionLost, mt5.EventAuthFailed:
+			j.Connected, j.ConnectionKnown = false, true
+		case mt5.EventAutoTradingEnabled:
+			j.AutoTrading = "enabled"
+		case mt5.EventAutoTradingDisabled:
+			j.AutoTrading = "disabled"
+		case mt5.EventExpertLoaded:
+			j.LoadedExperts = append(removeString(j.LoadedExperts, event.Detail), event.Detail)
+		case mt5.EventExpertRemoved:
+			j.LoadedExperts = removeString(j.LoadedExperts, event.Detail)
+		case mt5.EventTradeExecuted, mt5.EventOrderFailed:
+			if j.LastTrade == nil || event.Time.After(*j.LastTrade) {
+				at := event.Time
+				j.LastTrade = &at
+			}
+		}
+	})
+}
+
+func removeString(list []string, value string) (result []string) {
+	for _, item := range list {
+		if item != value {
+			result = append(result, item)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// deferrals are the disruptive actions of the watching process waiting for a maintenance window,
+// keyed by name
+var deferrals = map[string]status.DeferredAction{}
+
+// maintenanceAllowed tells whether the disruptive action name may run now, i.e. one of the maintenance
+// windows named (all if none) is open. Otherwise the action is reported as deferred until the next
+// window opens. Crash recovery does not ask.
+func maintenanceAllowed(logPrinter ifc.MsgPrinter, name, reason string, windows []string) bool {
+	allowed, next := conf.Schedule.WithDefaults().Windows.Allow(time.Now(), windows)
+	if !allowed {
+		deferAction(logPrinter, name, reason+", outside of maintenance windows", next)
+	}
+
+	return allowed
+}
+
+// deferAction reports the action name as deferred until (zero if unknown).
+func deferAction(logPrinter ifc.MsgPrinter, name, reason string, until time.Time) {
+	deferral, known := deferrals[name]
+	if !known || deferral.Reason != reason {
+		when := "unknown"
+		if !until.IsZero() {
+			when = until.Format(time.RFC3339)
+		}
+		logPrinter.Printfln("Defer %s (%s) until %s", name, reason, when)
+		hlp.AppendLog(&env, "Deferred %s (%s) until %s", name, reason, when)
+	}
+	if !known {
+		deferral = status.DeferredAction{Name: name, Since: time.Now()}
+	}
+	deferral.Reason, deferral.Until = reason, nil
+	if !until.IsZero() {
+		deferral.Until = &until
+	}
+	deferrals[name] = deferral
+	reportDeferrals()
+}
+
+// runDeferred forgets the action name which is about to run, noting if it was deferred.
+func runDeferred(name string) {
+	if deferral, known := deferrals[name]; known {
+		hlp.AppendLog(&env, "Running %s, deferred since %s", name, deferral.Since.Format(time.RFC3339))
+	}
+	clearDeferral(name)
+}
+
+// clearDeferral forgets the deferred action name, e.g. once it is no longer needed.
+func clearDeferral(name string) {
+	if _, known := deferrals[name]; !known {
+		return
+	}
+	delete(deferrals, name)
+	reportDeferrals()
+}
+
+func reportDeferrals() {
+	if statusStore == nil {
+		return
+	}
+	deferred := make([]status.DeferredAction, 0, len(deferrals))
+	for _, deferral := range deferrals {
+		deferred = append(deferred, deferral)
+	}
+	sort.Slice(deferred, func(i, j int) bool { return deferred[i].Since.Before(deferred[j].Since) })
+	statusStore.Update(func(s *status.Status) {
+		s.Deferred = deferred
+	})
+}
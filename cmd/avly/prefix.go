@@ -0,0 +1,334 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+	"github.com/9tmark/avly-trader/internal/prefix"
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// defaultSnapshotsKept is the number of snapshots 'avly -prefix prune' keeps without -keep
+const defaultSnapshotsKept = 3
+
+func prefixHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, command, name string, keep int) {
+	dir := prefix.SnapshotDir(&env)
+	switch command {
+	case "list":
+		snapshots, err := prefix.ListSnapshots(dir)
+		if err != nil {
+			msgPrinter.Errorfln("avly: %s", err.Error())
+		}
+		for _, snapshot := range snapshots {
+			msgPrinter.Printfln("%s  %s  %s  build %s  %d MB", snapshot.Name, snapshot.Created.Format(time.RFC3339), snapshot.WineVersion, snapshot.MT5Build, snapshot.Size>>20)
+		}
+	case "snapshot":
+		requireCapability(msgPrinter, "prefix snapshot", capSystem)
+		if err := quiescePrefix(runner); err != nil {
+			msgPrinter.Errorfln("avly: %s", err.Error())
+		}
+		snapshot, err := snapshotPrefix(logPrinter, runner)
+		if err != nil {
+			logPrinter.Errorfln("avly: %s", err.Error())
+		}
+		msgPrinter.Printfln("%s", snapshot.ArchivePath(dir))
+	case "restore":
+		requireCapability(msgPrinter, "prefix restore", capSystem)
+		if len(name) == 0 {
+			name = prefix.LatestSnapshot
+		}
+		snapshot, err := prefix.FindSnapshot(dir, name)
+		if err != nil {
+			msgPrinter.Errorfln("avly: %s", err.Error())
+		}
+		if err = quiescePrefix(runner); err != nil {
+			msgPrinter.Errorfln("avly: %s", err.Error())
+		}
+		logPrinter.Printfln("Restore Wine prefix from %s...", snapshot.Name)
+		if err = restorePrefix(runner, snapshot); err != nil {
+			logPrinter.Errorfln("avly: %s", err.Error())
+		}
+		logPrinter.Printfln("Restore: OK")
+	case "check", "repair":
+		requireCapability(msgPrinter, "prefix "+command, capManage)
+		issues := checkPrefix(msgPrinter, logPrinter, runner, command == "repair")
+		for _, issue := range issues {
+			msgPrinter.Printfln("%s", issue.String())
+		}
+		if len(issues) > 0 {
+			msgPrinter.Errorfln("avly: Wine prefix has %d issue(s)", len(issues))
+		}
+		logPrinter.Printfln("Wine prefix: OK")
+	case "prune":
+		requireCapability(msgPrinter, "prefix prune", capSystem)
+		if keep < 0 {
+			msgPrinter.Errorfln("avly: flag 'keep' needs a number of snapshots, 0 or more")
+		}
+		removed, err := prefix.PruneSnapshots(dir, keep)
+		if err != nil {
+			msgPrinter.Errorfln("avly: %s", err.Error())
+		}
+		for _, snapshot := range removed {
+			hlp.AppendLog(&env, "Removed snapshot of Wine prefix: %s", snapshot.Name)
+			msgPrinter.Printfln("removed %s", snapshot.Name)
+		}
+	default:
+		msgPrinter.Errorfln("avly: flag 'prefix' needs one of the arguments check, repair, snapshot, restore [name], list or prune")
+	}
+}
+
+// quiescePrefix refuses to touch the prefix while a terminal runs and waits for the wineserver to
+// write the registry and exit, at most AVL_WINESERVER_TIMEOUT.
+func quiescePrefix(runner ifc.CmdRunner) error {
+	if targetPid() > 0 {
+		return errors.New("prefix error: a terminal is running, stop it first (avly -stop)")
+	}
+	timeout := hlp.EnvDuration(&env, "AVL_WINESERVER_TIMEOUT", defaultWineserverTimeout)
+	managed(runner).RunCmdSync(fmt.Sprintf("timeout %d wineserver -w", int(timeout.Seconds())), &env)
+
+	return nil
+}
+
+// snapshotPrefix archives the quiesced prefix into the snapshot dir.
+func snapshotPrefix(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) (snapshot prefix.Snapshot, err error) {
+	logPrinter.Printfln("Snapshot Wine prefix...")
+	if snapshot, err = prefix.CreateSnapshot(runner, &env, mt5.InstalledBuild(&env), time.Now()); err != nil {
+		return
+	}
+	hlp.AppendLog(&env, "Snapshot of Wine prefix: %s (%s, build %s)", snapshot.Name, snapshot.WineVersion, snapshot.MT5Build)
+
+	return
+}
+
+func restorePrefix(runner ifc.CmdRunner, snapshot prefix.Snapshot) (err error) {
+	if err = prefix.RestoreSnapshot(runner, &env, snapshot); err != nil {
+		return
+	}
+	if err = handOverTree(hlp.EnvValue(&env, "WINEPREFIX")); err != nil {
+		return
+	}
+	hlp.AppendLog(&env, "Restored Wine prefix from snapshot %s (%s, build %s)", snapshot.Name, snapshot.WineVersion, snapshot.MT5Build)
+
+	return
+}
+
+// seedPrefix restores the snapshot named by AVL_PREFIX_SEED ("latest" or a name) instead of running
+// the installers. It tells whether the prefix was seeded; otherwise the caller prepares it as usual.
+func seedPrefix(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) bool {
+	name := hlp.EnvValue(&env, "AVL_PREFIX_SEED")
+	if len(name) == 0 {
+		return false
+	}
+	snapshot, err := prefix.FindSnapshot(prefix.SnapshotDir(&env), name)
+	if err != nil {
+		logPrinter.Printfln("avly: warn: cannot seed Wine prefix: %s", err.Error())
+		return false
+	}
+	if version, _ := prefix.WineVersion(runner, &env); version != snapshot.WineVersion {
+		logPrinter.Printfln("avly: warn: cannot seed Wine prefix: snapshot %s was taken with %s, installed is %s", snapshot.Name, snapshot.WineVersion, version)
+		return false
+	}
+	logPrinter.Printfln("Seed Wine prefix from %s...", snapshot.Name)
+	if err = restorePrefix(runner, snapshot); err != nil {
+		logPrinter.Printfln("avly: warn: cannot seed Wine prefix: %s", err.Error())
+		return false
+	}
+
+	return true
+}
+
+// defaultWineserverTimeout bounds waiting for the wineserver to exit unless AVL_WINESERVER_TIMEOUT says otherwise
+const defaultWineserverTimeout = 30 * time.Second
+
+// teardownWine ends the Wine session of the prefix and reports the processes which had to be killed
+// or survived.
+func teardownWine(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) {
+	killed, leftovers, err := prefix.TeardownSession(managed(runner), &env, hlp.EnvDuration(&env, "AVL_WINESERVER_TIMEOUT", defaultWineserverTimeout))
+	if err != nil {
+		logPrinter.Printfln("avly: warn: could not list Wine processes: %s", err.Error())
+	}
+	if len(killed) > 0 {
+		hlp.AppendLog(&env, "Killed Wine processes the wineserver left: %s", joinProcesses(killed))
+	}
+	if len(leftovers) > 0 {
+		logPrinter.Printfln("avly: warn: Wine processes survived stop: %s", joinProcesses(leftovers))
+		hlp.AppendLog(&env, "Wine processes survived stop: %s", joinProcesses(leftovers))
+	}
+	if statusStore != nil {
+		statusStore.Update(func(s *status.Status) {
+			s.Prefix.Leftovers = nil
+			for _, proc := range leftovers {
+				s.Prefix.Leftovers = append(s.Prefix.Leftovers, proc.String())
+			}
+		})
+	}
+}
+
+func joinProcesses(procs []prefix.Process) string {
+	names := make([]string, len(procs))
+	for i, proc := range procs {
+		names[i] = proc.String()
+	}
+
+	return strings.Join(names, ", ")
+}
+
+const (
+	// launchFailuresName counts failed launches in AVL_STATE, so the next launch, also in a restarted
+	// container, checks the prefix
+	launchFailuresName = "launch-failures"
+	// defaultLaunchAttempts bounds the tries of a launch unless AVL_LAUNCH_ATTEMPTS says otherwise
+	defaultLaunchAttempts = 3
+)
+
+// launchFailures returns the number of failed launches since the last successful one.
+func launchFailures() (failures int) {
+	raw, _ := os.ReadFile(filepath.Join(hlp.EnvValue(&env, "AVL_STATE"), launchFailuresName))
+	failures, _ = strconv.Atoi(strings.TrimSpace(string(raw)))
+
+	return
+}
+
+// recordLaunch resets or increments the count of failed launches.
+func recordLaunch(succeeded bool) {
+	failures := 0
+	if !succeeded {
+		failures = launchFailures() + 1
+	}
+	path := filepath.Join(hlp.EnvValue(&env, "AVL_STATE"), launchFailuresName)
+	if failures == 0 {
+		os.Remove(path)
+	} else if errDir := os.MkdirAll(filepath.Dir(path), 0755); errDir == nil {
+		hlp.WriteFresh(path, []byte(strconv.Itoa(failures)), 0644)
+	}
+	if statusStore != nil {
+		statusStore.Update(func(s *status.Status) {
+			s.Prefix.LaunchFailures = failures
+		})
+	}
+}
+
+func newPrefixChecker(runner ifc.CmdRunner) prefix.Checker {
+	wineserver, _, _ := runner.RunCmdSync("pidof wineserver", &env)
+
+	recipe := conf.Wine.Recipe.WithDefaults()
+
+	return prefix.Checker{
+		Prefix:            hlp.EnvValue(&env, "WINEPREFIX"),
+		InstallDir:        mt5.InstallDir(&env),
+		MonoVersion:       prefix.MSIVersion(recipe.MonoMSI),
+		GeckoVersion:      prefix.MSIVersion(recipe.GeckoMSI),
+		WineserverRunning: len(wineserver) > 0,
+		Recipe:            recipe,
+		Timeout:           stepTimeout(),
+	}
+}
+
+// stepTimeout bounds a step building the prefix unless AVL_PREFIX_STEP_TIMEOUT says otherwise.
+func stepTimeout() time.Duration {
+	return hlp.EnvDuration(&env, "AVL_PREFIX_STEP_TIMEOUT", prefix.DefaultStepTimeout)
+}
+
+// applyRecipe runs the steps of the prefix recipe not done yet.
+func applyRecipe(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) error {
+	if hlp.IsRoot() {
+		if err := hlp.InstallWinetricks(runner, &env); err != nil {
+			return err
+		}
+	}
+	ran, err := prefix.ApplyRecipe(managed(runner), &env, conf.Wine.Recipe, stepTimeout(), logPrinter.Printfln)
+	for _, step := range ran {
+		hlp.AppendLog(&env, "Wine prefix recipe: %s done", step)
+	}
+
+	return err
+}
+
+// checkPrefix reports the issues of the Wine prefix and, with repair, fixes them. It returns the
+// issues left.
+func checkPrefix(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, repair bool) (issues []prefix.Issue) {
+	issues = newPrefixChecker(runner).Check()
+	for _, issue := range issues {
+		logPrinter.Printfln("avly: warn: prefix: %s", issue.String())
+		hlp.AppendLog(&env, "Wine prefix issue: %s", issue.String())
+	}
+	var repaired []string
+	if repair && len(issues) > 0 {
+		stopDialogs := watchDialogs(logPrinter, runner)
+		for _, action := range prefix.Repairs(issues) {
+			logPrinter.Printfln("Repair Wine prefix: %s...", action)
+			var err error
+			if action == prefix.RepairRebuild {
+				err = rebuildPrefix(msgPrinter, logPrinter, runner)
+			} else {
+				err = newPrefixChecker(runner).Fix(managed(runner), &env, action)
+			}
+			if err != nil {
+				logPrinter.Printfln("avly: warn: could not repair Wine prefix (%s): %s", action, err.Error())
+				continue
+			}
+			hlp.AppendLog(&env, "Repaired Wine prefix: %s", action)
+			repaired = append(repaired, string(action))
+			// a rebuilt prefix is checked from scratch
+			if action == prefix.RepairRebuild {
+				break
+			}
+		}
+		stopDialogs()
+		issues = newPrefixChecker(runner).Check()
+	}
+	if statusStore != nil {
+		statusStore.Update(func(s *status.Status) {
+			now := time.Now()
+			s.Prefix.CheckedAt = &now
+			s.Prefix.Repaired = repaired
+			s.Prefix.Issues = nil
+			for _, issue := range issues {
+				s.Prefix.Issues = append(s.Prefix.Issues, issue.Check+": "+issue.Problem)
+			}
+		})
+	}
+
+	return
+}
+
+// rebuildPrefix replaces a broken prefix by the newest snapshot or, without one, builds it anew. The
+// broken prefix is kept in $WINEPREFIX.broken for inspection until the next rebuild.
+func rebuildPrefix(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) (err error) {
+	if snapshot, errFind := prefix.FindSnapshot(prefix.SnapshotDir(&env), prefix.LatestSnapshot); errFind == nil {
+		if version, _ := prefix.WineVersion(runner, &env); version == snapshot.WineVersion {
+			return restorePrefix(runner, snapshot)
+		}
+	}
+	wineprefix := filepath.Clean(hlp.EnvValue(&env, "WINEPREFIX"))
+	managed(runner).RunCmdSync("wineserver -k", &env)
+	if err = os.RemoveAll(wineprefix + ".broken"); err != nil {
+		return
+	}
+	if err = os.Rename(wineprefix, wineprefix+".broken"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err = os.MkdirAll(wineprefix, 0755); err != nil {
+		return
+	}
+	if err = handOverTree(wineprefix); err != nil {
+		return
+	}
+	_, _, err = prepare(msgPrinter, logPrinter, runner)
+
+	return
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"os"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+	"github.com/9tmark/avly-trader/internal/vnc"
+)
+
+// runAs is the user of the display, window manager, VNC server, Wine and terminal processes
+// (AVL_RUN_AS). Without hasRunAs, they run as the user of avly.
+var (
+	runAs    hlp.Identity
+	hasRunAs bool
+)
+
+// x11SocketDir is shared by all X servers; only root may create it with the sticky bit
+const x11SocketDir = "/tmp/.X11-unix"
+
+type capability int
+
+const (
+	// capSystem installs packages and changes the ownership of files
+	capSystem capability = iota
+	// capManage starts and signals the managed processes and writes into their folders
+	capManage
+)
+
+func loadRunAs() (err error) {
+	runAs, hasRunAs, err = hlp.RunAsIdentity(&env)
+
+	return
+}
+
+// requireCapability stops avly unless it may perform the operations of cap for verb.
+func requireCapability(msgPrinter ifc.MsgPrinter, verb string, cap capability) {
+	switch cap {
+	case capSystem:
+		if !hlp.IsRoot() {
+			msgPrinter.Errorfln("avly: flag '%s' needs to be executed as root", verb)
+		}
+	case capManage:
+		if !hasRunAs && !hlp.IsRoot() {
+			msgPrinter.Errorfln("avly: flag '%s' needs to be executed as root", verb)
+		}
+		if hasRunAs && !hlp.CanActAs(runAs) {
+			msgPrinter.Errorfln("avly: flag '%s' needs to be executed as root or %s", verb, runAs.Name)
+		}
+	}
+}
+
+// managed returns the runner for the display, window manager, VNC server, Wine and terminal
+// processes, which drops root privileges if AVL_RUN_AS is set.
+func managed(runner ifc.CmdRunner) ifc.CmdRunner {
+	if !hasRunAs || !hlp.IsRoot() {
+		return runner
+	}
+
+	return runAs.Runner(runner)
+}
+
+// handOver creates the folders of avly and the managed processes. With AVL_RUN_AS, the latter are
+// given to that user, fixing files left behind by earlier runs as root. The folders avly writes into
+// as root (logs, runtime files with secrets and status, state) stay with root, so the user can
+// neither read the secrets nor redirect those writes with links. The VNC server runs as the user, so
+// its password files and sessions are handed over as well.
+func handOver() (err error) {
+	for _, name := range []string{"AVL_LOGS", "AVL_RUNTIME", "AVL_STATE"} {
+		dir := hlp.EnvValue(&env, name)
+		if len(dir) == 0 {
+			continue
+		}
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return
+		}
+		if hasRunAs && hlp.IsRoot() {
+			if err = hlp.ReclaimTree(dir); err != nil {
+				return
+			}
+			// the user still needs to reach the folders handed over inside
+			if err = os.Chmod(dir, 0755); err != nil {
+				return
+			}
+		}
+	}
+	for _, dir := range []string{
+		hlp.EnvValue(&env, "WINEPREFIX"),
+		hlp.EnvValue(&env, "AVL_PROCESS_LOGS"),
+		hlp.EnvValue(&env, "AVL_TESTER"),
+		hlp.EnvValue(&env, "AVL_MQL5_BUILD"),
+		mt5.TerminalRuntimeDir(&env),
+		vnc.Dir(&env),
+	} {
+		if len(dir) == 0 {
+			continue
+		}
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return
+		}
+		if err = handOverTree(dir); err != nil {
+			return
+		}
+	}
+	if err = os.MkdirAll(x11SocketDir, 01777); err != nil {
+		return
+	}
+
+	return os.Chmod(x11SocketDir, os.ModeDir|os.ModeSticky|0777)
+}
+
+// handOverTree gives dir and its content to the AVL_RUN_AS user, as far as avly runs as root.
+func handOverTree(dir string) error {
+	if !hasRunAs || !hlp.IsRoot() {
+		return nil
+	}
+
+	return hlp.ChownTree(dir, runAs)
+}
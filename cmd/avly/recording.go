@@ -0,0 +1,76 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"time"
+
+	"github.com/9tmark/avly-trader/internal/display"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+	"github.com/9tmark/avly-trader/internal/recording"
+	"github.com/9tmark/avly-trader/internal/vnc"
+)
+
+// startRecorder records the live display into $AVL_LOGS/recordings if enabled in the config file.
+// Changes of the recording settings take effect on the next start of the container.
+func startRecorder(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) {
+	if !conf.Recording.Enabled {
+		return
+	}
+	recorder := &recording.Recorder{
+		Dir:      recording.Dir(&env),
+		Settings: conf.Recording,
+		Grab: func() (*image.RGBA, error) {
+			return display.Grab(runner, &env, filepath.Join(mt5.RuntimeDir(&env), "recording.xwd"))
+		},
+		Logf: func(format string, a ...interface{}) {
+			logPrinter.Printfln("avly: warn: "+format, a...)
+		},
+	}
+	if conf.Recording.OnlyWithSessions {
+		recorder.Active = func() bool {
+			sessions, err := vnc.ActiveSessions(&env)
+			return err == nil && len(sessions) > 0
+		}
+	}
+	hlp.AppendLog(&env, "Recording display at %g fps into %s", recorder.Settings.WithDefaults().FPS, recorder.Dir)
+	go recorder.Run(make(chan struct{}))
+}
+
+func replayExportHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, from, to, out string) {
+	if len(out) == 0 {
+		msgPrinter.Errorfln("avly: flag 'replay-export' needs an output argument (file ending in .gif or folder)")
+	}
+	fromTime, err := parseReplayTime(from)
+	if err != nil {
+		msgPrinter.Errorfln("avly: invalid 'from': %s", err.Error())
+	}
+	toTime := time.Now()
+	if len(to) > 0 {
+		if toTime, err = parseReplayTime(to); err != nil {
+			msgPrinter.Errorfln("avly: invalid 'to': %s", err.Error())
+		}
+	}
+	count, err := recording.Export(recording.Dir(&env), fromTime, toTime, out)
+	if err != nil {
+		msgPrinter.Errorfln("avly: %s", err.Error())
+	}
+	msgPrinter.Printfln("Exported %d frames to %s", count, out)
+}
+
+// parseReplayTime accepts RFC 3339 times and durations back from now ("15m").
+func parseReplayTime(value string) (time.Time, error) {
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/9tmark/avly-trader/internal/health"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/schedule"
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// terminalProcess is reported missing after a planned restart while the terminal is not running
+const terminalProcess = "terminal process"
+
+// pendingReattachment is the journal from before a planned restart after which the terminal did not
+// come back healthy in time (nil if it did)
+var pendingReattachment *status.JournalStatus
+
+// tradeQuiet tells whether the journal showed no trade activity for the quiet period of task.
+// Otherwise the task is reported as deferred.
+func tradeQuiet(logPrinter ifc.MsgPrinter, name string, task schedule.Task) bool {
+	quiet, _ := task.QuietPeriod()
+	journal := statusStore.Snapshot().Journal
+	if health.TradeQuiet(journal, time.Now(), quiet) {
+		return true
+	}
+	deferAction(logPrinter, name, "trade activity", health.LastTrade(journal).Add(quiet))
+
+	return false
+}
+
+// plannedRestart captures the screen, stops the terminal, runs maintain and launches the terminal
+// again, even if maintain failed. It then waits for the broker authorization and the experts loaded
+// before; if the terminal did not launch or they do not come back within the timeout of task, the
+// instance turns unhealthy.
+func plannedRestart(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, task schedule.Task, maintain func() error) error {
+	timeout, _ := task.RestartTimeout()
+	before := statusStore.Snapshot().Journal
+	screenshot := captureScreen(logPrinter, runner, "planned-restart")
+	now := time.Now()
+	statusStore.Update(func(s *status.Status) {
+		s.Restart = status.RestartStatus{Task: task.Name, At: &now, Screenshot: screenshot}
+	})
+	pendingReattachment = nil
+
+	if _, err := stop(msgPrinter, logPrinter, runner); err != nil {
+		return err
+	}
+	errMaintain := maintain()
+	if err := launchTarget(msgPrinter, logPrinter, runner); err != nil || targetPid() == 0 {
+		missing := []string{terminalProcess}
+		alertReattachment(logPrinter, before, missing)
+		return fmt.Errorf("terminal not back: %s", strings.Join(missing, ", "))
+	}
+
+	logPrinter.Printfln("Await reattachment...")
+	deadline := time.Now().Add(timeout)
+	missing := reattachment(before)
+	for len(missing) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		missing = reattachment(before)
+	}
+	if len(missing) > 0 {
+		alertReattachment(logPrinter, before, missing)
+		return fmt.Errorf("terminal not back within %s: %s", timeout, strings.Join(missing, ", "))
+	}
+	confirmReattachment(logPrinter)
+
+	return errMaintain
+}
+
+// superviseRestart keeps checking a terminal which did not come back healthy after a planned
+// restart and clears the alert once it did.
+func superviseRestart(logPrinter ifc.MsgPrinter) {
+	if pendingReattachment == nil {
+		return
+	}
+	missing := reattachment(*pendingReattachment)
+	if len(missing) > 0 {
+		statusStore.Update(func(s *status.Status) {
+			s.Restart.Missing = missing
+		})
+		return
+	}
+	pendingReattachment = nil
+	confirmReattachment(logPrinter)
+}
+
+// reattachment lists what did not come back since the journal before, the terminal process first.
+func reattachment(before status.JournalStatus) (missing []string) {
+	if targetPid() == 0 {
+		missing = append(missing, terminalProcess)
+	}
+
+	return append(missing, health.Reattachment(before, statusStore.Snapshot().Journal)...)
+}
+
+// alertReattachment turns the instance unhealthy until superviseRestart sees missing come back.
+func alertReattachment(logPrinter ifc.MsgPrinter, before status.JournalStatus, missing []string) {
+	pendingReattachment = &before
+	statusStore.Update(func(s *status.Status) {
+		s.Restart.Missing = missing
+	})
+	logPrinter.Printfln("avly: warn: terminal not back after planned restart: %s", strings.Join(missing, ", "))
+	hlp.AppendLog(&env, "Terminal not back after planned restart: %s", strings.Join(missing, ", "))
+}
+
+func confirmReattachment(logPrinter ifc.MsgPrinter) {
+	now := time.Now()
+	statusStore.Update(func(s *status.Status) {
+		s.Restart.Missing, s.Restart.ConfirmedAt = nil, &now
+	})
+	logPrinter.Printfln("Terminal reattached: OK")
+	hlp.AppendLog(&env, "Terminal reattached to broker and experts after planned restart")
+}
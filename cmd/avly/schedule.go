@@ -0,0 +1,211 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+	"github.com/9tmark/avly-trader/internal/prefix"
+	"github.com/9tmark/avly-trader/internal/schedule"
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// scheduled are the settings the running scheduler was planned from
+var scheduled schedule.Settings
+
+// taskRun tells the watch loop about the scheduled tasks running in the background
+var taskRun struct {
+	sync.Mutex
+	running, restarting bool
+}
+
+// tasksRunning tells whether scheduled tasks run and whether one of them restarts the terminal, in
+// which case the watch loop leaves the terminal to it.
+func tasksRunning() (running, restarting bool) {
+	taskRun.Lock()
+	defer taskRun.Unlock()
+
+	return taskRun.running, taskRun.restarting
+}
+
+// newScheduler plans the tasks of the config file, continuing the schedule persisted in AVL_STATE.
+func newScheduler(logPrinter ifc.MsgPrinter) *schedule.Scheduler {
+	scheduled = conf.Schedule
+	scheduler, err := schedule.New(conf.Schedule, schedule.StatePath(hlp.EnvValue(&env, "AVL_STATE")), time.Now())
+	if err != nil {
+		logPrinter.Printfln("avly: warn: scheduled tasks disabled: %s", err.Error())
+		return nil
+	}
+	reportSchedule(scheduler)
+
+	return scheduler
+}
+
+// taskDeferralPrefix tells deferred tasks from deferred actions of the supervisor
+const taskDeferralPrefix = "task "
+
+func taskDeferral(task schedule.Task) string {
+	return taskDeferralPrefix + task.Name
+}
+
+// runSchedule starts the tasks which are due in the background, where they run one after the other,
+// so the watch loop and the signal handling stay responsive. Disruptive ones wait for a maintenance
+// window, restarts also for a journal without recent trades. Nothing new starts while tasks still
+// run. A changed config file replans the schedule first.
+func runSchedule(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, scheduler *schedule.Scheduler) *schedule.Scheduler {
+	if running, _ := tasksRunning(); running {
+		return scheduler
+	}
+	if !reflect.DeepEqual(scheduled, conf.Schedule) {
+		scheduler = newScheduler(logPrinter)
+	}
+	if scheduler == nil {
+		return nil
+	}
+	waiting := map[string]bool{}
+	var runnable []schedule.Task
+	restarting := false
+	for _, task := range scheduler.Due(time.Now()) {
+		deferral := taskDeferral(task)
+		if task.IsDisruptive(conf.Cleanup.TouchesHistory()) && !maintenanceAllowed(logPrinter, deferral, "scheduled "+task.Action, task.Windows) {
+			waiting[deferral] = true
+			continue
+		}
+		if task.Restarts() && !tradeQuiet(logPrinter, deferral, task) {
+			waiting[deferral] = true
+			continue
+		}
+		runDeferred(deferral)
+		runnable = append(runnable, task)
+		restarting = restarting || task.Restarts()
+	}
+	// tasks removed or replanned meanwhile no longer wait
+	for name := range deferrals {
+		if strings.HasPrefix(name, taskDeferralPrefix) && !waiting[name] {
+			clearDeferral(name)
+		}
+	}
+	reportSchedule(scheduler)
+	if len(runnable) == 0 {
+		return scheduler
+	}
+
+	taskRun.Lock()
+	taskRun.running, taskRun.restarting = true, restarting
+	taskRun.Unlock()
+	go func() {
+		for _, task := range runnable {
+			logPrinter.Printfln("Run scheduled task %s (%s)...", task.Name, task.Action)
+			err := runTask(msgPrinter, logPrinter, runner, task)
+			if err != nil {
+				logPrinter.Printfln("avly: warn: scheduled task %s failed: %s", task.Name, err.Error())
+				hlp.AppendLog(&env, "Scheduled task %s failed: %s", task.Name, err.Error())
+			} else {
+				hlp.AppendLog(&env, "Scheduled task %s done", task.Name)
+			}
+			if errState := scheduler.Done(task, time.Now(), err); errState != nil {
+				logPrinter.Printfln("avly: warn: could not persist schedule: %s", errState.Error())
+			}
+		}
+		reportSchedule(scheduler)
+		taskRun.Lock()
+		taskRun.running, taskRun.restarting = false, false
+		taskRun.Unlock()
+	}()
+
+	return scheduler
+}
+
+// reportSchedule publishes the last and next runs in the status document.
+func reportSchedule(scheduler *schedule.Scheduler) {
+	if statusStore == nil {
+		return
+	}
+	tasks := make([]status.ScheduledTask, len(scheduler.Tasks))
+	for i, task := range scheduler.Tasks {
+		state := scheduler.State[task.Name]
+		tasks[i] = status.ScheduledTask{Name: task.Name, Action: task.Action, LastRun: state.LastRun, LastResult: state.LastResult, NextRun: state.NextRun}
+	}
+	statusStore.Update(func(s *status.Status) {
+		s.Schedule = tasks
+	})
+}
+
+func runTask(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, task schedule.Task) (err error) {
+	switch task.Action {
+	case schedule.ActionCleanUp:
+		_, err = cleanUp(msgPrinter, logPrinter, runner, false)
+	case schedule.ActionRotateLogs:
+		err = rotateLogs(runner)
+	case schedule.ActionSnapshot:
+		err = plannedRestart(msgPrinter, logPrinter, runner, task, func() error {
+			if err := quiescePrefix(runner); err != nil {
+				return err
+			}
+			if _, err := snapshotPrefix(logPrinter, runner); err != nil {
+				return err
+			}
+			removed, err := prefix.PruneSnapshots(prefix.SnapshotDir(&env), task.Keep)
+			for _, snapshot := range removed {
+				hlp.AppendLog(&env, "Removed snapshot of Wine prefix: %s", snapshot.Name)
+			}
+			return err
+		})
+	case schedule.ActionRestart:
+		err = plannedRestart(msgPrinter, logPrinter, runner, task, func() error { return nil })
+	case schedule.ActionBackup:
+		var path string
+		if path, err = mt5.BackupData(runner, &env, task.Target, task.Name, time.Now()); err != nil {
+			return
+		}
+		hlp.AppendLog(&env, "Backed up terminal data: %s", path)
+		_, err = mt5.PruneBackups(task.Target, task.Name, task.Keep)
+	case schedule.ActionCommand:
+		err = runCommandTask(runner, task)
+	}
+
+	return
+}
+
+// rotateLogs moves avly.log into the backup of the month and leaves a pointer to it.
+func rotateLogs(runner ifc.CmdRunner) (err error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, _, err = runner.RunCmdSync("cat $AVL_LOGS/avly.log >> $AVL_LOGS/avly.bak-$(date +\"%Y_%m\").log", &env); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not backup logs: %w", err)
+	}
+	runner.RunCmdSync("cat /dev/null > $AVL_LOGS/avly.log", &env)
+	runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") For older logs, see: $(echo $AVL_LOGS/avly.bak-$(date +\"%Y_%m\").log) >> $AVL_LOGS/avly.log", &env)
+
+	return
+}
+
+// runCommandTask runs a custom command as the user of the terminal, appending its output to
+// schedule.log.
+func runCommandTask(runner ifc.CmdRunner, task schedule.Task) error {
+	timeout, _ := task.CommandTimeout()
+	cmdLine := fmt.Sprintf("timeout %d sh -c %s >> $AVL_PROCESS_LOGS/schedule.log 2>&1", int(timeout.Seconds()), hlp.ShellQuote(task.Command))
+	_, _, err := managed(runner).RunCmdSync(cmdLine, &env)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 124 {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+
+	return err
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"github.com/9tmark/avly-trader/internal/display"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+)
+
+func screenshotHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, instance string) {
+	screenEnv := append([]string{}, env...)
+	if len(instance) > 0 && instance != "live" {
+		instanceDisplay, err := mt5.RunningTesterDisplay(&env, instance)
+		if err != nil {
+			msgPrinter.Errorfln("avly: %s", err.Error())
+		}
+		hlp.SetEnvValue(&screenEnv, "DISPLAY", instanceDisplay)
+	}
+	reason := "manual"
+	if len(instance) > 0 {
+		reason += "-" + instance
+	}
+	path, err := display.Capture(runner, &screenEnv, reason)
+	if err != nil {
+		msgPrinter.Errorfln("avly: could not capture screen: %s", err.Error())
+	}
+	msgPrinter.Printfln("%s", path)
+}
+
+// captureScreen keeps a screenshot before a forced restart or after a failure. Failures to capture are
+// only logged, as the framebuffer may be the very thing that is broken.
+func captureScreen(logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, reason string) (path string) {
+	path, err := display.Capture(runner, &env, reason)
+	if err != nil {
+		logPrinter.Printfln("avly: warn: could not capture screen: %s", err.Error())
+		return ""
+	}
+	hlp.AppendLog(&env, "Captured screen (%s): %s", reason, path)
+
+	return
+}
@@ -0,0 +1,33 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/mt5"
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// statusStore holds the status document of the watching 'enter' process (nil for other verbs).
+var statusStore *status.Store
+
+func statusHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) {
+	requireCapability(msgPrinter, "status", capManage)
+	current, err := status.Load(mt5.RuntimeDir(&env))
+	if err != nil {
+		msgPrinter.Errorfln("avly: %s", err.Error())
+	}
+	raw, _ := json.MarshalIndent(current, "", "  ")
+	msgPrinter.Printfln("%s", string(raw))
+	// non-zero exit code for container health checks
+	if reasons := current.Unhealthy(); len(reasons) > 0 {
+		msgPrinter.Errorfln("avly: unhealthy: %s", strings.Join(reasons, "; "))
+	}
+}
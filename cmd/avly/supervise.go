@@ -0,0 +1,243 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/9tmark/avly-trader/internal/config"
+	"github.com/9tmark/avly-trader/internal/display"
+	"github.com/9tmark/avly-trader/internal/health"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// newBrokerMonitor reads the broker supervision settings:
+// AVL_BROKER_THRESHOLD (default 5m), AVL_BROKER_ACTION (alert, restart-terminal, restart-stack) and
+// AVL_BROKER_PROBE (optional host:port of the trade server).
+func newBrokerMonitor(logPrinter ifc.MsgPrinter) *health.BrokerMonitor {
+	action, err := health.ParseAction(hlp.EnvValue(&env, "AVL_BROKER_ACTION"))
+	if err != nil {
+		logPrinter.Printfln("avly: warn: %s, falling back to alert", err.Error())
+	}
+
+	return &health.BrokerMonitor{
+		Threshold: hlp.EnvDuration(&env, "AVL_BROKER_THRESHOLD", 5*time.Minute),
+		Action:    action,
+		ProbeAddr: hlp.EnvValue(&env, "AVL_BROKER_PROBE"),
+	}
+}
+
+// superviseBroker derives the broker connectivity from the journal (and the TCP probe) and carries
+// out the configured action if it stays disconnected.
+func superviseBroker(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, monitor *health.BrokerMonitor) {
+	journal := statusStore.Snapshot().Journal
+	connected := !journal.ConnectionKnown || journal.Connected
+	var probe string
+	if len(monitor.ProbeAddr) > 0 {
+		probe = "ok"
+		if !monitor.Probe(10 * time.Second) {
+			probe = "failed"
+			connected = false
+		}
+	}
+
+	now := time.Now()
+	healthy, due := monitor.Observe(connected, now)
+	statusStore.Update(func(s *status.Status) {
+		s.Broker.Healthy, s.Broker.Connected, s.Broker.Probe, s.Broker.DisconnectedSince = healthy, connected, probe, nil
+		if since := monitor.DisconnectedSince(); !connected && !since.IsZero() {
+			s.Broker.DisconnectedSince = &since
+		}
+	})
+	if !due {
+		return
+	}
+
+	logPrinter.Printfln("avly: warn: broker disconnected for more than %s (action: %s)", monitor.Threshold, monitor.Action)
+	runner.RunCmdSync("echo $(date +\"%Y/%m/%d %T\") Broker unhealthy: disconnected >> $AVL_LOGS/avly.log", &env)
+	if monitor.Action != health.ActionAlert {
+		captureScreen(logPrinter, runner, "broker-"+string(monitor.Action))
+	}
+	switch monitor.Action {
+	case health.ActionRestartTerminal:
+		relaunchTarget(msgPrinter, logPrinter, runner)
+	case health.ActionRestartStack:
+		restartStack(msgPrinter, logPrinter, runner)
+	}
+	statusStore.Update(func(s *status.Status) {
+		s.Broker.LastAction, s.Broker.LastActionAt = string(monitor.Action), &now
+	})
+}
+
+// newHungDetector reads the hung-terminal settings: AVL_HUNG_STRIKES (consecutive probes, default 2)
+// and AVL_HUNG_PING_TIMEOUT (how long the main window has to answer a ping, default 5s).
+func newHungDetector() *health.HungDetector {
+	strikes, err := strconv.Atoi(hlp.EnvValue(&env, "AVL_HUNG_STRIKES"))
+	if err != nil || strikes < 1 {
+		strikes = 2
+	}
+	timeout := hlp.EnvDuration(&env, "AVL_HUNG_PING_TIMEOUT", 5*time.Second)
+
+	return &health.HungDetector{
+		Strikes: strikes,
+		Ping: func(window display.Window) (bool, error) {
+			return display.Ping(&env, window.ID, timeout)
+		},
+	}
+}
+
+// superviseTerminal inspects the windows of the display for crash dialogs, a missing main window or
+// an unresponsive process. A hung terminal is captured and handled by AVL_HUNG_ACTION (alert,
+// restart-terminal, restart-stack; default restart-terminal).
+func superviseTerminal(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, detector *health.HungDetector) {
+	pid := targetPid()
+	if pid == 0 {
+		// a missing process is handled by the watch loop
+		return
+	}
+	windows, err := display.ListWindows(runner, &env)
+	if err != nil {
+		logPrinter.Printfln("avly: warn: could not inspect windows: %s", err.Error())
+		return
+	}
+	procState, _ := hlp.ProcState(pid)
+
+	verdict := detector.Inspect(windows, pid, procState)
+	now := time.Now()
+	statusStore.Update(func(s *status.Status) {
+		if !verdict.Hung {
+			s.Terminal.Hung, s.Terminal.Reason, s.Terminal.Detail, s.Terminal.HungSince = false, "", "", nil
+		}
+		s.Terminal.MainWindow = verdict.MainWindow
+	})
+	if !verdict.Hung {
+		return
+	}
+
+	action := health.ActionRestartTerminal
+	if configured := hlp.EnvValue(&env, "AVL_HUNG_ACTION"); len(configured) > 0 {
+		if action, err = health.ParseAction(configured); err != nil {
+			logPrinter.Printfln("avly: warn: %s, falling back to alert", err.Error())
+		}
+	}
+	screenshot := captureScreen(logPrinter, runner, "hung")
+	logPrinter.Printfln("avly: warn: terminal hung: %s: %s (action: %s)", verdict.Reason, verdict.Detail, action)
+	hlp.AppendLog(&env, "Terminal hung: %s: %s (screenshot: %s)", verdict.Reason, verdict.Detail, screenshot)
+	statusStore.Update(func(s *status.Status) {
+		s.Terminal.Hung, s.Terminal.Reason, s.Terminal.Detail, s.Terminal.Screenshot = true, string(verdict.Reason), verdict.Detail, screenshot
+		if s.Terminal.HungSince == nil {
+			s.Terminal.HungSince = &now
+		}
+	})
+	switch action {
+	case health.ActionRestartTerminal:
+		relaunchTarget(msgPrinter, logPrinter, runner)
+	case health.ActionRestartStack:
+		restartStack(msgPrinter, logPrinter, runner)
+	}
+}
+
+// displayRestart names the restart of the stack for a changed display geometry while it is deferred
+const displayRestart = "stack restart"
+
+// superviseDisplay reports the geometry of the running X server and restarts the stack if it differs
+// from the one configured, e.g. after the config file was changed. The restart waits for a
+// maintenance window.
+func superviseDisplay(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) {
+	// scheduled tasks running in the background read the config, so it is reloaded between them
+	if running, _ := tasksRunning(); !running {
+		if err := loadConfig(); err != nil {
+			logPrinter.Printfln("avly: warn: keeping previous config: %s", err.Error())
+		}
+	}
+	configured := conf.DisplayOf(config.LiveInstance)
+	actual, err := display.QueryGeometry(runner, &env)
+	if err != nil {
+		// a dead X server is handled by the watch loop
+		return
+	}
+	statusStore.Update(func(s *status.Status) {
+		s.Display.Actual, s.Display.Configured = actual.String(), configured.String()
+	})
+	// the resolution is derived from the screen size in millimeters and may be off by one
+	dpiOff := actual.DPI - configured.DPI
+	if actual.WHD() == configured.WHD() && dpiOff >= -1 && dpiOff <= 1 {
+		clearDeferral(displayRestart)
+		return
+	}
+	if !maintenanceAllowed(logPrinter, displayRestart, fmt.Sprintf("display geometry changed from %s to %s", actual, configured), nil) {
+		return
+	}
+	runDeferred(displayRestart)
+
+	logPrinter.Printfln("Display geometry changed from %s to %s", actual, configured)
+	hlp.AppendLog(&env, "Display geometry changed from %s to %s, restarting stack", actual, configured)
+	restartStack(msgPrinter, logPrinter, runner)
+}
+
+// restartStack takes down target process and VNC server and brings both back up.
+func restartStack(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) {
+	logPrinter.Printfln("Restart stack")
+	stopTarget(msgPrinter, logPrinter, runner)
+	refledgeDisplay(msgPrinter, logPrinter, runner)
+	launchTarget(msgPrinter, logPrinter, runner)
+}
+
+// relaunchTarget stops the target process and launches it again. Unlike the handlers of the verbs,
+// the supervising helpers do not exit on failure: the watching process keeps running and retries in
+// its next cycle.
+func relaunchTarget(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) error {
+	stopTarget(msgPrinter, logPrinter, runner)
+
+	return launchTarget(msgPrinter, logPrinter, runner)
+}
+
+func stopTarget(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) {
+	if _, err := stop(msgPrinter, logPrinter, runner); err != nil {
+		logPrinter.Printfln("avly: warn: %s", err.Error())
+	}
+}
+
+// launchTarget launches the target process, capturing the screen and logging if that failed.
+func launchTarget(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) error {
+	targetProcessAlive, err := launch(msgPrinter, logPrinter, runner)
+	if err == nil && !targetProcessAlive {
+		err = errors.New("could not launch or verify target executable")
+	}
+	if err != nil {
+		captureScreen(logPrinter, runner, "launch-failed")
+		logPrinter.Printfln("avly: warn: %s", err.Error())
+		hlp.AppendLog(&env, "Launch failed: %s", err.Error())
+	}
+
+	return err
+}
+
+// refledgeDisplay drains the VNC server and brings framebuffer and VNC server back up.
+func refledgeDisplay(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) error {
+	if _, err := drain(msgPrinter, logPrinter, runner); err != nil {
+		logPrinter.Printfln("avly: warn: %s", err.Error())
+	}
+	framebufferAlive, vncServerAlive, err := fledge(msgPrinter, logPrinter, runner)
+	if err == nil && !framebufferAlive {
+		err = errors.New("could not open or verify framebuffer")
+	}
+	if err == nil && !vncServerAlive {
+		err = errors.New("could not pull up or verify VNC server")
+	}
+	if err != nil {
+		logPrinter.Printfln("avly: warn: %s", err.Error())
+		hlp.AppendLog(&env, "Display restart failed: %s", err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+	"github.com/9tmark/avly-trader/internal/status"
+	"github.com/9tmark/avly-trader/internal/vnc"
+	"github.com/9tmark/avly-trader/internal/webvnc"
+)
+
+// vncCmdLine builds the x11vnc command line from the vnc section of the config file. Passwords are
+// handed over in files inside the runtime dir, never as arguments.
+func vncCmdLine() (cmdLine string, err error) {
+	password, hasPassword, err := vnc.LoadPassword(&env)
+	if err != nil {
+		return
+	}
+	settings := conf.VNC.WithDefaults(hasPassword)
+
+	var auth vnc.Auth
+	switch {
+	case settings.OTP:
+		auth.PasswdPath = vnc.OTPPath(&env)
+		if _, errStat := os.Stat(auth.PasswdPath); errStat != nil {
+			if err = vnc.RevokeOTP(&env); err != nil {
+				return
+			}
+		}
+	case hasPassword:
+		if len(password) > 8 {
+			hlp.AppendLog(&env, "VNC only uses the first 8 characters of the password")
+		}
+		if auth.RfbAuthPath, err = vnc.WriteRfbAuth(&env, password); err != nil {
+			return
+		}
+	}
+	if self, errSelf := os.Executable(); errSelf == nil {
+		auth.HookCmd = hlp.ShellQuote(self) + " -vnc-hook"
+	}
+	args, err := settings.Args(auth)
+	if err != nil {
+		return
+	}
+	for i := range args {
+		args[i] = hlp.ShellQuote(args[i])
+	}
+
+	return "x11vnc -display $DISPLAY -bg -forever -quiet -xkb -o $AVL_PROCESS_LOGS/x11vnc.log " + strings.Join(args, " "), nil
+}
+
+// newWebBridge connects browsers to the VNC server. The bridge logs in with the password secret or,
+// if only one-time passwords are admitted, with a password of its own.
+func newWebBridge(logPrinter ifc.MsgPrinter) (bridge *webvnc.Bridge, err error) {
+	_, hasPassword, err := vnc.LoadPassword(&env)
+	if err != nil {
+		return
+	}
+	settings := conf.VNC.WithDefaults(hasPassword)
+	addr, err := settings.BridgeAddr()
+	if err != nil {
+		return
+	}
+	bridge = &webvnc.Bridge{
+		Addr:     addr,
+		ViewOnly: settings.Web.ViewOnly,
+		Logf:     logPrinter.Printfln,
+		Audit:    auditWebSession(logPrinter),
+		Mark: func(local string) func() {
+			if errMark := vnc.MarkBridgeConn(&env, local); errMark != nil {
+				logPrinter.Printfln("avly: warn: could not mark web VNC connection: %s", errMark.Error())
+			}
+			return func() { vnc.UnmarkBridgeConn(&env, local) }
+		},
+		Password: func(viewOnly bool) (string, error) {
+			if settings.OTP {
+				return vnc.BridgePassword(&env, viewOnly)
+			}
+			password, _, errPassword := vnc.LoadPassword(&env)
+			return password, errPassword
+		},
+	}
+
+	return
+}
+
+func vncOTPHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner, viewOnly bool) {
+	if !conf.VNC.OTP {
+		msgPrinter.Errorfln("avly: one-time passwords are not enabled (\"otp\" of the vnc config)")
+	}
+	ttl := hlp.EnvDuration(&env, "AVL_VNC_OTP_TTL", vnc.DefaultOTPTTL)
+	otp, err := vnc.IssueOTP(&env, viewOnly, ttl)
+	if err != nil {
+		msgPrinter.Errorfln("avly: %s", err.Error())
+	}
+	mode := "control"
+	if viewOnly {
+		mode = "view-only"
+	}
+	hlp.AppendLog(&env, "Issued VNC one-time password (%s) valid until %s", mode, otp.ExpiresAt.Format(time.RFC3339))
+	msgPrinter.Printfln("%s", otp.Password)
+	msgPrinter.Printfln("valid for a single %s connection until %s", mode, otp.ExpiresAt.Format(time.RFC3339))
+}
+
+// vncHookHandler is run by x11vnc, as the user of the display, after a client was accepted (RFB_MODE=afteraccept) and after it
+// disconnected (RFB_MODE=gone). It audits the session; a one-time password is used up on accept,
+// unless the session is one of the web bridge, which has a password of its own.
+func vncHookHandler(msgPrinter ifc.MsgPrinter, logPrinter ifc.MsgPrinter, runner ifc.CmdRunner) {
+	_, hasPassword, _ := vnc.LoadPassword(&env)
+	settings := conf.VNC.WithDefaults(hasPassword)
+	id, source, now := os.Getenv("RFB_CLIENT_ID"), os.Getenv("RFB_CLIENT_IP"), time.Now()
+	// sessions of the web bridge are audited with the browser's address by the bridge itself, which
+	// marks its connections; other clients may come from the same host, e.g. through an SSH tunnel
+	addr := net.JoinHostPort(source, os.Getenv("RFB_CLIENT_PORT"))
+	fromBridge := vnc.IsBridgeConn(&env, addr)
+
+	switch os.Getenv("RFB_MODE") {
+	case "afteraccept":
+		if fromBridge {
+			return
+		}
+		if settings.OTP {
+			if err := vnc.RevokeOTP(&env); err != nil {
+				msgPrinter.Errorfln("avly: %s", err.Error())
+			}
+		}
+		mode := vnc.ModeControl
+		if settings.ViewOnly || os.Getenv("RFB_LOGIN_VIEWONLY") == "1" {
+			mode = vnc.ModeViewOnly
+		}
+		if err := vnc.SessionStarted(&env, vnc.Session{ID: id, Source: source, Mode: mode, Via: vnc.ViaVNC, Start: now}); err != nil {
+			msgPrinter.Errorfln("avly: %s", err.Error())
+		}
+	case "gone":
+		if fromBridge {
+			vnc.UnmarkBridgeConn(&env, addr)
+			return
+		}
+		if err := vnc.SessionEnded(&env, id, source, now); err != nil {
+			msgPrinter.Errorfln("avly: %s", err.Error())
+		}
+	}
+}
+
+// auditWebSession records a session of the web bridge.
+func auditWebSession(logPrinter ifc.MsgPrinter) func(source string, viewOnly bool) func() {
+	return func(source string, viewOnly bool) func() {
+		mode := vnc.ModeControl
+		if viewOnly || conf.VNC.ViewOnly {
+			mode = vnc.ModeViewOnly
+		}
+		session := vnc.Session{ID: fmt.Sprintf("web-%d", time.Now().UnixNano()), Source: source, Mode: mode, Via: vnc.ViaWeb, Start: time.Now()}
+		if err := vnc.SessionStarted(&env, session); err != nil {
+			logPrinter.Printfln("avly: warn: could not audit web VNC session: %s", err.Error())
+		}
+
+		return func() {
+			if err := vnc.SessionEnded(&env, session.ID, source, time.Now()); err != nil {
+				logPrinter.Printfln("avly: warn: could not audit web VNC session: %s", err.Error())
+			}
+		}
+	}
+}
+
+// superviseVNC moves the sessions recorded by the hooks into the audit log, reports the connected
+// clients and revokes an expired one-time password.
+func superviseVNC(logPrinter ifc.MsgPrinter) {
+	flushed, err := vnc.FlushAudit(&env)
+	if err != nil {
+		logPrinter.Printfln("avly: warn: could not write VNC audit log: %s", err.Error())
+	}
+	for _, record := range flushed {
+		// only one-time passwords admit clients of the VNC server then
+		if conf.VNC.OTP && record.Event == vnc.EventConnect && record.Via == vnc.ViaVNC {
+			hlp.AppendLog(&env, "VNC one-time password used by %s", record.Source)
+		}
+	}
+	sessions, err := vnc.ActiveSessions(&env)
+	if err != nil {
+		logPrinter.Printfln("avly: warn: could not read VNC sessions: %s", err.Error())
+	}
+	statusStore.Update(func(s *status.Status) {
+		s.VNC.Sessions = []status.VNCSession{}
+		for _, session := range sessions {
+			s.VNC.Sessions = append(s.VNC.Sessions, status.VNCSession(session))
+		}
+	})
+
+	if !conf.VNC.OTP {
+		return
+	}
+	revoked, err := vnc.ExpireOTP(&env, time.Now())
+	if err != nil {
+		logPrinter.Printfln("avly: warn: could not expire VNC one-time password: %s", err.Error())
+	}
+	if revoked {
+		hlp.AppendLog(&env, "VNC one-time password expired")
+	}
+}
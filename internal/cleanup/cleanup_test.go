@@ -0,0 +1,210 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cleanup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2022, 6, 30, 12, 0, 0, 0, time.UTC)
+
+// dataDir returns a data dir with files of the given age in days and 1 KiB each.
+func dataDir(t *testing.T, files map[string]int) string {
+	dir := t.TempDir()
+	for name, days := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, make([]byte, 1024), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := testNow.Add(-time.Duration(days) * 24 * time.Hour)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	return dir
+}
+
+func names(items []Item) string {
+	var rels []string
+	for _, item := range items {
+		rels = append(rels, item.Rel)
+	}
+	sort.Strings(rels)
+
+	return strings.Join(rels, ",")
+}
+
+func TestPlanSelectsByAgeCountAndSize(t *testing.T) {
+	dir := dataDir(t, map[string]int{"logs/1.log": 1, "logs/2.log": 2, "logs/9.log": 9, "logs/20.log": 20, "logs/30.log": 30, "logs/keep.txt": 40})
+	engine := Engine{DataDir: dir, Now: testNow}
+
+	cases := []struct {
+		rule     Rule
+		expected string
+	}{
+		{Rule{Path: "logs/*.log"}, "logs/1.log,logs/2.log,logs/20.log,logs/30.log,logs/9.log"},
+		{Rule{Path: "logs/*.log", MinAge: "7d"}, "logs/20.log,logs/30.log,logs/9.log"},
+		{Rule{Path: "logs/*.log", KeepNewest: 4}, "logs/30.log"},
+		{Rule{Path: "logs/*.log", MaxTotalMB: 1}, ""},
+		{Rule{Path: "logs/*", MinAge: "3d", KeepNewest: 1}, "logs/20.log,logs/30.log,logs/9.log,logs/keep.txt"},
+	}
+	for _, c := range cases {
+		result, err := engine.Plan(c.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(result.Items); got != c.expected {
+			t.Errorf("Expected '%v' to be '%v' (%+v)", got, c.expected, c.rule)
+		}
+		if result.Bytes != int64(len(result.Items))*1024 {
+			t.Errorf("Expected '%v' to be '%v'", result.Bytes, len(result.Items)*1024)
+		}
+	}
+}
+
+func TestPlanMaxTotalDisposesOfOldest(t *testing.T) {
+	files := map[string]int{}
+	for i := 0; i < 1100; i++ {
+		files[fmt.Sprintf("history/f%04d", i)] = i
+	}
+	dir := dataDir(t, files)
+	result, err := Engine{DataDir: dir, Now: testNow}.Plan(Rule{Path: "history/*", MaxTotalMB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1100 KiB in total, 1024 KiB allowed
+	if len(result.Items) != 76 {
+		t.Errorf("Expected '%v' to be '%v'", len(result.Items), 76)
+	}
+	for _, item := range result.Items {
+		if testNow.Sub(item.ModTime) < 1024*24*time.Hour {
+			t.Errorf("Expected '%v' to be among the oldest", item.Rel)
+		}
+	}
+}
+
+func TestRunDeletesUnlessDryRun(t *testing.T) {
+	dir := dataDir(t, map[string]int{"a.csv": 10, "b.csv": 1})
+	settings := Settings{Rules: []Rule{{Path: "*.csv", MinAge: "7d"}}}
+
+	results, err := Engine{DataDir: dir, Now: testNow}.Run(settings, true)
+	if err != nil || len(results) != 1 || results[0].Bytes != 1024 {
+		t.Fatalf("Expected '%v' to free 1024 bytes (%v)", results, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.csv")); err != nil {
+		t.Errorf("Expected a dry run to keep a.csv")
+	}
+
+	if _, err = (Engine{DataDir: dir, Now: testNow}).Run(settings, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.csv")); !os.IsNotExist(err) {
+		t.Errorf("Expected a.csv to be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.csv")); err != nil {
+		t.Errorf("Expected b.csv to be kept")
+	}
+}
+
+func TestArchiveAndMove(t *testing.T) {
+	dir := dataDir(t, map[string]int{"logs/old.log": 10, "MQL5/Logs/old.log": 10, "history/EURUSD/2021.hcc": 400})
+	engine := Engine{DataDir: dir, Now: testNow}
+
+	result, err := engine.Plan(Rule{Name: "logs", Path: "logs/*", Action: ActionArchive, Target: "logs/archive"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = engine.Apply(result); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filepath.Join(dir, "logs", "archive", "logs-20220630-120000.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zipped, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := tar.NewReader(zipped).Next()
+	if err != nil || header.Name != "logs/old.log" || header.Size != 1024 {
+		t.Errorf("Expected '%v' to be '%v' (%v)", header, "logs/old.log", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "logs", "old.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected the archived log to be deleted")
+	}
+	// the archive folder matches the glob, but is never part of its own archive
+	result, _ = engine.Plan(Rule{Name: "logs", Path: "logs/*", Action: ActionArchive, Target: "logs/archive"})
+	if len(result.Items) != 0 {
+		t.Errorf("Expected '%v' to be empty", names(result.Items))
+	}
+
+	root := t.TempDir()
+	engine.Roots = []string{root}
+	result, err = engine.Plan(Rule{Path: "history/*", Action: ActionMove, Target: filepath.Join(root, "history")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = engine.Apply(result); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "history", "history", "EURUSD", "2021.hcc")); err != nil {
+		t.Errorf("Expected the history to be moved: %v", err)
+	}
+}
+
+func TestGuardRefusesOutsideOfRoots(t *testing.T) {
+	dir := dataDir(t, map[string]int{"logs/old.log": 10})
+	outside := dataDir(t, map[string]int{"secret.log": 10})
+	os.Symlink(outside, filepath.Join(dir, "linked"))
+	engine := Engine{DataDir: dir, Now: testNow}
+
+	if _, err := engine.Plan(Rule{Path: "linked/*.log"}); err == nil {
+		t.Errorf("Expected an error for a glob reaching through a link")
+	}
+	if _, err := engine.Plan(Rule{Path: "logs/*", Action: ActionMove, Target: outside}); err == nil {
+		t.Errorf("Expected an error for a target outside of the roots")
+	}
+	if _, err := engine.Plan(Rule{Path: "logs/*", Action: ActionMove, Target: "linked/x"}); err == nil {
+		t.Errorf("Expected an error for a target linked outside of the roots")
+	}
+	for _, invalid := range []Rule{{Path: "../*"}, {Path: "/etc/*"}, {Path: "."}, {Path: "logs/*", Action: "shred"}, {Path: "logs/*", Action: ActionMove}, {Path: "logs/*", MinAge: "soon"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected an error for '%+v'", invalid)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.log")); err != nil {
+		t.Errorf("Expected '%v' to be untouched", filepath.Join(outside, "secret.log"))
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	for age, expected := range map[string]time.Duration{"": 0, "30d": 720 * time.Hour, "90m": 90 * time.Minute} {
+		if parsed, err := ParseAge(age); err != nil || parsed != expected {
+			t.Errorf("Expected '%v' to be '%v' (%v)", parsed, expected, err)
+		}
+	}
+}
+
+func TestTouchesHistory(t *testing.T) {
+	if (Settings{}).TouchesHistory() {
+		t.Errorf("Expected the default rules to spare history")
+	}
+	for _, path := range []string{"bases/*/ticks/*", "*/Broker-Demo/history"} {
+		if !(Settings{Rules: []Rule{{Path: path}}}).TouchesHistory() {
+			t.Errorf("Expected '%v' to touch history", path)
+		}
+	}
+}
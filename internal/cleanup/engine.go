@@ -0,0 +1,342 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cleanup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+// Item is a file or folder selected by a rule. Size and ModTime of folders cover their content.
+type Item struct {
+	Path    string
+	Rel     string
+	Size    int64
+	ModTime time.Time
+	Dir     bool
+}
+
+// Result is what a rule did or, in a dry run, would do.
+type Result struct {
+	Rule  Rule
+	Items []Item
+	// Bytes are freed inside the data dir
+	Bytes int64
+	// Archive is the tar.gz written by the archive action
+	Archive string
+}
+
+// Engine applies rules to a data dir. Nothing outside the data dir and Roots is touched.
+type Engine struct {
+	DataDir string
+	Roots   []string
+	Now     time.Time
+}
+
+// Run plans and, unless dryRun, applies the rules of s in order. A failing rule does not stop the
+// others; the first error is returned.
+func (e Engine) Run(s Settings, dryRun bool) (results []Result, err error) {
+	for _, rule := range s.WithDefaults().Rules {
+		result, errRule := e.Plan(rule)
+		if errRule == nil && !dryRun {
+			result, errRule = e.Apply(result)
+		}
+		if errRule != nil {
+			if err == nil {
+				err = fmt.Errorf("cleanup error: %s: %w", rule.Name, errRule)
+			}
+			continue
+		}
+		results = append(results, result)
+	}
+
+	return
+}
+
+// Plan selects the items of rule.
+func (e Engine) Plan(rule Rule) (result Result, err error) {
+	result.Rule = rule
+	if err = rule.Validate(); err != nil {
+		return
+	}
+	dataDir, err := filepath.EvalSymlinks(e.DataDir)
+	if err != nil {
+		return
+	}
+	target := ""
+	if rule.Action == ActionArchive || rule.Action == ActionMove {
+		if target, err = e.resolveTarget(dataDir, rule.Target); err != nil {
+			return
+		}
+	}
+	matches, err := filepath.Glob(filepath.Join(dataDir, rule.Path))
+	if err != nil {
+		return
+	}
+
+	var items []Item
+	for _, match := range matches {
+		// the folder of a match may be a link pointing elsewhere
+		parent, errParent := filepath.EvalSymlinks(filepath.Dir(match))
+		if errParent != nil || !within(parent, dataDir) || match == dataDir {
+			return result, fmt.Errorf("refusing to act on %s outside of %s", match, dataDir)
+		}
+		if len(target) > 0 && (within(target, match) || within(match, target)) {
+			continue
+		}
+		item, errItem := stat(match)
+		if errItem != nil {
+			return result, errItem
+		}
+		item.Rel, _ = filepath.Rel(dataDir, match)
+		items = append(items, item)
+	}
+	// newest first, so KeepNewest spares the head and MaxTotalMB disposes of the tail
+	sort.Slice(items, func(i, j int) bool { return items[i].ModTime.After(items[j].ModTime) })
+
+	minAge, _ := ParseAge(rule.MinAge)
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+	limit := rule.MaxTotalMB << 20
+	var candidates []Item
+	for i, item := range items {
+		if i < rule.KeepNewest || (minAge > 0 && e.Now.Sub(item.ModTime) < minAge) {
+			continue
+		}
+		candidates = append(candidates, item)
+	}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if rule.MaxTotalMB > 0 && total <= limit {
+			break
+		}
+		result.Items = append(result.Items, candidates[i])
+		result.Bytes += candidates[i].Size
+		total -= candidates[i].Size
+	}
+	if rule.Action == ActionArchive && len(result.Items) > 0 {
+		result.Archive = filepath.Join(target, fmt.Sprintf("%s-%s.tar.gz", rule.Name, e.Now.UTC().Format("20060102-150405")))
+	}
+
+	return
+}
+
+// Apply disposes of the items of a planned result.
+func (e Engine) Apply(result Result) (Result, error) {
+	if len(result.Items) == 0 {
+		return result, nil
+	}
+	switch result.Rule.Action {
+	case ActionArchive:
+		if err := writeArchive(result.Archive, result.Items); err != nil {
+			return result, err
+		}
+		return result, removeItems(result.Items)
+	case ActionMove:
+		dataDir, _ := filepath.EvalSymlinks(e.DataDir)
+		target, err := e.resolveTarget(dataDir, result.Rule.Target)
+		if err != nil {
+			return result, err
+		}
+		for _, item := range result.Items {
+			if err = move(item, filepath.Join(target, item.Rel)); err != nil {
+				return result, err
+			}
+		}
+		return result, nil
+	default:
+		return result, removeItems(result.Items)
+	}
+}
+
+// resolveTarget returns the absolute target folder, refusing one outside of the data dir and Roots.
+func (e Engine) resolveTarget(dataDir, target string) (string, error) {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dataDir, target)
+	}
+	target = filepath.Clean(target)
+	// the target may not exist yet, so the links of its closest existing folder are resolved
+	existing, rest := target, ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			target = filepath.Join(resolved, rest)
+			break
+		}
+		if existing == "/" {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+	for _, root := range append([]string{dataDir}, e.Roots...) {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		if within(target, root) {
+			return target, nil
+		}
+	}
+
+	return "", fmt.Errorf("refusing target %s outside of the data dir and the configured roots", target)
+}
+
+// within tells whether path is root or inside of it.
+func within(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+func stat(path string) (item Item, err error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+	item = Item{Path: path, Size: info.Size(), ModTime: info.ModTime(), Dir: info.IsDir()}
+	if !item.Dir {
+		return
+	}
+	item.Size = 0
+	err = filepath.WalkDir(path, func(_ string, entry fs.DirEntry, errWalk error) error {
+		if errWalk != nil {
+			return errWalk
+		}
+		info, errInfo := entry.Info()
+		if errInfo != nil {
+			return errInfo
+		}
+		if !entry.IsDir() {
+			item.Size += info.Size()
+		}
+		if info.ModTime().After(item.ModTime) {
+			item.ModTime = info.ModTime()
+		}
+		return nil
+	})
+
+	return
+}
+
+func removeItems(items []Item) error {
+	for _, item := range items {
+		if err := os.RemoveAll(item.Path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func move(item Item, dst string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return
+	}
+	if err = hlp.Adopt(filepath.Dir(dst)); err != nil {
+		return
+	}
+	if err = os.Rename(item.Path, dst); err == nil || !errors.Is(err, syscall.EXDEV) {
+		return
+	}
+	// across file systems
+	if item.Dir {
+		err = hlp.CopyTree(item.Path, dst, func(string) bool { return false })
+	} else {
+		err = hlp.CopyFile(item.Path, dst)
+	}
+	if err != nil {
+		return
+	}
+
+	return os.RemoveAll(item.Path)
+}
+
+// writeArchive packs items, named relative to the data dir, into a tar.gz only readable by its owner.
+func writeArchive(path string, items []Item) (err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	if err = hlp.Adopt(filepath.Dir(path)); err != nil {
+		return
+	}
+	tmp := path + ".part"
+	file, err := hlp.CreateFresh(tmp, 0600)
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp)
+	zipped := gzip.NewWriter(file)
+	archive := tar.NewWriter(zipped)
+	for _, item := range items {
+		errWalk := filepath.WalkDir(item.Path, func(path string, entry fs.DirEntry, errWalk error) error {
+			if errWalk != nil {
+				return errWalk
+			}
+			return addToArchive(archive, item, path, entry)
+		})
+		if errWalk != nil {
+			file.Close()
+			return errWalk
+		}
+	}
+	for _, closer := range []io.Closer{archive, zipped, file} {
+		if errClose := closer.Close(); errClose != nil && err == nil {
+			err = errClose
+		}
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return
+	}
+
+	return hlp.Adopt(path)
+}
+
+// addToArchive writes path, which is item or inside of it, named relative to the data dir.
+func addToArchive(archive *tar.Writer, item Item, path string, entry fs.DirEntry) (err error) {
+	info, err := entry.Info()
+	if err != nil {
+		return
+	}
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return
+	}
+	rel, _ := filepath.Rel(item.Path, path)
+	header.Name = filepath.ToSlash(filepath.Join(item.Rel, rel))
+	if err = archive.WriteHeader(header); err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, err = io.Copy(archive, file)
+
+	return
+}
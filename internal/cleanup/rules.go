@@ -0,0 +1,147 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cleanup
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ActionDelete  = "delete"
+	ActionArchive = "archive"
+	ActionMove    = "move"
+
+	// historyDir holds the history and tick data of each trade server
+	historyDir = "bases"
+)
+
+// DefaultRules apply unless the config file lists its own. History and ticks are kept, as the
+// terminal would download them again.
+var DefaultRules = []Rule{
+	{Name: "terminal-logs", Path: "logs/*.log", MinAge: "7d", KeepNewest: 3},
+	{Name: "expert-logs", Path: "MQL5/Logs/*.log", MinAge: "7d", KeepNewest: 3},
+	{Name: "exports", Path: "*.csv", MinAge: "7d"},
+}
+
+// Settings configure the cleanup of the terminal data dir.
+type Settings struct {
+	// Rules are applied in order; unset means DefaultRules
+	Rules []Rule `json:"rules,omitempty"`
+	// Roots are absolute folders besides the data dir which archives and moved files may go to
+	Roots []string `json:"roots,omitempty"`
+}
+
+// Rule selects files or folders inside the terminal data dir and disposes of them.
+type Rule struct {
+	Name string `json:"name,omitempty"`
+	// Path is a glob relative to the data dir, e.g. "logs/*.log" or "history/*/ticks"
+	Path string `json:"path"`
+	// MinAge spares matches modified more recently, as Go duration or in days like "30d"
+	MinAge string `json:"minAge,omitempty"`
+	// KeepNewest spares the newest matches
+	KeepNewest int `json:"keepNewest,omitempty"`
+	// MaxTotalMB only disposes of the oldest matches until the rest fits
+	MaxTotalMB int64 `json:"maxTotalMB,omitempty"`
+	// Action is delete (default), archive (into a tar.gz) or move
+	Action string `json:"action,omitempty"`
+	// Target is the folder of archives and moved files, relative to the data dir or inside Roots
+	Target string `json:"target,omitempty"`
+}
+
+// WithDefaults fills unset fields.
+func (s Settings) WithDefaults() Settings {
+	if s.Rules == nil {
+		s.Rules = DefaultRules
+	}
+	for i := range s.Rules {
+		if len(s.Rules[i].Action) == 0 {
+			s.Rules[i].Action = ActionDelete
+		}
+		if len(s.Rules[i].Name) == 0 {
+			s.Rules[i].Name = fmt.Sprintf("rule-%d", i+1)
+		}
+	}
+
+	return s
+}
+
+// Validate checks the settings which do not depend on the environment.
+func (s Settings) Validate() error {
+	for _, root := range s.Roots {
+		if !filepath.IsAbs(root) || filepath.Clean(root) == "/" {
+			return fmt.Errorf("cleanup error: root %s needs to be an absolute folder other than /", root)
+		}
+	}
+	for i, rule := range s.Rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("cleanup error: rule %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// TouchesHistory tells whether a rule may dispose of history or tick data, which the terminal reads
+// while running.
+func (s Settings) TouchesHistory() bool {
+	for _, rule := range s.WithDefaults().Rules {
+		first := strings.SplitN(filepath.ToSlash(filepath.Clean(rule.Path)), "/", 2)[0]
+		if matched, _ := filepath.Match(first, historyDir); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks a rule.
+func (r Rule) Validate() error {
+	clean := filepath.Clean(r.Path)
+	if len(r.Path) == 0 || clean == "." || filepath.IsAbs(r.Path) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("path %q needs to be a glob inside the data dir", r.Path)
+	}
+	if _, err := filepath.Match(r.Path, ""); err != nil {
+		return fmt.Errorf("path %q: %w", r.Path, err)
+	}
+	if _, err := ParseAge(r.MinAge); err != nil {
+		return err
+	}
+	if r.KeepNewest < 0 || r.MaxTotalMB < 0 {
+		return fmt.Errorf("keepNewest and maxTotalMB must not be negative")
+	}
+	switch r.Action {
+	case "", ActionDelete:
+	case ActionArchive, ActionMove:
+		if len(r.Target) == 0 {
+			return fmt.Errorf("action %s needs a target", r.Action)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	return nil
+}
+
+// ParseAge reads a Go duration or a number of days like "30d". Empty means no minimum age.
+func ParseAge(age string) (time.Duration, error) {
+	if len(age) == 0 {
+		return 0, nil
+	}
+	if days := strings.TrimSuffix(age, "d"); days != age {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if duration, err := time.ParseDuration(age); err == nil && duration >= 0 {
+		return duration, nil
+	}
+
+	return 0, fmt.Errorf("invalid age %q", age)
+}
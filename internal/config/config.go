@@ -0,0 +1,141 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/9tmark/avly-trader/internal/cleanup"
+	"github.com/9tmark/avly-trader/internal/display"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	"github.com/9tmark/avly-trader/internal/prefix"
+	"github.com/9tmark/avly-trader/internal/recording"
+	"github.com/9tmark/avly-trader/internal/schedule"
+	"github.com/9tmark/avly-trader/internal/vnc"
+)
+
+// DefaultPath is used unless AVL_CONFIG names another file.
+const DefaultPath = "/etc/avly-trader/config.json"
+
+// LiveInstance names the trading terminal, as opposed to tester instances.
+const LiveInstance = "live"
+
+// Config holds the settings too structured for environment variables. A missing file means defaults.
+type Config struct {
+	// Display applies to every instance without its own display settings
+	Display display.Geometry `json:"display"`
+	// Instances are keyed by instance name ("live", "backtest", "farm-0", ...) or by its prefix ("farm")
+	Instances map[string]InstanceConfig `json:"instances,omitempty"`
+	// VNC configures access to the VNC server of the live instance
+	VNC vnc.Settings `json:"vnc"`
+	// Recording configures the recorder of the live display
+	Recording recording.Settings `json:"recording"`
+	// Wine configures the Wine prefix of all instances
+	Wine prefix.Settings `json:"wine"`
+	// Cleanup configures what is disposed of inside the terminal data dir
+	Cleanup cleanup.Settings `json:"cleanup"`
+	// Schedule configures the maintenance tasks run by the watching container process
+	Schedule schedule.Settings `json:"schedule"`
+}
+
+type InstanceConfig struct {
+	Display display.Geometry `json:"display"`
+}
+
+// Path returns the location of the config file of env.
+func Path(env *[]string) string {
+	if path := hlp.EnvValue(env, "AVL_CONFIG"); len(path) > 0 {
+		return path
+	}
+
+	return DefaultPath
+}
+
+// Load reads and validates the config file of env.
+func Load(env *[]string) (cfg Config, err error) {
+	path := Path(env)
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(raw, &cfg); err != nil {
+		err = fmt.Errorf("config error: invalid %s: %w", path, err)
+		return
+	}
+	if err = cfg.Validate(); err != nil {
+		err = fmt.Errorf("config error: %s: %w", path, err)
+	}
+
+	return
+}
+
+// Validate checks the settings of all instances.
+func (c Config) Validate() error {
+	if err := c.DisplayOf(LiveInstance).Validate(); err != nil {
+		return err
+	}
+	if err := c.VNC.Validate(); err != nil {
+		return err
+	}
+	if err := c.Recording.Validate(); err != nil {
+		return err
+	}
+	if err := c.Wine.Validate(); err != nil {
+		return err
+	}
+	if err := c.Cleanup.Validate(); err != nil {
+		return err
+	}
+	if err := c.Schedule.Validate(); err != nil {
+		return err
+	}
+	for name := range c.Instances {
+		if err := c.DisplayOf(name).Validate(); err != nil {
+			return fmt.Errorf("instance %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// DisplayOf returns the display geometry of the instance name: its own settings, those of its prefix,
+// the common ones and the defaults, in that order.
+func (c Config) DisplayOf(name string) display.Geometry {
+	geometry := c.Display
+	prefix, _, _ := strings.Cut(name, "-")
+	for _, key := range []string{prefix, name} {
+		if instance, ok := c.Instances[key]; ok {
+			geometry = merge(geometry, instance.Display)
+		}
+	}
+
+	return geometry.WithDefaults()
+}
+
+func merge(base, override display.Geometry) display.Geometry {
+	if override.Width > 0 {
+		base.Width = override.Width
+	}
+	if override.Height > 0 {
+		base.Height = override.Height
+	}
+	if override.Depth > 0 {
+		base.Depth = override.Depth
+	}
+	if override.DPI > 0 {
+		base.DPI = override.DPI
+	}
+
+	return base
+}
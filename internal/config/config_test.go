@@ -0,0 +1,64 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/9tmark/avly-trader/internal/display"
+)
+
+func TestLoadMissingFileUsesDefaults(t *testing.T) {
+	env := []string{"AVL_CONFIG=" + filepath.Join(t.TempDir(), "config.json")}
+
+	cfg, err := Load(&env)
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	if geometry := cfg.DisplayOf(LiveInstance); geometry != display.DefaultGeometry {
+		t.Errorf("Expected '%v' to be '%v'", geometry, display.DefaultGeometry)
+	}
+}
+
+func TestDisplayOfInstance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{
+		"display": {"width": 1920, "height": 1080},
+		"instances": {
+			"farm": {"display": {"width": 1024, "height": 768}},
+			"farm-1": {"display": {"dpi": 120}}
+		}
+	}`), 0644)
+	env := []string{"AVL_CONFIG=" + path}
+
+	cfg, err := Load(&env)
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	cases := map[string]display.Geometry{
+		LiveInstance: {Width: 1920, Height: 1080, Depth: 24, DPI: 96},
+		"farm-0":     {Width: 1024, Height: 768, Depth: 24, DPI: 96},
+		"farm-1":     {Width: 1024, Height: 768, Depth: 24, DPI: 120},
+	}
+	for name, expected := range cases {
+		if geometry := cfg.DisplayOf(name); geometry != expected {
+			t.Errorf("%s: Expected '%v' to be '%v'", name, geometry, expected)
+		}
+	}
+}
+
+func TestLoadRejectsUnsupportedDepth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"instances": {"backtest": {"display": {"depth": 32}}}}`), 0644)
+	env := []string{"AVL_CONFIG=" + path}
+
+	if _, err := Load(&env); err == nil {
+		t.Errorf("Expected depth 32 to be rejected")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package control
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// handleMetrics serves the counters of the status document in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteMetrics(w, s.Store.Snapshot())
+}
+
+// WriteMetrics writes the journal events by kind (authorizations, connection losses, trades, failed
+// orders, ...), the failed orders by return code and whether the instance is healthy.
+func WriteMetrics(w io.Writer, st status.Status) {
+	journal := st.Journal
+	fmt.Fprintln(w, "# HELP avly_journal_events_total Events of the terminal journal and expert log by kind.")
+	fmt.Fprintln(w, "# TYPE avly_journal_events_total counter")
+	kinds := make([]string, 0, len(journal.Counts))
+	for kind := range journal.Counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "avly_journal_events_total{kind=%q} %d\n", kind, journal.Counts[kind])
+	}
+
+	fmt.Fprintln(w, "# HELP avly_order_failures_total Failed orders by trade server return code.")
+	fmt.Fprintln(w, "# TYPE avly_order_failures_total counter")
+	retcodes := make([]int, 0, len(journal.Retcodes))
+	for retcode := range journal.Retcodes {
+		retcodes = append(retcodes, retcode)
+	}
+	sort.Ints(retcodes)
+	for _, retcode := range retcodes {
+		fmt.Fprintf(w, "avly_order_failures_total{retcode=%q} %d\n", strconv.Itoa(retcode), journal.Retcodes[retcode])
+	}
+
+	healthy := 0
+	if len(st.Unhealthy()) == 0 {
+		healthy = 1
+	}
+	fmt.Fprintln(w, "# HELP avly_healthy Whether the instance is healthy, as 'avly -status' tells.")
+	fmt.Fprintln(w, "# TYPE avly_healthy gauge")
+	fmt.Fprintf(w, "avly_healthy %d\n", healthy)
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package control
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/9tmark/avly-trader/internal/mt5"
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+func TestServerMetricsCountJournalEvents(t *testing.T) {
+	server, _ := newTestServer(t)
+	entry, _ := mt5.ParseLogLine("KO\t2\t10:00:00.000\tTrades\t'1234': failed market buy 0.1 EURUSD [Market closed]", time.Date(2022, 5, 4, 0, 0, 0, 0, time.UTC), mt5.OriginTerminal)
+	event, ok := mt5.ClassifyEntry(entry)
+	if !ok {
+		t.Fatalf("Expected '%+v' to be an event", entry)
+	}
+	record := func() {
+		server.Store.Update(func(s *status.Status) {
+			s.Journal.AddEvent(status.Event{Time: event.Time, Kind: string(event.Kind), Source: event.Source, Detail: event.Detail, Retcode: event.Retcode})
+		})
+	}
+	metrics := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected code '%d' to be '%d'", rec.Code, http.StatusOK)
+		}
+		return rec.Body.String()
+	}
+
+	record()
+	if body := metrics(); !strings.Contains(body, `avly_journal_events_total{kind="order_failed"} 1`+"\n") || !strings.Contains(body, `avly_order_failures_total{retcode="10018"} 1`+"\n") {
+		t.Errorf("Expected one failed order with retcode 10018 in '%s'", body)
+	}
+	record()
+	if body := metrics(); !strings.Contains(body, `avly_order_failures_total{retcode="10018"} 2`+"\n") {
+		t.Errorf("Expected the counter to go up in '%s'", body)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected code '%d' to be '%d'", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/9tmark/avly-trader/internal/display"
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// DefaultAddr keeps the control API on the container's loopback unless AVL_HTTP_ADDR says otherwise.
+const DefaultAddr = "127.0.0.1:7300"
+
+// Server is the control API of the watching 'enter' process. Except for /healthz and public routes,
+// every endpoint requires the bearer Token, if one is set. WebSocket requests may pass it as query
+// parameter "token".
+type Server struct {
+	Addr  string
+	Token string
+	Env   *[]string
+	Store *status.Store
+	mux   *http.ServeMux
+}
+
+// Handler returns the routes of the control API.
+func (s *Server) Handler() http.Handler {
+	if s.mux == nil {
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc("/healthz", s.handleHealth)
+		s.mux.HandleFunc("/status", s.authorized(s.handleStatus))
+		s.mux.HandleFunc("/screenshot/latest", s.authorized(s.handleLatestScreenshot))
+		s.mux.HandleFunc("/metrics", s.authorized(s.handleMetrics))
+	}
+
+	return s.mux
+}
+
+// Handle adds a route to the control API, guarded by the bearer token.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.Handler()
+	s.mux.HandleFunc(pattern, s.authorized(handler.ServeHTTP))
+}
+
+// HandlePublic adds a route without token, e.g. for static files.
+func (s *Server) HandlePublic(pattern string, handler http.Handler) {
+	s.Handler()
+	s.mux.Handle(pattern, handler)
+}
+
+// ListenAndServe serves the control API until the process ends.
+func (s *Server) ListenAndServe() error {
+	return http.ListenAndServe(s.Addr, s.Handler())
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.Token) > 0 {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			// browsers cannot set headers on WebSocket requests
+			if len(token) == 0 && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				token = r.URL.Query().Get("token")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// handleHealth answers 200 or 503 with the reasons, as 'avly -status' does with its exit code.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	reasons := s.Store.Snapshot().Unhealthy()
+	w.Header().Set("Content-Type", "application/json")
+	if len(reasons) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"healthy": len(reasons) == 0, "reasons": reasons})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Store.Snapshot())
+}
+
+func (s *Server) handleLatestScreenshot(w http.ResponseWriter, r *http.Request) {
+	path := display.LatestScreenshot(s.Env)
+	if len(path) == 0 {
+		http.Error(w, "no screenshot available", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package control
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+func newTestServer(t *testing.T) (*Server, string) {
+	logs := t.TempDir()
+	env := []string{"AVL_LOGS=" + logs}
+	store := status.NewStore(t.TempDir())
+	store.Update(func(s *status.Status) { s.Broker.Healthy = true })
+
+	return &Server{Token: "secret", Env: &env, Store: store}, logs
+}
+
+func TestServerRequiresToken(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	cases := map[string]int{"": http.StatusUnauthorized, "Bearer wrong": http.StatusUnauthorized, "Bearer secret": http.StatusOK}
+	for header, expected := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if len(header) > 0 {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		if rec.Code != expected {
+			t.Errorf("%q: Expected code '%d' to be '%d'", header, rec.Code, expected)
+		}
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected code '%d' to be '%d'", rec.Code, http.StatusOK)
+	}
+
+	server.Store.Update(func(s *status.Status) { s.Terminal.Hung, s.Terminal.Reason = true, "error-dialog" })
+	rec = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected code '%d' to be '%d'", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServerLatestScreenshot(t *testing.T) {
+	server, logs := newTestServer(t)
+	req := httptest.NewRequest(http.MethodGet, "/screenshot/latest", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected code '%d' to be '%d'", rec.Code, http.StatusNotFound)
+	}
+
+	dir := filepath.Join(logs, "screenshots")
+	os.MkdirAll(dir, 0750)
+	os.WriteFile(filepath.Join(dir, "20221001-100000.000-hung.png"), []byte("old"), 0640)
+	os.WriteFile(filepath.Join(dir, "20221002-100000.000-manual.png"), []byte("new"), 0640)
+	rec = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "new" {
+		t.Errorf("Expected newest screenshot, got code '%d' and body '%s'", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServerAcceptsQueryTokenForWebSockets(t *testing.T) {
+	server, _ := newTestServer(t)
+	server.Handle("/ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cases := map[bool]int{true: http.StatusOK, false: http.StatusUnauthorized}
+	for upgrade, expected := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/ws?token=secret", nil)
+		if upgrade {
+			req.Header.Set("Upgrade", "websocket")
+		}
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		if rec.Code != expected {
+			t.Errorf("upgrade %t: Expected code '%d' to be '%d'", upgrade, rec.Code, expected)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package control
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+// LoadToken reads the bearer token of the control API from AVL_HTTP_TOKEN_FILE or
+// $AVL_SECRETS_DIR/avly_http_token. A missing file means no token.
+func LoadToken(env *[]string) (token string, err error) {
+	path := hlp.EnvValue(env, "AVL_HTTP_TOKEN_FILE")
+	if len(path) == 0 {
+		path = filepath.Join(hlp.EnvValue(env, "AVL_SECRETS_DIR"), "avly_http_token")
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("control error: could not read token: %w", err)
+	}
+
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// IsLoopback tells whether addr (host:port) only listens on the loopback interface.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+// defaultScreenshotsKept bounds the ring of screenshots unless AVL_SCREENSHOTS_KEEP says otherwise
+const defaultScreenshotsKept = 50
+
+// ScreenshotDir returns the folder keeping the ring of recent screenshots.
+func ScreenshotDir(env *[]string) string {
+	return filepath.Join(hlp.EnvValue(env, "AVL_LOGS"), "screenshots")
+}
+
+// Capture saves the framebuffer of the display of env as PNG into the screenshot ring and drops the
+// oldest images beyond AVL_SCREENSHOTS_KEEP. reason becomes part of the file name.
+func Capture(runner ifc.CmdRunner, env *[]string, reason string) (path string, err error) {
+	dir := ScreenshotDir(env)
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return
+	}
+	name := time.Now().Format("20060102-150405.000") + "-" + reason
+	img, err := Grab(runner, env, filepath.Join(dir, "."+name+".xwd"))
+	if err != nil {
+		return
+	}
+	path = filepath.Join(dir, name+".png")
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return
+	}
+	if err = png.Encode(out, img); err != nil {
+		out.Close()
+		os.Remove(path)
+		return
+	}
+	if err = out.Close(); err != nil {
+		return
+	}
+	pruneScreenshots(dir, screenshotsKept(env))
+
+	return
+}
+
+// Grab dumps the framebuffer of the display of env through the temporary file dump and decodes it.
+func Grab(runner ifc.CmdRunner, env *[]string, dump string) (img *image.RGBA, err error) {
+	defer os.Remove(dump)
+	if _, _, err = runner.RunCmdSync("xwd -root -silent -display $DISPLAY -out "+hlp.ShellQuote(dump), env); err != nil {
+		return
+	}
+	in, err := os.Open(dump)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	decoded, err := DecodeXWD(in)
+	if err != nil {
+		return
+	}
+
+	return decoded.(*image.RGBA), nil
+}
+
+// LatestScreenshot returns the path of the newest screenshot or an empty string.
+func LatestScreenshot(env *[]string) string {
+	shots := listScreenshots(ScreenshotDir(env))
+	if len(shots) == 0 {
+		return ""
+	}
+
+	return shots[len(shots)-1]
+}
+
+func screenshotsKept(env *[]string) int {
+	if kept, err := strconv.Atoi(hlp.EnvValue(env, "AVL_SCREENSHOTS_KEEP")); err == nil && kept > 0 {
+		return kept
+	}
+
+	return defaultScreenshotsKept
+}
+
+// listScreenshots returns the PNG files of dir, oldest first (names start with their time).
+func listScreenshots(dir string) (shots []string) {
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".png") {
+			shots = append(shots, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(shots)
+
+	return
+}
+
+func pruneScreenshots(dir string, kept int) {
+	shots := listScreenshots(dir)
+	for len(shots) > kept {
+		os.Remove(shots[0])
+		shots = shots[1:]
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+type DialogAction string
+
+const (
+	// DialogKey presses Keys (xdotool key names, e.g. "Return" or "alt+n")
+	DialogKey DialogAction = "key"
+	// DialogClick clicks at X, Y relative to the window's size (0..1)
+	DialogClick DialogAction = "click"
+	// DialogClose closes the window like the user would with alt+F4
+	DialogClose DialogAction = "close"
+)
+
+// maxDismissAttempts stops retrying a window which withstands its rule, e.g. after a wrong click position
+const maxDismissAttempts = 3
+
+// DialogRule maps windows, matched by title and/or class pattern, to the action dismissing them.
+type DialogRule struct {
+	Name   string       `json:"name"`
+	Title  string       `json:"title,omitempty"`
+	Class  string       `json:"class,omitempty"`
+	Action DialogAction `json:"action"`
+	Keys   string       `json:"keys,omitempty"`
+	X      float64      `json:"x,omitempty"`
+	Y      float64      `json:"y,omitempty"`
+	title  *regexp.Regexp
+	class  *regexp.Regexp
+}
+
+// BuiltinDialogRules accept the Wine add-on installers and postpone the terminal's update prompts,
+// as updates are rolled out with a restart of the container rather than in the middle of trading.
+var BuiltinDialogRules = []DialogRule{
+	{Name: "wine-mono", Title: `(?i)^wine mono installer`, Action: DialogKey, Keys: "Return"},
+	{Name: "wine-gecko", Title: `(?i)^wine gecko installer`, Action: DialogKey, Keys: "Return"},
+	{Name: "mt5-installer", Title: `(?i)^metatrader 5.*setup`, Action: DialogKey, Keys: "Return"},
+	{Name: "update-available", Title: `(?i)(new version|update) .*available`, Action: DialogClose},
+	{Name: "live-update", Title: `(?i)^live ?update`, Action: DialogClose},
+}
+
+// Dismissal records a handled dialog.
+type Dismissal struct {
+	Rule       string
+	Window     Window
+	Screenshot string
+	Err        error
+}
+
+// DialogHandler dismisses known modal dialogs on the display.
+type DialogHandler struct {
+	rules    []DialogRule
+	attempts map[string]int
+}
+
+var windowIDRegex = regexp.MustCompile(`^\d+$`)
+
+// LoadDialogRules reads a JSON array of rules, which take precedence over the built-in ones.
+func LoadDialogRules(path string) (rules []DialogRule, err error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(raw, &rules); err != nil {
+		err = fmt.Errorf("dialog error: invalid rules %s: %w", path, err)
+	}
+
+	return
+}
+
+// NewDialogHandler validates and compiles rules.
+func NewDialogHandler(rules []DialogRule) (handler *DialogHandler, err error) {
+	handler = &DialogHandler{attempts: map[string]int{}}
+	for _, rule := range rules {
+		if len(rule.Title) == 0 && len(rule.Class) == 0 {
+			return nil, fmt.Errorf("dialog error: rule %q needs a title or class pattern", rule.Name)
+		}
+		switch rule.Action {
+		case DialogKey:
+			if len(rule.Keys) == 0 || strings.ContainsAny(rule.Keys, "'\"`$;&|<> ") {
+				return nil, fmt.Errorf("dialog error: rule %q needs plain xdotool key names", rule.Name)
+			}
+		case DialogClick:
+			if rule.X < 0 || rule.X > 1 || rule.Y < 0 || rule.Y > 1 {
+				return nil, fmt.Errorf("dialog error: rule %q needs a click position between 0 and 1", rule.Name)
+			}
+		case DialogClose:
+		default:
+			return nil, fmt.Errorf("dialog error: rule %q has unknown action %q", rule.Name, rule.Action)
+		}
+		if len(rule.Title) > 0 {
+			if rule.title, err = regexp.Compile(rule.Title); err != nil {
+				return nil, fmt.Errorf("dialog error: rule %q: %w", rule.Name, err)
+			}
+		}
+		if len(rule.Class) > 0 {
+			if rule.class, err = regexp.Compile(rule.Class); err != nil {
+				return nil, fmt.Errorf("dialog error: rule %q: %w", rule.Name, err)
+			}
+		}
+		handler.rules = append(handler.rules, rule)
+	}
+
+	return
+}
+
+// Match returns the first rule matching window.
+func (h *DialogHandler) Match(window Window) (rule DialogRule, ok bool) {
+	for _, rule = range h.rules {
+		if rule.title != nil && !rule.title.MatchString(window.Title) {
+			continue
+		}
+		if rule.class != nil && !rule.class.MatchString(window.Class) {
+			continue
+		}
+		return rule, true
+	}
+
+	return DialogRule{}, false
+}
+
+// Dismiss handles every known dialog on the display of env. Each one is captured before it is touched.
+func (h *DialogHandler) Dismiss(runner ifc.CmdRunner, env *[]string) (dismissals []Dismissal, err error) {
+	windows, err := ListWindows(runner, env)
+	if err != nil {
+		return
+	}
+	for _, window := range windows {
+		rule, ok := h.Match(window)
+		if !ok || !windowIDRegex.MatchString(window.ID) || h.attempts[window.ID] >= maxDismissAttempts {
+			continue
+		}
+		h.attempts[window.ID]++
+		dismissal := Dismissal{Rule: rule.Name, Window: window}
+		dismissal.Screenshot, _ = Capture(runner, env, "dialog-"+rule.Name)
+		_, _, dismissal.Err = runner.RunCmdSync(rule.CmdLine(window.ID), env)
+		dismissals = append(dismissals, dismissal)
+	}
+
+	return
+}
+
+// CmdLine returns the xdotool command carrying out the rule on the window with id.
+func (r DialogRule) CmdLine(id string) string {
+	activate := "xdotool windowactivate --sync " + id
+	switch r.Action {
+	case DialogKey:
+		return activate + " key --clearmodifiers " + r.Keys
+	case DialogClick:
+		return fmt.Sprintf("eval $(xdotool getwindowgeometry --shell %s) && %s mousemove --window %s $((WIDTH*%d/1000)) $((HEIGHT*%d/1000)) click 1",
+			id, activate, id, int(r.X*1000), int(r.Y*1000))
+	}
+
+	return activate + " key --clearmodifiers alt+F4"
+}
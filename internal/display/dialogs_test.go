@@ -0,0 +1,57 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"testing"
+)
+
+func TestBuiltinDialogRulesMatch(t *testing.T) {
+	handler, err := NewDialogHandler(BuiltinDialogRules)
+	if err != nil {
+		t.Fatalf("Expected built-in rules to be valid: %s", err.Error())
+	}
+
+	cases := map[string]string{
+		"Wine Mono Installer":                      "wine-mono",
+		"Wine Gecko Installer":                     "wine-gecko",
+		"MetaTrader 5 Setup":                       "mt5-installer",
+		"New version of MetaTrader 5 is available": "update-available",
+		"LiveUpdate":                               "live-update",
+	}
+	for title, expected := range cases {
+		rule, ok := handler.Match(Window{ID: "1", Title: title})
+		if !ok || rule.Name != expected {
+			t.Errorf("%q: Expected rule '%s' to be '%s'", title, rule.Name, expected)
+		}
+	}
+	if rule, ok := handler.Match(Window{ID: "1", Title: "1234 - Broker-Live: Demo Account - [EURUSD,H1]"}); ok {
+		t.Errorf("Expected main window not to match, got rule '%s'", rule.Name)
+	}
+}
+
+func TestNewDialogHandlerRejectsInvalidRules(t *testing.T) {
+	invalid := []DialogRule{
+		{Name: "no-pattern", Action: DialogClose},
+		{Name: "injection", Title: "x", Action: DialogKey, Keys: "Return; rm -rf /"},
+		{Name: "off-window", Title: "x", Action: DialogClick, X: 1.5},
+		{Name: "unknown", Title: "x", Action: "explode"},
+	}
+	for _, rule := range invalid {
+		if _, err := NewDialogHandler([]DialogRule{rule}); err == nil {
+			t.Errorf("%s: Expected rule to be rejected", rule.Name)
+		}
+	}
+}
+
+func TestDialogRuleCmdLine(t *testing.T) {
+	click := DialogRule{Action: DialogClick, X: 0.75, Y: 0.9}
+	expected := "eval $(xdotool getwindowgeometry --shell 42) && xdotool windowactivate --sync 42 mousemove --window 42 $((WIDTH*750/1000)) $((HEIGHT*900/1000)) click 1"
+	if cmdLine := click.CmdLine("42"); cmdLine != expected {
+		t.Errorf("Expected '%s' to be '%s'", cmdLine, expected)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+// XvfbDepths are the screen depths Xvfb accepts.
+var XvfbDepths = []int{8, 15, 16, 24, 30}
+
+// DefaultGeometry uses 24 bits, as 16 bits leave artifacts in the terminal's charts.
+var DefaultGeometry = Geometry{Width: 1366, Height: 768, Depth: 24, DPI: 96}
+
+// Geometry is the size, depth and resolution of a virtual screen.
+type Geometry struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Depth  int `json:"depth"`
+	DPI    int `json:"dpi"`
+}
+
+var (
+	dimensionsRegex = regexp.MustCompile(`dimensions:\s+(\d+)x(\d+) pixels`)
+	rootDepthRegex  = regexp.MustCompile(`depth of root window:\s+(\d+) planes`)
+	resolutionRegex = regexp.MustCompile(`resolution:\s+(\d+)x\d+ dots per inch`)
+)
+
+// WithDefaults fills unset fields from DefaultGeometry.
+func (g Geometry) WithDefaults() Geometry {
+	if g.Width == 0 {
+		g.Width = DefaultGeometry.Width
+	}
+	if g.Height == 0 {
+		g.Height = DefaultGeometry.Height
+	}
+	if g.Depth == 0 {
+		g.Depth = DefaultGeometry.Depth
+	}
+	if g.DPI == 0 {
+		g.DPI = DefaultGeometry.DPI
+	}
+
+	return g
+}
+
+// Validate checks the geometry against what Xvfb and the terminal can work with.
+func (g Geometry) Validate() error {
+	if g.Width < 800 || g.Width > 8192 || g.Height < 600 || g.Height > 8192 {
+		return fmt.Errorf("display error: %dx%d is outside of 800x600 to 8192x8192", g.Width, g.Height)
+	}
+	supported := false
+	for _, depth := range XvfbDepths {
+		supported = supported || depth == g.Depth
+	}
+	if !supported {
+		return fmt.Errorf("display error: depth %d is not supported by Xvfb (%v)", g.Depth, XvfbDepths)
+	}
+	if g.DPI < 48 || g.DPI > 480 {
+		return fmt.Errorf("display error: %d DPI is outside of 48 to 480", g.DPI)
+	}
+
+	return nil
+}
+
+// WHD returns the screen argument of Xvfb, e.g. 1366x768x24.
+func (g Geometry) WHD() string {
+	return fmt.Sprintf("%dx%dx%d", g.Width, g.Height, g.Depth)
+}
+
+func (g Geometry) String() string {
+	return fmt.Sprintf("%s@%ddpi", g.WHD(), g.DPI)
+}
+
+// QueryGeometry reads the geometry of the running X server of env.
+func QueryGeometry(runner ifc.CmdRunner, env *[]string) (geometry Geometry, err error) {
+	out, _, err := runner.RunCmdSync("xdpyinfo -display $DISPLAY", env)
+	if err != nil {
+		return
+	}
+
+	return ParseXdpyinfo(out)
+}
+
+// ParseXdpyinfo reads the geometry of the default screen from the output of xdpyinfo.
+func ParseXdpyinfo(out string) (geometry Geometry, err error) {
+	dimensions := dimensionsRegex.FindStringSubmatch(out)
+	depth := rootDepthRegex.FindStringSubmatch(out)
+	if dimensions == nil || depth == nil {
+		err = fmt.Errorf("display error: unexpected xdpyinfo output")
+		return
+	}
+	geometry.Width, _ = strconv.Atoi(dimensions[1])
+	geometry.Height, _ = strconv.Atoi(dimensions[2])
+	geometry.Depth, _ = strconv.Atoi(depth[1])
+	if resolution := resolutionRegex.FindStringSubmatch(out); resolution != nil {
+		geometry.DPI, _ = strconv.Atoi(resolution[1])
+	}
+
+	return
+}
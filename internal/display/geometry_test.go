@@ -0,0 +1,48 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"testing"
+)
+
+const xdpyinfoOut = `name of display:    :1
+version number:    11.0
+screen #0:
+  dimensions:    1920x1080 pixels (406x229 millimeters)
+  resolution:    120x120 dots per inch
+  depths (6):    24, 1, 4, 8, 15, 16
+  root window id:    0x2c7
+  depth of root window:    24 planes
+`
+
+func TestParseXdpyinfo(t *testing.T) {
+	geometry, err := ParseXdpyinfo(xdpyinfoOut)
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	expected := Geometry{Width: 1920, Height: 1080, Depth: 24, DPI: 120}
+	if geometry != expected {
+		t.Errorf("Expected '%v' to be '%v'", geometry, expected)
+	}
+}
+
+func TestGeometryValidate(t *testing.T) {
+	if err := DefaultGeometry.Validate(); err != nil {
+		t.Errorf("Expected default geometry to be valid: %s", err.Error())
+	}
+	invalid := []Geometry{
+		{Width: 1366, Height: 768, Depth: 32, DPI: 96},
+		{Width: 640, Height: 480, Depth: 24, DPI: 96},
+		{Width: 1366, Height: 768, Depth: 24, DPI: 1000},
+	}
+	for _, geometry := range invalid {
+		if err := geometry.Validate(); err == nil {
+			t.Errorf("Expected '%v' to be invalid", geometry)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+const (
+	x11SocketDir = "/tmp/.X11-unix"
+
+	x11ChangeWindowAttributes = 2
+	x11InternAtom             = 16
+	x11SendEvent              = 25
+
+	x11Reply         = 1
+	x11Error         = 0
+	x11ClientMessage = 33
+	// x11SentEvent flags events delivered through SendEvent
+	x11SentEvent = 0x80
+
+	x11CWEventMask            = 0x800
+	x11SubstructureNotifyMask = 0x80000
+)
+
+// pingStamp tells the answers to this process's pings from others
+var pingStamp = uint32(time.Now().Unix())
+
+// Ping sends the _NET_WM_PING of the EWMH to the window with id (as listed by ListWindows) and tells
+// whether its client answered within timeout. A client answers from its event loop, so a frozen one
+// does not. The window must list _NET_WM_PING in WM_PROTOCOLS (Window.Ping). err reports failures
+// to talk to the X server, which say nothing about the window.
+func Ping(env *[]string, id string, timeout time.Duration) (answered bool, err error) {
+	window, err := strconv.ParseUint(id, 0, 32)
+	if err != nil {
+		return false, fmt.Errorf("display error: invalid window id %q", id)
+	}
+	conn, err := dialX11(env)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	return ping(conn, uint32(window))
+}
+
+// dialX11 connects to the local X server of DISPLAY in env (":<n>" or ":<n>.<screen>").
+func dialX11(env *[]string) (net.Conn, error) {
+	display := hlp.EnvValue(env, "DISPLAY")
+	number, _, _ := strings.Cut(strings.TrimPrefix(display, ":"), ".")
+	if !strings.HasPrefix(display, ":") || len(number) == 0 {
+		return nil, fmt.Errorf("display error: cannot ping on display %q", display)
+	}
+
+	return net.Dial("unix", x11SocketDir+"/X"+number)
+}
+
+// ping speaks just enough of the X11 protocol to have the client of window answer _NET_WM_PING.
+// Xvfb is started without access control, so the connection carries no authorization.
+func ping(conn net.Conn, window uint32) (answered bool, err error) {
+	order := binary.LittleEndian
+	setup := make([]byte, 12)
+	setup[0], setup[2] = 'l', 11
+	if _, err = conn.Write(setup); err != nil {
+		return
+	}
+	root, err := readSetup(conn)
+	if err != nil {
+		return
+	}
+
+	protocols, err := internAtom(conn, "WM_PROTOCOLS")
+	if err != nil {
+		return
+	}
+	netWMPing, err := internAtom(conn, "_NET_WM_PING")
+	if err != nil {
+		return
+	}
+
+	// the answer is sent to the root window, where substructure events reach every client listening
+	request := make([]byte, 16)
+	request[0] = x11ChangeWindowAttributes
+	order.PutUint16(request[2:], 4)
+	order.PutUint32(request[4:], root)
+	order.PutUint32(request[8:], x11CWEventMask)
+	order.PutUint32(request[12:], x11SubstructureNotifyMask)
+	if _, err = conn.Write(request); err != nil {
+		return
+	}
+
+	// without an event mask, the event goes to the client which created window
+	request = make([]byte, 44)
+	request[0] = x11SendEvent
+	order.PutUint16(request[2:], 11)
+	order.PutUint32(request[4:], window)
+	event := request[12:]
+	event[0], event[1] = x11ClientMessage, 32
+	order.PutUint32(event[4:], window)
+	order.PutUint32(event[8:], protocols)
+	order.PutUint32(event[12:], netWMPing)
+	order.PutUint32(event[16:], pingStamp)
+	order.PutUint32(event[20:], window)
+	if _, err = conn.Write(request); err != nil {
+		return
+	}
+
+	for {
+		message, errRead := readMessage(conn)
+		var netErr net.Error
+		if errors.As(errRead, &netErr) && netErr.Timeout() {
+			return false, nil
+		}
+		if errRead != nil {
+			return false, errRead
+		}
+		switch {
+		case message[0] == x11Error:
+			return false, fmt.Errorf("display error: X error %d pinging window %#x", message[1], window)
+		case message[0]&^x11SentEvent == x11ClientMessage && order.Uint32(message[8:]) == protocols &&
+			order.Uint32(message[12:]) == netWMPing && order.Uint32(message[16:]) == pingStamp && order.Uint32(message[20:]) == window:
+			return true, nil
+		}
+	}
+}
+
+// readSetup reads the answer to the connection setup and returns the root window of the first screen.
+func readSetup(r io.Reader) (root uint32, err error) {
+	order := binary.LittleEndian
+	header := make([]byte, 8)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	body := make([]byte, 4*int(order.Uint16(header[6:])))
+	if _, err = io.ReadFull(r, body); err != nil {
+		return
+	}
+	if header[0] != 1 {
+		reason := body
+		if int(header[1]) < len(body) {
+			reason = body[:header[1]]
+		}
+		return 0, fmt.Errorf("display error: X server refused the connection: %s", strings.TrimSpace(string(reason)))
+	}
+	if len(body) < 32 {
+		return 0, errors.New("display error: X server sent a short setup")
+	}
+	// the fixed part is followed by the vendor, the pixmap formats and the screens
+	screens := 32 + (int(order.Uint16(body[16:]))+3)&^3 + 8*int(body[21])
+	if len(body) < screens+4 {
+		return 0, errors.New("display error: X server sent no screen")
+	}
+
+	return order.Uint32(body[screens:]), nil
+}
+
+// internAtom returns the atom called name.
+func internAtom(conn net.Conn, name string) (atom uint32, err error) {
+	order := binary.LittleEndian
+	request := make([]byte, 8+(len(name)+3)&^3)
+	request[0] = x11InternAtom
+	order.PutUint16(request[2:], uint16(len(request)/4))
+	order.PutUint16(request[4:], uint16(len(name)))
+	copy(request[8:], name)
+	if _, err = conn.Write(request); err != nil {
+		return
+	}
+	for {
+		message, errRead := readMessage(conn)
+		if errRead != nil {
+			return 0, errRead
+		}
+		switch message[0] {
+		case x11Error:
+			return 0, fmt.Errorf("display error: X error %d interning %s", message[1], name)
+		case x11Reply:
+			return order.Uint32(message[8:]), nil
+		}
+	}
+}
+
+// readMessage reads an error, event or reply; only replies are longer than 32 bytes.
+func readMessage(r io.Reader) (message []byte, err error) {
+	message = make([]byte, 32)
+	if _, err = io.ReadFull(r, message); err != nil {
+		return
+	}
+	if message[0] == x11Reply {
+		extra := make([]byte, 4*int(binary.LittleEndian.Uint32(message[4:])))
+		if _, err = io.ReadFull(r, extra); err != nil {
+			return
+		}
+		message = append(message, extra...)
+	}
+
+	return
+}
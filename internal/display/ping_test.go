@@ -0,0 +1,94 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	fakeRoot      = 0x1e2
+	fakeProtocols = 300
+	fakePing      = 301
+)
+
+// fakeXServer answers the requests of ping on conn; the client of the window answers if answering.
+func fakeXServer(t *testing.T, conn net.Conn, answering bool) {
+	order := binary.LittleEndian
+	defer conn.Close()
+	setup := make([]byte, 12)
+	if _, err := io.ReadFull(conn, setup); err != nil || setup[0] != 'l' {
+		t.Errorf("Expected a little-endian setup, got '%v'", setup)
+		return
+	}
+	// fixed part, vendor "Xv" padded to 4 bytes, one pixmap format and the root of the screen
+	body := make([]byte, 32+4+8+40)
+	order.PutUint16(body[16:], 2)
+	body[20], body[21] = 1, 1
+	copy(body[32:], "Xv")
+	order.PutUint32(body[44:], fakeRoot)
+	header := make([]byte, 8)
+	header[0] = 1
+	order.PutUint16(header[6:], uint16(len(body)/4))
+	conn.Write(append(header, body...))
+
+	for _, atom := range []uint32{fakeProtocols, fakePing} {
+		request := make([]byte, 8)
+		io.ReadFull(conn, request)
+		name := make([]byte, 4*int(order.Uint16(request[2:]))-8)
+		io.ReadFull(conn, name)
+		reply := make([]byte, 32)
+		reply[0] = x11Reply
+		order.PutUint32(reply[8:], atom)
+		conn.Write(reply)
+	}
+	attributes := make([]byte, 16)
+	io.ReadFull(conn, attributes)
+	if order.Uint32(attributes[4:]) != fakeRoot || order.Uint32(attributes[12:]) != x11SubstructureNotifyMask {
+		t.Errorf("Expected to listen on the root window, got '%v'", attributes)
+	}
+	send := make([]byte, 44)
+	io.ReadFull(conn, send)
+	if !answering {
+		time.Sleep(time.Second)
+		return
+	}
+	// the client sends the event back to the root window
+	pong := append([]byte{}, send[12:]...)
+	pong[0] |= x11SentEvent
+	order.PutUint32(pong[4:], fakeRoot)
+	unrelated := make([]byte, 32)
+	unrelated[0] = 22
+	conn.Write(append(unrelated, pong...))
+}
+
+func TestPing(t *testing.T) {
+	for _, answering := range []bool{true, false} {
+		client, server := net.Pipe()
+		go fakeXServer(t, server, answering)
+		client.SetDeadline(time.Now().Add(200 * time.Millisecond))
+		answered, err := ping(client, 4194310)
+		client.Close()
+		if err != nil {
+			t.Fatalf("Expected no error, got '%s'", err.Error())
+		}
+		if answered != answering {
+			t.Errorf("Expected '%v' to be '%v'", answered, answering)
+		}
+	}
+}
+
+func TestPingNeedsLocalDisplay(t *testing.T) {
+	env := []string{"DISPLAY=example.com:0"}
+	if _, err := Ping(&env, "4194310", time.Second); err == nil {
+		t.Errorf("Expected an error for a remote display")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+// Window is a visible top level window of the X display.
+type Window struct {
+	ID    string
+	Title string
+	PID   int
+	Class string
+	// Ping tells whether the client answers _NET_WM_PING
+	Ping bool
+}
+
+// listWindowsCmd prints "<id>\t<title>\t<xprop output>" per visible window
+const listWindowsCmd = `for w in $(xdotool search --onlyvisible --name '.*' 2>/dev/null); do ` +
+	`printf '%s\t%s\t%s\n' "$w" "$(xdotool getwindowname $w 2>/dev/null | tr '\t\n' '  ')" "$(xprop -id $w _NET_WM_PID WM_CLASS WM_PROTOCOLS 2>/dev/null | tr '\n' ' ')"; done`
+
+var (
+	wmPidRegex   = regexp.MustCompile(`_NET_WM_PID\(CARDINAL\) = (\d+)`)
+	wmClassRegex = regexp.MustCompile(`WM_CLASS\(STRING\) = "([^"]*)"`)
+	wmPingRegex  = regexp.MustCompile(`WM_PROTOCOLS\(ATOM\): protocols [^=]*\b_NET_WM_PING\b`)
+)
+
+// ListWindows inspects the display of env through xdotool and xprop.
+func ListWindows(runner ifc.CmdRunner, env *[]string) (windows []Window, err error) {
+	out, _, err := runner.RunCmdSync(listWindowsCmd, env)
+	if err != nil {
+		return
+	}
+	windows = ParseWindowList(out)
+
+	return
+}
+
+// ParseWindowList reads the output of listWindowsCmd.
+func ParseWindowList(out string) (windows []Window) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 || len(strings.TrimSpace(fields[0])) == 0 {
+			continue
+		}
+		window := Window{ID: strings.TrimSpace(fields[0]), Title: strings.TrimSpace(fields[1])}
+		if match := wmPidRegex.FindStringSubmatch(fields[2]); match != nil {
+			window.PID, _ = strconv.Atoi(match[1])
+		}
+		if match := wmClassRegex.FindStringSubmatch(fields[2]); match != nil {
+			window.Class = match[1]
+		}
+		window.Ping = wmPingRegex.MatchString(fields[2])
+		windows = append(windows, window)
+	}
+
+	return
+}
@@ -0,0 +1,27 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"testing"
+)
+
+func TestParseWindowList(t *testing.T) {
+	out := "4194310\t1234 - Broker-Live\t_NET_WM_PID(CARDINAL) = 42 WM_CLASS(STRING) = \"terminal64.exe\", \"terminal64.exe\" WM_PROTOCOLS(ATOM): protocols  WM_DELETE_WINDOW, _NET_WM_PING, WM_TAKE_FOCUS \n" +
+		"2097153\ti3bar for output screen\t_NET_WM_PID:  not found. WM_CLASS(STRING) = \"i3bar\", \"i3bar\" \n"
+
+	windows := ParseWindowList(out)
+	if len(windows) != 2 {
+		t.Fatalf("len(windows): Expected '%d' to be '%d'", len(windows), 2)
+	}
+	if windows[0].PID != 42 || windows[0].Class != "terminal64.exe" || !windows[0].Ping {
+		t.Errorf("windows[0]: Expected '%+v' to have PID 42, class terminal64.exe and answer pings", windows[0])
+	}
+	if windows[1].PID != 0 || windows[1].Title != "i3bar for output screen" || windows[1].Ping {
+		t.Errorf("windows[1]: Expected '%+v' to have no PID", windows[1])
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+)
+
+const (
+	xwdVersion  = 7
+	xwdZPixmap  = 2
+	xwdLSBFirst = 0
+	// fixed part of the header: 25 CARD32 fields, followed by the window name
+	xwdHeaderFields = 25
+	// XWDColor: pixel CARD32, red, green, blue CARD16, flags and pad byte
+	xwdColorSize = 12
+)
+
+// xwdHeader is the header written by xwd(1), all fields are big-endian CARD32.
+type xwdHeader struct {
+	HeaderSize, FileVersion, PixmapFormat, PixmapDepth, PixmapWidth, PixmapHeight, XOffset, ByteOrder,
+	BitmapUnit, BitmapBitOrder, BitmapPad, BitsPerPixel, BytesPerLine, VisualClass, RedMask, GreenMask,
+	BlueMask, BitsPerRGB, ColormapEntries, NColors, WindowWidth, WindowHeight, WindowX, WindowY,
+	WindowBorderWidth uint32
+}
+
+// DecodeXWD reads a ZPixmap dump of a TrueColor or DirectColor visual, as Xvfb provides for
+// depths 16 and 24.
+func DecodeXWD(r io.Reader) (img image.Image, err error) {
+	var header xwdHeader
+	if err = binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("xwd error: %w", err)
+	}
+	switch {
+	case header.FileVersion != xwdVersion:
+		return nil, fmt.Errorf("xwd error: unsupported version %d", header.FileVersion)
+	case header.PixmapFormat != xwdZPixmap:
+		return nil, errors.New("xwd error: only ZPixmap dumps are supported")
+	case header.BitsPerPixel%8 != 0 || header.BitsPerPixel < 8 || header.BitsPerPixel > 32:
+		return nil, fmt.Errorf("xwd error: unsupported %d bits per pixel", header.BitsPerPixel)
+	case header.RedMask == 0 || header.GreenMask == 0 || header.BlueMask == 0:
+		return nil, errors.New("xwd error: only TrueColor and DirectColor visuals are supported")
+	case header.HeaderSize < xwdHeaderFields*4:
+		return nil, errors.New("xwd error: corrupt header")
+	case uint64(header.BytesPerLine) < uint64(header.PixmapWidth)*uint64(header.BitsPerPixel/8) || header.PixmapWidth > 1<<15 || header.PixmapHeight > 1<<15:
+		return nil, errors.New("xwd error: corrupt geometry")
+	}
+	// skip window name and colormap
+	skip := int64(header.HeaderSize-xwdHeaderFields*4) + int64(header.NColors)*xwdColorSize
+	if _, err = io.CopyN(io.Discard, r, skip); err != nil {
+		return nil, fmt.Errorf("xwd error: %w", err)
+	}
+
+	width, height := int(header.PixmapWidth), int(header.PixmapHeight)
+	bytesPerPixel := int(header.BitsPerPixel / 8)
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	line := make([]byte, header.BytesPerLine)
+	for y := 0; y < height; y++ {
+		if _, err = io.ReadFull(r, line); err != nil {
+			return nil, fmt.Errorf("xwd error: %w", err)
+		}
+		for x := 0; x < width; x++ {
+			var pixel uint32
+			raw := line[x*bytesPerPixel : (x+1)*bytesPerPixel]
+			for i := range raw {
+				if header.ByteOrder == xwdLSBFirst {
+					pixel |= uint32(raw[i]) << (8 * i)
+				} else {
+					pixel = pixel<<8 | uint32(raw[i])
+				}
+			}
+			rgba.SetRGBA(x, y, color.RGBA{
+				R: channel(pixel, header.RedMask),
+				G: channel(pixel, header.GreenMask),
+				B: channel(pixel, header.BlueMask),
+				A: 0xff,
+			})
+		}
+	}
+
+	return rgba, nil
+}
+
+// channel extracts the component of mask from pixel and scales it to 8 bits.
+func channel(pixel, mask uint32) uint8 {
+	shift := bits.TrailingZeros32(mask)
+	width := bits.OnesCount32(mask)
+	value := (pixel & mask) >> shift
+	if width >= 8 {
+		return uint8(value >> (width - 8))
+	}
+	max := uint32(1)<<width - 1
+
+	return uint8(value * 0xff / max)
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package display
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+// xwdDump builds a 2x1 dump of a depth 16 (RGB 565) screen holding a red and a white pixel.
+func xwdDump(byteOrder uint32) []byte {
+	name := []byte("xwdump\x00\x00")
+	header := xwdHeader{
+		HeaderSize: xwdHeaderFields*4 + uint32(len(name)), FileVersion: xwdVersion, PixmapFormat: xwdZPixmap,
+		PixmapDepth: 16, PixmapWidth: 2, PixmapHeight: 1, ByteOrder: byteOrder, BitsPerPixel: 16,
+		BytesPerLine: 4, VisualClass: 4, RedMask: 0xf800, GreenMask: 0x07e0, BlueMask: 0x001f, NColors: 1,
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, header)
+	buf.Write(name)
+	buf.Write(make([]byte, xwdColorSize))
+	if byteOrder == xwdLSBFirst {
+		buf.Write([]byte{0x00, 0xf8, 0xff, 0xff})
+	} else {
+		buf.Write([]byte{0xf8, 0x00, 0xff, 0xff})
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecodeXWD(t *testing.T) {
+	for _, byteOrder := range []uint32{0, 1} {
+		img, err := DecodeXWD(bytes.NewReader(xwdDump(byteOrder)))
+		if err != nil {
+			t.Fatalf("byte order %d: Expected no error, got '%s'", byteOrder, err.Error())
+		}
+		if got := img.At(0, 0); got != (color.RGBA{R: 0xff, A: 0xff}) {
+			t.Errorf("byte order %d: Expected pixel (0,0) '%v' to be red", byteOrder, got)
+		}
+		if got := img.At(1, 0); got != (color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}) {
+			t.Errorf("byte order %d: Expected pixel (1,0) '%v' to be white", byteOrder, got)
+		}
+	}
+}
+
+func TestDecodeXWDRejectsTruncatedDump(t *testing.T) {
+	dump := xwdDump(0)
+	if _, err := DecodeXWD(bytes.NewReader(dump[:len(dump)-2])); err == nil {
+		t.Errorf("Expected truncated dump to be rejected")
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package health
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Action is what the supervisor does about a condition which stays unhealthy.
+type Action string
+
+const (
+	ActionAlert           Action = "alert"
+	ActionRestartTerminal Action = "restart-terminal"
+	ActionRestartStack    Action = "restart-stack"
+)
+
+// ParseAction validates the configured action; empty means alert.
+func ParseAction(value string) (action Action, err error) {
+	switch Action(value) {
+	case "", ActionAlert:
+		action = ActionAlert
+	case ActionRestartTerminal, ActionRestartStack:
+		action = Action(value)
+	default:
+		err = fmt.Errorf("health error: unknown action '%s' (alert, restart-terminal, restart-stack)", value)
+	}
+
+	return
+}
+
+// BrokerMonitor turns connectivity observations into a health verdict. The broker connection is
+// unhealthy once it stayed disconnected for Threshold.
+type BrokerMonitor struct {
+	Threshold time.Duration
+	Action    Action
+	// ProbeAddr optionally names the trade server (host:port) for a TCP probe
+	ProbeAddr         string
+	disconnectedSince time.Time
+	// armedSince is when the current Threshold started: the disconnection or the last due action
+	armedSince time.Time
+}
+
+// Observe records whether the terminal is connected right now. The connection stays unhealthy until
+// it comes back. due tells the caller to carry out Action; it is reported again after another
+// Threshold if the connection does not come back.
+func (m *BrokerMonitor) Observe(connected bool, now time.Time) (healthy, due bool) {
+	if connected {
+		m.disconnectedSince, m.armedSince = time.Time{}, time.Time{}
+		return true, false
+	}
+	if m.disconnectedSince.IsZero() {
+		m.disconnectedSince, m.armedSince = now, now
+	}
+	if now.Sub(m.disconnectedSince) < m.Threshold {
+		return true, false
+	}
+	if now.Sub(m.armedSince) < m.Threshold {
+		return false, false
+	}
+	m.armedSince = now
+
+	return false, true
+}
+
+// DisconnectedSince returns the start of the current disconnection (zero if connected).
+func (m *BrokerMonitor) DisconnectedSince() time.Time {
+	return m.disconnectedSince
+}
+
+// Probe tells whether a TCP connection to the trade server can be established. Without an address
+// there's nothing to probe, which counts as success.
+func (m *BrokerMonitor) Probe(timeout time.Duration) bool {
+	if len(m.ProbeAddr) == 0 {
+		return true
+	}
+	conn, err := net.DialTimeout("tcp", m.ProbeAddr, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
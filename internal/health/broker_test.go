@@ -0,0 +1,56 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package health
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBrokerMonitorToleratesShortDisconnects(t *testing.T) {
+	monitor := BrokerMonitor{Threshold: 5 * time.Minute}
+	start := time.Date(2022, 5, 4, 10, 0, 0, 0, time.UTC)
+
+	monitor.Observe(false, start)
+	healthy, due := monitor.Observe(false, start.Add(4*time.Minute))
+	if !healthy || due {
+		t.Errorf("Expected '%t, %t' to be '%t, %t'", healthy, due, true, false)
+	}
+	if healthy, _ = monitor.Observe(true, start.Add(5*time.Minute)); !healthy || !monitor.DisconnectedSince().IsZero() {
+		t.Errorf("Expected reconnect to reset the disconnection")
+	}
+}
+
+func TestBrokerMonitorBecomesUnhealthyAndRearms(t *testing.T) {
+	monitor := BrokerMonitor{Threshold: 5 * time.Minute}
+	start := time.Date(2022, 5, 4, 10, 0, 0, 0, time.UTC)
+
+	monitor.Observe(false, start)
+	healthy, due := monitor.Observe(false, start.Add(5*time.Minute))
+	if healthy || !due {
+		t.Errorf("Expected '%t, %t' to be '%t, %t'", healthy, due, false, true)
+	}
+	if healthy, due = monitor.Observe(false, start.Add(6*time.Minute)); healthy || due {
+		t.Errorf("Expected '%t, %t' to be '%t, %t'", healthy, due, false, false)
+	}
+	if since := monitor.DisconnectedSince(); !since.Equal(start) {
+		t.Errorf("Expected '%v' to be '%v'", since, start)
+	}
+	if healthy, due = monitor.Observe(false, start.Add(10*time.Minute)); healthy || !due {
+		t.Errorf("Expected '%t, %t' to be '%t, %t'", healthy, due, false, true)
+	}
+}
+
+func TestParseActionRejectsUnknown(t *testing.T) {
+	if action, err := ParseAction(""); action != ActionAlert || err != nil {
+		t.Errorf("Expected '%s, %v' to be '%s, %v'", action, err, ActionAlert, nil)
+	}
+	if _, err := ParseAction("reboot"); err == nil || !strings.HasPrefix(err.Error(), "health error") {
+		t.Errorf("err: Expected '%v' to be a 'health error'", err)
+	}
+}
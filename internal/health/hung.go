@@ -0,0 +1,90 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package health
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/9tmark/avly-trader/internal/display"
+)
+
+type HungReason string
+
+const (
+	HungErrorDialog  HungReason = "error-dialog"
+	HungNoMainWindow HungReason = "no-main-window"
+	HungUnresponsive HungReason = "unresponsive"
+)
+
+// ErrorDialogPattern matches titles of Wine crash dialogs and runtime error boxes.
+var ErrorDialogPattern = regexp.MustCompile(`(?i)program error|application error|has encountered a (serious )?problem|runtime (library|error)|unhandled exception|not responding`)
+
+// HungVerdict is the result of a window inspection. Detail never carries the main window's title,
+// as it shows the account number.
+type HungVerdict struct {
+	Hung       bool
+	Reason     HungReason
+	Detail     string
+	MainWindow bool
+}
+
+// HungDetector tells a crashed or frozen terminal from a healthy one by inspecting the X display.
+// A verdict other than an error dialog needs Strikes consecutive probes, as the terminal's window
+// takes a while to appear after launch.
+type HungDetector struct {
+	Strikes int
+	// Ping asks the client of a window listing _NET_WM_PING to answer; an error says nothing about
+	// the client. Without Ping, only the process state tells an unresponsive terminal.
+	Ping    func(window display.Window) (answered bool, err error)
+	strikes int
+}
+
+// Inspect judges the windows of the display for the terminal process terminalPID with /proc state procState.
+func (d *HungDetector) Inspect(windows []display.Window, terminalPID int, procState string) (verdict HungVerdict) {
+	var main display.Window
+	for _, window := range windows {
+		if ErrorDialogPattern.MatchString(window.Title) {
+			d.strikes = 0
+			return HungVerdict{Hung: true, Reason: HungErrorDialog, Detail: window.Title}
+		}
+		if isTerminalWindow(window, terminalPID) && len(window.Title) > 0 && !verdict.MainWindow {
+			verdict.MainWindow, main = true, window
+		}
+	}
+
+	switch {
+	// stopped or defunct processes do not answer anymore, uninterruptible ones hang on I/O
+	case procState == "T" || procState == "Z" || procState == "D":
+		verdict.Reason, verdict.Detail = HungUnresponsive, "process state "+procState
+	case !verdict.MainWindow:
+		verdict.Reason, verdict.Detail = HungNoMainWindow, "terminal has no main window"
+	// a running process may still be stuck outside its event loop
+	case d.Ping != nil && main.Ping && !d.answers(main):
+		verdict.Reason, verdict.Detail = HungUnresponsive, "main window did not answer a ping"
+	default:
+		d.strikes = 0
+		return
+	}
+	d.strikes++
+	verdict.Hung = d.strikes >= d.Strikes
+	if verdict.Hung {
+		d.strikes = 0
+	}
+
+	return
+}
+
+func (d *HungDetector) answers(window display.Window) bool {
+	answered, err := d.Ping(window)
+
+	return answered || err != nil
+}
+
+func isTerminalWindow(window display.Window, terminalPID int) bool {
+	return (terminalPID > 0 && window.PID == terminalPID) || strings.EqualFold(window.Class, "terminal64.exe")
+}
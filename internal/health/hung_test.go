@@ -0,0 +1,78 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package health
+
+import (
+	"testing"
+
+	"github.com/9tmark/avly-trader/internal/display"
+)
+
+var (
+	mainWindow = display.Window{ID: "4194310", Title: "1234 - Broker-Live: Demo Account - [EURUSD,H1]", PID: 42, Class: "terminal64.exe"}
+	pingable   = display.Window{ID: "4194311", Title: mainWindow.Title, PID: 42, Class: "terminal64.exe", Ping: true}
+)
+
+func TestHungDetectorErrorDialogIsImmediate(t *testing.T) {
+	detector := HungDetector{Strikes: 3}
+
+	verdict := detector.Inspect([]display.Window{mainWindow, {ID: "1", Title: "Program Error", PID: 42}}, 42, "S")
+	if !verdict.Hung || verdict.Reason != HungErrorDialog {
+		t.Errorf("Expected '%+v' to be hung by '%s'", verdict, HungErrorDialog)
+	}
+}
+
+func TestHungDetectorNeedsStrikesForMissingWindow(t *testing.T) {
+	detector := HungDetector{Strikes: 2}
+
+	if verdict := detector.Inspect(nil, 42, "S"); verdict.Hung {
+		t.Errorf("Expected first probe without main window not to be hung")
+	}
+	if verdict := detector.Inspect(nil, 42, "S"); !verdict.Hung || verdict.Reason != HungNoMainWindow {
+		t.Errorf("Expected '%+v' to be hung by '%s'", verdict, HungNoMainWindow)
+	}
+}
+
+func TestHungDetectorHealthyResetsStrikes(t *testing.T) {
+	detector := HungDetector{Strikes: 2}
+
+	detector.Inspect([]display.Window{mainWindow}, 42, "T")
+	if verdict := detector.Inspect([]display.Window{mainWindow}, 42, "S"); verdict.Hung || !verdict.MainWindow {
+		t.Errorf("Expected '%+v' to be healthy with main window", verdict)
+	}
+	if verdict := detector.Inspect([]display.Window{mainWindow}, 42, "T"); verdict.Hung || verdict.Reason != HungUnresponsive {
+		t.Errorf("Expected '%+v' to be a first strike for '%s'", verdict, HungUnresponsive)
+	}
+}
+
+func TestHungDetectorPingsMainWindow(t *testing.T) {
+	answering := false
+	detector := HungDetector{Strikes: 2, Ping: func(window display.Window) (bool, error) {
+		if window.ID != pingable.ID {
+			t.Errorf("Expected '%s' to be pinged, got '%s'", pingable.ID, window.ID)
+		}
+		return answering, nil
+	}}
+
+	detector.Inspect([]display.Window{pingable}, 42, "S")
+	if verdict := detector.Inspect([]display.Window{pingable}, 42, "S"); !verdict.Hung || verdict.Reason != HungUnresponsive {
+		t.Errorf("Expected '%+v' to be hung by '%s'", verdict, HungUnresponsive)
+	}
+	answering = true
+	if verdict := detector.Inspect([]display.Window{pingable}, 42, "S"); verdict.Hung || !verdict.MainWindow {
+		t.Errorf("Expected '%+v' to be healthy", verdict)
+	}
+
+	// windows not listing the protocol are not pinged
+	detector.Ping = func(display.Window) (bool, error) {
+		t.Errorf("Expected no ping")
+		return false, nil
+	}
+	if verdict := detector.Inspect([]display.Window{mainWindow}, 42, "S"); verdict.Hung {
+		t.Errorf("Expected '%+v' to be healthy", verdict)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package health
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+// journal event kinds relevant to planned restarts, as recorded in the status document
+const (
+	kindAuthorized   = "authorized"
+	kindExpertLoaded = "expert_loaded"
+)
+
+// LastTrade returns the time of the latest trade or order in the journal, or the zero time if it
+// shows none.
+func LastTrade(journal status.JournalStatus) (last time.Time) {
+	if journal.LastTrade != nil {
+		last = *journal.LastTrade
+	}
+
+	return
+}
+
+// TradeQuiet tells whether the journal showed no trade activity for quiet before now.
+func TradeQuiet(journal status.JournalStatus, now time.Time, quiet time.Duration) bool {
+	last := LastTrade(journal)
+
+	return last.IsZero() || now.Sub(last) >= quiet
+}
+
+// Reattachment compares the journal from before a restart with the current one. It lists what did not
+// come back yet: the broker authorization, if the terminal was authorized before, and each expert
+// loaded before.
+func Reattachment(before, after status.JournalStatus) (missing []string) {
+	if before.Counts[kindAuthorized] > 0 && after.Counts[kindAuthorized] <= before.Counts[kindAuthorized] {
+		missing = append(missing, "broker authorization")
+	}
+	loaded := map[string]bool{}
+	for _, expert := range after.LoadedExperts {
+		loaded[expert] = true
+	}
+	absent := 0
+	for _, expert := range before.LoadedExperts {
+		if !loaded[expert] {
+			missing = append(missing, "expert "+expert)
+			absent++
+		}
+	}
+	// unless the terminal logged their removal on stop, the names stay; the counter shows the reloads
+	reloaded := after.Counts[kindExpertLoaded] - before.Counts[kindExpertLoaded]
+	if absent == 0 && reloaded < len(before.LoadedExperts) {
+		missing = append(missing, fmt.Sprintf("experts (%d of %d loaded again)", reloaded, len(before.LoadedExperts)))
+	}
+
+	return
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package health
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/9tmark/avly-trader/internal/status"
+)
+
+func TestTradeQuiet(t *testing.T) {
+	now := time.Date(2022, 6, 30, 8, 10, 0, 0, time.UTC)
+	// the latest order may have left the recent events
+	lastTrade := now.Add(-2 * time.Minute)
+	journal := status.JournalStatus{LastTrade: &lastTrade, RecentEvents: []status.Event{
+		{Time: now.Add(-1 * time.Minute), Kind: kindAuthorized},
+	}}
+
+	if TradeQuiet(journal, now, 5*time.Minute) {
+		t.Errorf("Expected a failed order 2 minutes ago not to be quiet")
+	}
+	if !TradeQuiet(journal, now.Add(3*time.Minute), 5*time.Minute) {
+		t.Errorf("Expected 5 minutes without trades to be quiet")
+	}
+	if !TradeQuiet(status.JournalStatus{}, now, 5*time.Minute) {
+		t.Errorf("Expected an empty journal to be quiet")
+	}
+}
+
+func TestReattachment(t *testing.T) {
+	before := status.JournalStatus{LoadedExperts: []string{"Avly (EURUSD,H1)", "Grid (GBPUSD,M5)"}, Counts: map[string]int{kindAuthorized: 1, kindExpertLoaded: 2}}
+
+	partial := status.JournalStatus{LoadedExperts: []string{"Avly (EURUSD,H1)"}, Counts: map[string]int{kindAuthorized: 1, kindExpertLoaded: 3}}
+	if missing := Reattachment(before, partial); !reflect.DeepEqual(missing, []string{"broker authorization", "expert Grid (GBPUSD,M5)"}) {
+		t.Errorf("Expected '%v' to name the authorization and the missing expert", missing)
+	}
+
+	// the names were not removed on stop, but both were loaded again
+	recovered := status.JournalStatus{LoadedExperts: before.LoadedExperts, Counts: map[string]int{kindAuthorized: 2, kindExpertLoaded: 4}}
+	if missing := Reattachment(before, recovered); len(missing) > 0 {
+		t.Errorf("Expected '%v' to be empty", missing)
+	}
+
+	// without a login before, none is expected
+	if missing := Reattachment(status.JournalStatus{}, status.JournalStatus{}); len(missing) > 0 {
+		t.Errorf("Expected '%v' to be empty", missing)
+	}
+}
+
+func TestReattachmentCountsReloadsOfKeptNames(t *testing.T) {
+	before := status.JournalStatus{LoadedExperts: []string{"Avly (EURUSD,H1)", "Grid (GBPUSD,M5)"}, Counts: map[string]int{kindAuthorized: 1, kindExpertLoaded: 2}}
+	after := status.JournalStatus{LoadedExperts: before.LoadedExperts, Counts: map[string]int{kindAuthorized: 2, kindExpertLoaded: 3}}
+
+	if missing := Reattachment(before, after); !reflect.DeepEqual(missing, []string{"experts (1 of 2 loaded again)"}) {
+		t.Errorf("Expected '%v' to be '%v'", missing, "experts (1 of 2 loaded again)")
+	}
+}
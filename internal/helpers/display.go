@@ -0,0 +1,23 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package helpers
+
+import (
+	"fmt"
+	"os"
+)
+
+// FreeDisplay returns the first X display number from 'from' on which neither has a lock file nor a socket.
+func FreeDisplay(from int) int {
+	for display := from; ; display++ {
+		_, errLock := os.Stat(fmt.Sprintf("/tmp/.X%d-lock", display))
+		_, errSocket := os.Stat(fmt.Sprintf("/tmp/.X11-unix/X%d", display))
+		if os.IsNotExist(errLock) && os.IsNotExist(errSocket) {
+			return display
+		}
+	}
+}
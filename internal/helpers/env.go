@@ -0,0 +1,76 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package helpers
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InheritEnv copies every variable of the process environment starting with prefix into env.
+// Existing entries are overridden, so container settings (compose, k8s) win over built-in defaults.
+func InheritEnv(env *[]string, prefix string) {
+	for _, kv := range os.Environ() {
+		key, value, found := strings.Cut(kv, "=")
+		if !found || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		SetEnvValue(env, key, value)
+	}
+}
+
+// EnvValue returns the value of key inside env or an empty string.
+func EnvValue(env *[]string, key string) (value string) {
+	for _, kv := range *env {
+		if strings.HasPrefix(kv, key+"=") {
+			value = kv[len(key)+1:]
+		}
+	}
+
+	return
+}
+
+// SetEnvValue sets key inside env, replacing a present entry.
+func SetEnvValue(env *[]string, key, value string) {
+	for i, kv := range *env {
+		if strings.HasPrefix(kv, key+"=") {
+			(*env)[i] = key + "=" + value
+			return
+		}
+	}
+	*env = append(*env, key+"="+value)
+}
+
+// EnvDuration parses the value of key inside env as duration (e.g. "5m"), falling back to def if unset or invalid.
+func EnvDuration(env *[]string, key string, def time.Duration) time.Duration {
+	if parsed, err := time.ParseDuration(EnvValue(env, key)); err == nil && parsed > 0 {
+		return parsed
+	}
+
+	return def
+}
+
+// EnvInt parses the value of key inside env as positive integer, falling back to def if unset or invalid.
+func EnvInt(env *[]string, key string, def int) int {
+	if parsed, err := strconv.Atoi(EnvValue(env, key)); err == nil && parsed > 0 {
+		return parsed
+	}
+
+	return def
+}
+
+// EnvBool tells whether key inside env is set to "1", "true" or "yes".
+func EnvBool(env *[]string, key string) bool {
+	switch strings.ToLower(EnvValue(env, key)) {
+	case "1", "true", "yes":
+		return true
+	}
+
+	return false
+}
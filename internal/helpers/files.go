@@ -0,0 +1,107 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package helpers
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// CreateFresh replaces whatever is at path by a new, empty file. A link at path is removed, not
+// followed, so avly may write as root into folders of the managed processes.
+func CreateFresh(path string, perm os.FileMode) (*os.File, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, perm)
+}
+
+// WriteFresh writes data into a new file at path, see CreateFresh.
+func WriteFresh(path string, data []byte, perm os.FileMode) (err error) {
+	file, err := CreateFresh(path, perm)
+	if err != nil {
+		return
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return
+	}
+
+	return file.Close()
+}
+
+// CopyFile copies src to dst, creating missing parent folders. The content is written next to dst
+// first and renamed afterwards, so readers never see a half-written file.
+func CopyFile(src, dst string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return
+	}
+	tmp := dst + ".avly-tmp"
+	out, err := CreateFresh(tmp, info.Mode().Perm())
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return
+	}
+	if err = out.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+// CopyTree copies the regular files and symlinks of src into dst. skip gets paths relative to src;
+// returning true for a folder skips its whole content.
+func CopyTree(src, dst string, skip func(rel string) bool) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, errWalk error) error {
+		if errWalk != nil {
+			return errWalk
+		}
+		rel, errRel := filepath.Rel(src, path)
+		if errRel != nil {
+			return errRel
+		}
+		if rel != "." && skip != nil && skip(rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, errLink := os.Readlink(path)
+			if errLink != nil {
+				return errLink
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return CopyFile(path, target)
+		}
+		return nil
+	})
+}
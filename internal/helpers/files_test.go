@@ -0,0 +1,35 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFreshReplacesLinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	os.WriteFile(target, []byte("keep"), 0644)
+	path := filepath.Join(dir, "file")
+	os.Symlink(target, path)
+
+	if err := WriteFresh(path, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(target); string(raw) != "keep" {
+		t.Errorf("Expected '%s' to be 'keep'", raw)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected '%v' to be a regular file with mode '%v'", info.Mode(), os.FileMode(0600))
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// AppendLog writes a line to $AVL_LOGS/avly.log in the same format as the shell based entries.
+// Use it when the message carries user supplied values (paths etc.) which must not pass a shell.
+func AppendLog(env *[]string, msg string, a ...any) (err error) {
+	file, err := os.OpenFile(filepath.Join(EnvValue(env, "AVL_LOGS"), "avly.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY|syscall.O_NOFOLLOW, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "%s %s\n", time.Now().Format("2006/01/02 15:04:05"), fmt.Sprintf(msg, a...))
+
+	return
+}
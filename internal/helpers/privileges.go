@@ -0,0 +1,155 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package helpers
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+// Identity is the unprivileged user running the display, window manager, Wine and terminal processes.
+type Identity struct {
+	Name     string
+	Uid, Gid uint32
+	Home     string
+}
+
+// RunAsIdentity resolves AVL_RUN_AS, a user name or "uid:gid". ok is false if it is unset, so the
+// managed processes keep running as the user of avly.
+func RunAsIdentity(env *[]string) (id Identity, ok bool, err error) {
+	value := EnvValue(env, "AVL_RUN_AS")
+	if len(value) == 0 {
+		return
+	}
+	if uid, gid, isNumeric := strings.Cut(value, ":"); isNumeric {
+		parsedUid, errUid := strconv.ParseUint(uid, 10, 32)
+		parsedGid, errGid := strconv.ParseUint(gid, 10, 32)
+		if errUid != nil || errGid != nil {
+			err = fmt.Errorf("privileges error: invalid AVL_RUN_AS %s, expected a user name or uid:gid", value)
+			return
+		}
+		id = Identity{Name: uid, Uid: uint32(parsedUid), Gid: uint32(parsedGid), Home: "/tmp"}
+		if known, errLookup := user.LookupId(uid); errLookup == nil {
+			id.Name, id.Home = known.Username, known.HomeDir
+		}
+		return id, true, nil
+	}
+	known, err := user.Lookup(value)
+	if err != nil {
+		err = fmt.Errorf("privileges error: %w", err)
+		return
+	}
+	parsedUid, _ := strconv.ParseUint(known.Uid, 10, 32)
+	parsedGid, _ := strconv.ParseUint(known.Gid, 10, 32)
+
+	return Identity{Name: known.Username, Uid: uint32(parsedUid), Gid: uint32(parsedGid), Home: known.HomeDir}, true, nil
+}
+
+// Runner returns a runner starting its commands as id. Other runners than SafeCmdRunner, like the
+// spies of tests, are returned unchanged.
+func (id Identity) Runner(runner ifc.CmdRunner) ifc.CmdRunner {
+	safe, ok := runner.(*ifc.SafeCmdRunner)
+	if !ok {
+		return runner
+	}
+	dropped := *safe
+	dropped.Credential = &syscall.Credential{Uid: id.Uid, Gid: id.Gid, NoSetGroups: true}
+	dropped.Env = []string{"USER=" + id.Name, "LOGNAME=" + id.Name, "HOME=" + id.Home}
+
+	return &dropped
+}
+
+// IsRoot tells whether avly runs with root privileges.
+func IsRoot() bool {
+	return os.Geteuid() == 0
+}
+
+// CanActAs tells whether avly may start and signal processes of id: as root or as id itself.
+func CanActAs(id Identity) bool {
+	return IsRoot() || uint32(os.Geteuid()) == id.Uid
+}
+
+// ChownTree gives root and everything below it to id. Symbolic links are changed themselves, not
+// their targets, so links out of the tree (like Wine's dosdevices) are never followed.
+func ChownTree(root string, id Identity) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid == id.Uid && stat.Gid == id.Gid {
+			return nil
+		}
+
+		return os.Lchown(path, int(id.Uid), int(id.Gid))
+	})
+}
+
+// ReclaimTree gives root and everything below it back to root and takes the write access of others
+// away. Entries another user could redirect writes of root with (symbolic or hard links, devices,
+// pipes) are removed.
+func ReclaimTree(root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("privileges error: no owner of %s", path)
+		}
+		if !info.IsDir() && (!info.Mode().IsRegular() || stat.Nlink > 1) {
+			return os.Remove(path)
+		}
+		if err = os.Lchown(path, 0, 0); err != nil {
+			return err
+		}
+
+		return os.Chmod(path, info.Mode().Perm()&^0022)
+	})
+}
+
+// Adopt gives path to the owner of its folder if avly runs as root, so files avly writes into the
+// folders of the managed processes stay usable by them. Outside of root, it does nothing.
+func Adopt(path string) error {
+	if !IsRoot() {
+		return nil
+	}
+	owner, err := ownerOf(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	return ChownTree(path, owner)
+}
+
+func ownerOf(path string) (id Identity, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return id, fmt.Errorf("privileges error: no owner of %s", path)
+	}
+
+	return Identity{Uid: stat.Uid, Gid: stat.Gid}, nil
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+func TestRunAsIdentity(t *testing.T) {
+	if _, ok, err := RunAsIdentity(&[]string{}); ok || err != nil {
+		t.Errorf("Expected '%v' and '%v' to be 'false' and '<nil>'", ok, err)
+	}
+
+	id, ok, err := RunAsIdentity(&[]string{"AVL_RUN_AS=4321:8765"})
+	if !ok || err != nil {
+		t.Fatalf("Expected '%v' and '%v' to be 'true' and '<nil>'", ok, err)
+	}
+	if id.Uid != 4321 || id.Gid != 8765 {
+		t.Errorf("Expected '%v:%v' to be '4321:8765'", id.Uid, id.Gid)
+	}
+
+	for _, invalid := range []string{"4321:", "x:1", "avly-no-such-user"} {
+		if _, _, err := RunAsIdentity(&[]string{"AVL_RUN_AS=" + invalid}); err == nil {
+			t.Errorf("Expected an error for '%v'", invalid)
+		}
+	}
+}
+
+func TestIdentityRunner(t *testing.T) {
+	// switching to the own ids needs no privileges
+	id := Identity{Name: "avly-test", Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), Home: "/tmp"}
+	runner := id.Runner(&ifc.SafeCmdRunner{})
+	out, _, err := runner.RunCmdSync("echo $USER $HOME $AVL_LOGS", &[]string{"USER=root", "AVL_LOGS=/logs", "PATH=/usr/bin:/bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "avly-test /tmp /logs" {
+		t.Errorf("Expected '%v' to be '%v'", out, "avly-test /tmp /logs")
+	}
+
+	spy := &ifc.SpySafeCmdRunner{}
+	if id.Runner(spy) != spy {
+		t.Errorf("Expected other runners to be returned unchanged")
+	}
+}
+
+func TestChownTree(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+	os.WriteFile(filepath.Join(dir, "a", "b", "file"), []byte("x"), 0644)
+	os.Symlink("/", filepath.Join(dir, "a", "root"))
+
+	id := Identity{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	if err := ChownTree(dir, id); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(filepath.Join(dir, "a", "b", "file"))
+	if stat := info.Sys().(*syscall.Stat_t); stat.Uid != id.Uid || stat.Gid != id.Gid {
+		t.Errorf("Expected '%v:%v' to be '%v:%v'", stat.Uid, stat.Gid, id.Uid, id.Gid)
+	}
+}
+
+func TestReclaimTree(t *testing.T) {
+	if !IsRoot() {
+		t.Skip("needs root")
+	}
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "a"), 0777)
+	os.WriteFile(filepath.Join(dir, "a", "file"), []byte("x"), 0666)
+	os.Symlink("/etc/passwd", filepath.Join(dir, "a", "link"))
+	os.WriteFile(filepath.Join(dir, "shared"), []byte("x"), 0644)
+	os.Link(filepath.Join(dir, "shared"), filepath.Join(dir, "a", "hardlink"))
+	ChownTree(dir, Identity{Uid: 4321, Gid: 8765})
+
+	if err := ReclaimTree(filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(filepath.Join(dir, "a", "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := info.Sys().(*syscall.Stat_t); stat.Uid != 0 || stat.Gid != 0 {
+		t.Errorf("Expected '%v:%v' to be '0:0'", stat.Uid, stat.Gid)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Expected '%v' to be '%v'", info.Mode().Perm(), os.FileMode(0644))
+	}
+	for _, name := range []string{"link", "hardlink"} {
+		if _, err := os.Lstat(filepath.Join(dir, "a", name)); !os.IsNotExist(err) {
+			t.Errorf("Expected '%v' to be removed", name)
+		}
+	}
+}
@@ -8,8 +8,10 @@ package helpers
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 	"time"
 
 	ifc "github.com/9tmark/avly-trader/internal/interfaces"
@@ -73,3 +75,26 @@ func (pdq ProcDeathQueue) LetDie(runner ifc.CmdRunner, env *[]string) {
 	defer pdq.clear()
 	RestOrDie(runner, env, pdq...)
 }
+
+// KillProcGroup terminates proc and its children, which share its process group (see SafeCmdRunner).
+func KillProcGroup(proc *exec.Cmd) {
+	if proc == nil || proc.Process == nil {
+		return
+	}
+	syscall.Kill(-proc.Process.Pid, syscall.SIGTERM)
+}
+
+// ProcState returns the state letter of /proc/<pid>/stat (R, S, D, Z, T, ...).
+func ProcState(pid int) (state string, err error) {
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return
+	}
+	// the command name in parentheses may contain spaces
+	fields := strings.Fields(string(raw[strings.LastIndex(string(raw), ")")+1:]))
+	if len(fields) > 0 {
+		state = fields[0]
+	}
+
+	return
+}
@@ -7,6 +7,9 @@
 package helpers
 
 import (
+	"errors"
+	"os"
+	"strconv"
 	"strings"
 
 	ifc "github.com/9tmark/avly-trader/internal/interfaces"
@@ -49,3 +52,31 @@ func WasRunAsRoot(r ifc.CmdRunner) (eval bool) {
 
 	return
 }
+
+// ShellQuote wraps s in single quotes so it passes `sh -c` as a single literal word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+}
+
+// AvailableMemoryMB reads MemAvailable of /proc/meminfo.
+func AvailableMemoryMB() (megabytes int, err error) {
+	raw, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kilobytes, errConv := strconv.Atoi(fields[1])
+			if errConv != nil {
+				err = errConv
+				return
+			}
+			megabytes = kilobytes / 1024
+			return
+		}
+	}
+	err = errors.New("meminfo error: MemAvailable not found")
+
+	return
+}
@@ -7,11 +7,18 @@
 package helpers
 
 import (
-	"time"
+	"fmt"
+	"path/filepath"
+	"strings"
 
 	ifc "github.com/9tmark/avly-trader/internal/interfaces"
 )
 
+// WinePath translates an absolute unix path into its counterpart on Wine's Z: drive.
+func WinePath(unixPath string) string {
+	return "Z:" + strings.ReplaceAll(filepath.Clean(unixPath), "/", "\\")
+}
+
 func InstallWine(runner ifc.CmdRunner, env *[]string) (err error) {
 	var dq ProcDeathQueue
 	GetTCF(
@@ -38,39 +45,27 @@ func InstallWine(runner ifc.CmdRunner, env *[]string) (err error) {
 	return
 }
 
-func PrepareWineprefix(runner ifc.CmdRunner, env *[]string) (err error) {
-	var dq ProcDeathQueue
-	GetTCF(
-		func() {
-			_, pWb1 := runner.PanicCmdAsync("wine wineboot -u &> $AVL_LOGS/wine.log", env)
-			dq.Add(pWb1)
-			time.Sleep(20 * time.Second)
-			_, pRet := runner.PanicCmdAsync("xdotool key --clearmodifiers Return", env)
-			dq.Add(pRet)
-			time.Sleep(80 * time.Second)
-			runner.PanicCmdAsync("wine wineboot -u &>> $AVL_LOGS/wine.log", env)
-			time.Sleep(20 * time.Second)
-			_, pMon := runner.PanicCmdAsync("wine msiexec /i $THIRD_PARTY/wine-mono-7.1.1-x86.msi &>> $AVL_LOGS/wine.log", env)
-			dq.Add(pMon)
-			time.Sleep(45 * time.Second)
-			_, pGec := runner.PanicCmdAsync("wine msiexec /i $THIRD_PARTY/wine_gecko-2.47-x86_64.msi &>> $AVL_LOGS/wine.log", env)
-			dq.Add(pGec)
-			time.Sleep(20 * time.Second)
-			_, pCp := runner.PanicCmdSync("cp $THIRD_PARTY/winetricks /usr/local/bin", env)
-			dq.Add(pCp)
-			_, pCh := runner.PanicCmdSync("chmod +x /usr/local/bin/winetricks", env)
-			dq.Add(pCh)
-			_, pWt := runner.PanicCmdAsync("winetricks -f --unattended corefonts", env)
-			dq.Add(pWt)
-			time.Sleep(45 * time.Second)
-		},
-		func(caught error) {
-			err = caught
-		},
-		func() {
-			dq.LetDie(runner, env)
-		},
-	).Run()
+// InstallWinetricks puts the winetricks script shipped in THIRD_PARTY on the PATH, which needs root.
+func InstallWinetricks(runner ifc.CmdRunner, env *[]string) (err error) {
+	if _, _, err = runner.RunCmdSync("cp $THIRD_PARTY/winetricks /usr/local/bin", env); err != nil {
+		return
+	}
+	_, _, err = runner.RunCmdSync("chmod +x /usr/local/bin/winetricks", env)
+
+	return
+}
+
+// SetWineDPI writes the resolution Wine renders fonts and controls with into the prefix of env.
+// Running Wine processes keep the previous value until restarted.
+func SetWineDPI(runner ifc.CmdRunner, env *[]string, dpi int) (err error) {
+	for _, key := range []string{
+		`HKCU\Control Panel\Desktop`,
+		`HKLM\System\CurrentControlSet\Hardware Profiles\Current\Software\Fonts`,
+	} {
+		if _, _, err = runner.RunCmdSync(fmt.Sprintf("wine reg add %s /v LogPixels /t REG_DWORD /d %d /f >> $AVL_PROCESS_LOGS/wine.log 2>&1", ShellQuote(key), dpi), env); err != nil {
+			return
+		}
+	}
 
 	return
 }
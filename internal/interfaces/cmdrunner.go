@@ -22,7 +22,12 @@ type CmdRunner interface {
 	PanicCmdAsync(cmdLine string, env *[]string) (output string, proc *exec.Cmd)
 }
 
-type SafeCmdRunner struct{}
+type SafeCmdRunner struct {
+	// Credential runs the commands as another user, nil keeps the user of avly
+	Credential *syscall.Credential
+	// Env overrides variables of the commands' environment, e.g. USER and HOME of Credential
+	Env []string
+}
 
 type SpySafeCmdRunner struct {
 	Calls       int
@@ -49,9 +54,10 @@ func (s *SafeCmdRunner) RunCmdSync(cmdLine string, env *[]string) (output string
 	proc = &exec.Cmd{
 		Path: executable,
 		Args: cmdArr,
-		Env:  *env,
+		Env:  s.environ(env),
 		SysProcAttr: &syscall.SysProcAttr{
-			Setpgid: true,
+			Setpgid:    true,
+			Credential: s.Credential,
 		},
 		Stdout: &outBuf,
 	}
@@ -83,9 +89,10 @@ func (s *SafeCmdRunner) RunCmdAsync(cmdLine string, env *[]string) (output strin
 	proc = &exec.Cmd{
 		Path: executable,
 		Args: cmdArr,
-		Env:  *env,
+		Env:  s.environ(env),
 		SysProcAttr: &syscall.SysProcAttr{
-			Setpgid: true,
+			Setpgid:    true,
+			Credential: s.Credential,
 		},
 		Stdout: &outBuf,
 	}
@@ -130,3 +137,26 @@ func (s *SafeCmdRunner) PanicCmdAsync(cmdLine string, env *[]string) (output str
 
 	return
 }
+
+// environ returns env with the overrides of s.Env.
+func (s *SafeCmdRunner) environ(env *[]string) []string {
+	if len(s.Env) == 0 {
+		return *env
+	}
+	merged := make([]string, 0, len(*env)+len(s.Env))
+	for _, entry := range *env {
+		name, _, _ := strings.Cut(entry, "=")
+		overridden := false
+		for _, override := range s.Env {
+			if strings.HasPrefix(override, name+"=") {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, entry)
+		}
+	}
+
+	return append(merged, s.Env...)
+}
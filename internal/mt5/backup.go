@@ -0,0 +1,68 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+const backupExt = ".tar.gz"
+
+// backupExcludes keeps logs, downloaded history, tester data and saved broker logins out of data
+// backups. Paths are relative to the data dir.
+var backupExcludes = []string{"./logs", "./bases", "./Tester", "./MQL5/Logs", "./config/accounts.dat"}
+
+// BackupData archives the terminal data dir (experts, indicators, files, profiles and settings) into
+// dir as <name>-<UTC time>.tar.gz, only readable by its owner.
+func BackupData(runner ifc.CmdRunner, env *[]string, dir, name string, now time.Time) (path string, err error) {
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return
+	}
+	path = filepath.Join(dir, fmt.Sprintf("%s-%s%s", name, now.UTC().Format("20060102-150405"), backupExt))
+	tmp := filepath.Join(dir, "."+filepath.Base(path))
+	defer os.Remove(tmp)
+	var excludes []string
+	for _, exclude := range backupExcludes {
+		excludes = append(excludes, "--exclude="+hlp.ShellQuote(exclude))
+	}
+	cmdLine := fmt.Sprintf("tar -czf %s %s -C %s .", hlp.ShellQuote(tmp), strings.Join(excludes, " "), hlp.ShellQuote(InstallDir(env)))
+	if _, _, err = runner.RunCmdSync(cmdLine, env); err != nil {
+		return "", fmt.Errorf("backup error: %w", err)
+	}
+	if err = os.Chmod(tmp, 0600); err != nil {
+		return
+	}
+	err = os.Rename(tmp, path)
+
+	return
+}
+
+// PruneBackups removes all but the keep newest backups called name from dir.
+func PruneBackups(dir, name string, keep int) (removed []string, err error) {
+	backups, err := filepath.Glob(filepath.Join(dir, name+"-*"+backupExt))
+	if err != nil {
+		return
+	}
+	// the UTC time in the name sorts chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i := keep; i < len(backups); i++ {
+		if err = os.Remove(backups[i]); err != nil {
+			return
+		}
+		removed = append(removed, backups[i])
+	}
+
+	return
+}
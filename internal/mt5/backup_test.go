@@ -0,0 +1,67 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+func TestBackupData(t *testing.T) {
+	prefix := t.TempDir()
+	env := []string{"WINEPREFIX=" + prefix}
+	dataDir := InstallDir(&env)
+	for _, name := range []string{"MQL5/Experts/avly.ex5", "logs/20220630.log", "config/accounts.dat", "config/common.ini"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dataDir, name)), 0755)
+		os.WriteFile(filepath.Join(dataDir, name), []byte(name), 0644)
+	}
+	backups := filepath.Join(t.TempDir(), "backups")
+
+	path, err := BackupData(&ifc.SafeCmdRunner{}, &env, backups, "data", time.Date(2022, 6, 30, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "data-20220630-120000.tar.gz" {
+		t.Errorf("Expected '%v' to be '%v'", filepath.Base(path), "data-20220630-120000.tar.gz")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected '%v' to be only readable by its owner (%v)", path, err)
+	}
+	listing, err := exec.Command("tar", "-tzf", path).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(listing), "MQL5/Experts/avly.ex5") || !strings.Contains(string(listing), "config/common.ini") {
+		t.Errorf("Expected '%v' to hold the experts and settings", string(listing))
+	}
+	if strings.Contains(string(listing), "logs/") || strings.Contains(string(listing), "accounts.dat") {
+		t.Errorf("Expected '%v' to leave out logs and saved logins", string(listing))
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"data-20220101-000000.tar.gz", "data-20220301-000000.tar.gz", "data-20220201-000000.tar.gz", "other-20210101-000000.tar.gz"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0600)
+	}
+	removed, err := PruneBackups(dir, "data", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "data-20220101-000000.tar.gz" {
+		t.Errorf("Expected '%v' to be '%v'", removed, "data-20220101-000000.tar.gz")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other-20210101-000000.tar.gz")); err != nil {
+		t.Errorf("Expected backups of other tasks to be kept")
+	}
+}
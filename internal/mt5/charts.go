@@ -0,0 +1,77 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AttachedExperts lists the Expert Advisors (relative to MQL5, e.g. "Experts/Foo.ex5") which are
+// attached to a chart of any profile. Those are the ones running as soon as the terminal is up.
+func AttachedExperts(env *[]string) (experts []string, err error) {
+	seen := map[string]bool{}
+	root := filepath.Join(Mql5Dir(env), "Profiles", "Charts")
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, errWalk error) error {
+		if errWalk != nil {
+			if os.IsNotExist(errWalk) {
+				return fs.SkipDir
+			}
+			return errWalk
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".chr") {
+			return nil
+		}
+		raw, errRead := os.ReadFile(path)
+		if errRead != nil {
+			return errRead
+		}
+		for _, expert := range ParseChartExperts(DecodeText(raw)) {
+			if !seen[expert] {
+				seen[expert] = true
+				experts = append(experts, expert)
+			}
+		}
+		return nil
+	})
+
+	return
+}
+
+// ParseChartExperts extracts the expert paths of the '<expert>' sections of a .chr file.
+func ParseChartExperts(chart string) (experts []string) {
+	var inExpert bool
+	for _, line := range strings.Split(chart, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "<expert>":
+			inExpert = true
+		case line == "</expert>":
+			inExpert = false
+		case inExpert && strings.HasPrefix(line, "path="):
+			path := strings.ReplaceAll(strings.TrimPrefix(line, "path="), "\\", "/")
+			if !strings.HasPrefix(strings.ToLower(path), "experts/") {
+				path = "Experts/" + path
+			}
+			experts = append(experts, filepath.FromSlash(path))
+		}
+	}
+
+	return
+}
+
+// DecodeText decodes a terminal file which is either UTF-16LE with BOM or plain ANSI/UTF-8.
+func DecodeText(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xff && raw[1] == 0xfe {
+		return DecodeUTF16LE(raw)
+	}
+
+	return strings.ReplaceAll(string(raw), "\r", "")
+}
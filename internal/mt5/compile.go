@@ -0,0 +1,94 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+// MetaEditorExecutable is the MQL5 compiler shipped with the terminal.
+const MetaEditorExecutable = "metaeditor64.exe"
+
+// CompileMessage is a single diagnostic of a MetaEditor compile log.
+type CompileMessage struct {
+	File     string
+	Line     int
+	Column   int
+	Severity string
+	Code     string
+	Text     string
+}
+
+func (m CompileMessage) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s %s: %s", m.File, m.Line, m.Column, m.Severity, m.Code, m.Text)
+}
+
+type CompileResult struct {
+	Errors   int
+	Warnings int
+	Messages []CompileMessage
+}
+
+// e.g. Z:\var\tmp\avly-mql5\Experts\Foo.mq5(12,5) : error 256: 'x' - undeclared identifier
+var compileMessageRegex = regexp.MustCompile(`^(.+)\((\d+),(\d+)\)\s*:\s*(error|warning)\s*(\d*)\s*:?\s*(.*)$`)
+
+// MetaEditorPath returns the path of the MQL5 compiler inside the prefix.
+func MetaEditorPath(env *[]string) string {
+	return filepath.Join(InstallDir(env), MetaEditorExecutable)
+}
+
+// CompileCmdLine builds the MetaEditor command line compiling target (file or folder) with includes
+// looked up in includeDir. The log is written to logPath.
+func CompileCmdLine(env *[]string, target, includeDir, logPath string) string {
+	return strings.Join([]string{
+		"wine",
+		hlp.ShellQuote(MetaEditorPath(env)),
+		hlp.ShellQuote("/compile:" + hlp.WinePath(target)),
+		hlp.ShellQuote("/inc:" + hlp.WinePath(includeDir)),
+		hlp.ShellQuote("/log:" + hlp.WinePath(logPath)),
+	}, " ")
+}
+
+// ParseCompileLog collects errors and warnings of a MetaEditor log. File paths inside rootDir are made
+// relative to it, so they match the mounted source tree.
+func ParseCompileLog(compileLog, rootDir string) (result CompileResult) {
+	winRoot := strings.ToLower(hlp.WinePath(rootDir) + "\\")
+	for _, line := range strings.Split(compileLog, "\n") {
+		match := compileMessageRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		file := match[1]
+		if strings.HasPrefix(strings.ToLower(file), winRoot) {
+			file = file[len(winRoot):]
+		}
+		lineNo, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+		msg := CompileMessage{
+			File:     filepath.FromSlash(strings.ReplaceAll(file, "\\", "/")),
+			Line:     lineNo,
+			Column:   column,
+			Severity: match[4],
+			Code:     match[5],
+			Text:     match[6],
+		}
+		if msg.Severity == "error" {
+			result.Errors++
+		} else {
+			result.Warnings++
+		}
+		result.Messages = append(result.Messages, msg)
+	}
+
+	return
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleCompileLog = `Z:\var\tmp\avly-mql5\Experts\Foo.mq5 : information: compiling 'Foo.mq5'
+Z:\var\tmp\avly-mql5\Include\Bar.mqh(7,3) : warning 43: possible loss of data due to type conversion
+Z:\var\tmp\avly-mql5\Experts\Foo.mq5(12,5) : error 256: 'x' - undeclared identifier
+Result: 1 errors, 1 warnings, 123 msec elapsed
+`
+
+func TestParseCompileLogCounts(t *testing.T) {
+	result := ParseCompileLog(sampleCompileLog, "/var/tmp/avly-mql5")
+
+	if result.Errors != 1 || result.Warnings != 1 {
+		t.Errorf("Expected '%d/%d' errors/warnings to be '1/1'", result.Errors, result.Warnings)
+	}
+}
+
+func TestParseCompileLogRelativePaths(t *testing.T) {
+	result := ParseCompileLog(sampleCompileLog, "/var/tmp/avly-mql5")
+
+	last := result.Messages[len(result.Messages)-1]
+	if expected := filepath.FromSlash("Experts/Foo.mq5") + ":12:5: error 256: 'x' - undeclared identifier"; last.String() != expected {
+		t.Errorf("Expected '%s' to be '%s'", last.String(), expected)
+	}
+}
+
+func TestCompileCmdLineQuotesPaths(t *testing.T) {
+	env := []string{"WINEPREFIX=/opt/.mtprfx"}
+
+	cmdLine := CompileCmdLine(&env, "/var/tmp/avly-mql5/Experts/It's.mq5", "/var/tmp/avly-mql5", "/var/tmp/avly-mql5/avly-compile.log")
+	if !strings.Contains(cmdLine, `'/compile:Z:\var\tmp\avly-mql5\Experts\It'\''s.mq5'`) {
+		t.Errorf("Expected '%s' to contain a quoted compile target", cmdLine)
+	}
+}
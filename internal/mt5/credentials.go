@@ -0,0 +1,112 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+const defaultSecretsDir = "/run/secrets"
+
+// Credentials hold the broker login of the terminal. Their values are read from secret files only
+// and are never meant to be printed, so the formatting verbs are redacted.
+type Credentials struct {
+	Login    string
+	Password string
+	Server   string
+}
+
+// secretSource names the env var pointing to a secret file and the file name looked up inside AVL_SECRETS_DIR.
+type secretSource struct {
+	envKey   string
+	fileName string
+}
+
+var (
+	loginSource    = secretSource{envKey: "AVL_MT5_LOGIN_FILE", fileName: "mt5_login"}
+	passwordSource = secretSource{envKey: "AVL_MT5_PASSWORD_FILE", fileName: "mt5_password"}
+	serverSource   = secretSource{envKey: "AVL_MT5_SERVER_FILE", fileName: "mt5_server"}
+)
+
+func (c Credentials) String() string {
+	return "mt5.Credentials{<redacted>}"
+}
+
+func (c Credentials) GoString() string {
+	return c.String()
+}
+
+// LoadCredentials reads login, password and server from Docker/Kubernetes secret files.
+// found is false if none of the files exist, which means the terminal keeps using its own stored account.
+func LoadCredentials(env *[]string) (creds Credentials, found bool, err error) {
+	var present int
+	fields := []struct {
+		source secretSource
+		dst    *string
+	}{
+		{source: loginSource, dst: &creds.Login},
+		{source: passwordSource, dst: &creds.Password},
+		{source: serverSource, dst: &creds.Server},
+	}
+
+	for i := 0; i < len(fields); i++ {
+		value, ok, errRead := readSecret(env, fields[i].source)
+		if errRead != nil {
+			err = errRead
+			return
+		}
+		if ok {
+			*fields[i].dst = value
+			present++
+		}
+	}
+	if present == 0 {
+		return
+	}
+	if present < len(fields) {
+		err = errors.New("credentials error: login, password and server secrets need to be provided together")
+		return
+	}
+	found = true
+
+	return
+}
+
+func readSecret(env *[]string, source secretSource) (value string, ok bool, err error) {
+	path := hlp.EnvValue(env, source.envKey)
+	explicit := len(path) > 0
+	if !explicit {
+		dir := hlp.EnvValue(env, "AVL_SECRETS_DIR")
+		if len(dir) == 0 {
+			dir = defaultSecretsDir
+		}
+		path = filepath.Join(dir, source.fileName)
+	}
+
+	raw, errRead := os.ReadFile(path)
+	if errRead != nil {
+		if errors.Is(errRead, os.ErrNotExist) && !explicit {
+			return
+		}
+		err = fmt.Errorf("credentials error: could not read secret file %s: %w", path, errRead)
+		return
+	}
+	value = strings.TrimSpace(string(raw))
+	if len(value) == 0 {
+		err = fmt.Errorf("credentials error: secret file %s is empty", path)
+		return
+	}
+	ok = true
+
+	return
+}
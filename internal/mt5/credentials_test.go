@@ -0,0 +1,103 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSecrets(t *testing.T, dir string, secrets map[string]string) {
+	for name, value := range secrets {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadCredentialsFromSecretsDir(t *testing.T) {
+	dir := t.TempDir()
+	writeSecrets(t, dir, map[string]string{"mt5_login": "1234\n", "mt5_password": "s3cr3t\n", "mt5_server": "Broker-Demo"})
+	env := []string{"AVL_SECRETS_DIR=" + dir}
+
+	creds, found, err := LoadCredentials(&env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Errorf("found: Expected '%t' to be '%t'", found, true)
+	}
+	if creds.Login != "1234" || creds.Password != "s3cr3t" || creds.Server != "Broker-Demo" {
+		t.Errorf("creds: Expected values to be trimmed file contents")
+	}
+}
+
+func TestLoadCredentialsExplicitFileWins(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	writeSecrets(t, dir, map[string]string{"mt5_login": "1234", "mt5_password": "s3cr3t", "mt5_server": "Broker-Demo"})
+	writeSecrets(t, other, map[string]string{"pw": "0th3r"})
+	env := []string{"AVL_SECRETS_DIR=" + dir, "AVL_MT5_PASSWORD_FILE=" + filepath.Join(other, "pw")}
+
+	creds, _, err := LoadCredentials(&env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Password != "0th3r" {
+		t.Errorf("creds.Password: Expected value of explicit secret file")
+	}
+}
+
+func TestLoadCredentialsNoneFound(t *testing.T) {
+	env := []string{"AVL_SECRETS_DIR=" + t.TempDir()}
+
+	if _, found, err := LoadCredentials(&env); found || err != nil {
+		t.Errorf("Expected '%t, %v' to be '%t, %v'", found, err, false, nil)
+	}
+}
+
+func TestLoadCredentialsIncomplete(t *testing.T) {
+	dir := t.TempDir()
+	writeSecrets(t, dir, map[string]string{"mt5_password": "s3cr3t"})
+	env := []string{"AVL_SECRETS_DIR=" + dir}
+
+	_, _, err := LoadCredentials(&env)
+	if err == nil || !strings.HasPrefix(err.Error(), "credentials error") {
+		t.Errorf("err: Expected '%v' to be a 'credentials error'", err)
+	}
+}
+
+func TestCredentialsAreRedacted(t *testing.T) {
+	creds := Credentials{Login: "1234", Password: "s3cr3t", Server: "Broker-Demo"}
+
+	for _, printed := range []string{fmt.Sprintf("%v", creds), fmt.Sprintf("%+v", creds), fmt.Sprintf("%#v", creds), fmt.Sprint(creds)} {
+		if strings.Contains(printed, "s3cr3t") || strings.Contains(printed, "1234") {
+			t.Errorf("Expected '%s' not to contain secrets", printed)
+		}
+	}
+}
+
+func TestWriteStartupIniPermissions(t *testing.T) {
+	env := []string{"AVL_RUNTIME=" + t.TempDir()}
+
+	path, err := WriteStartupIni(&env, Credentials{Login: "1234", Password: "s3cr3t", Server: "Broker-Demo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode: Expected '%v' to be '%v'", info.Mode().Perm(), os.FileMode(0600))
+	}
+	if err = RemoveStartupIni(&env); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected startup ini to be removed")
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+// DeployFolders are the MQL5 subfolders which are synced from the mounted source tree.
+var DeployFolders = []string{"Experts", "Indicators", "Scripts", "Include", "Libraries", "Files", "Presets", "Profiles"}
+
+// deployManifestName keeps track of the files put into MQL5 by avly, so pruning never touches stock files.
+const deployManifestName = ".avly-deploy.json"
+
+type ChangeKind string
+
+const (
+	ChangeAdd    ChangeKind = "+"
+	ChangeUpdate ChangeKind = "~"
+	ChangeRemove ChangeKind = "-"
+)
+
+// Change is a single file operation inside MQL5, Path being relative to the MQL5 folder.
+type Change struct {
+	Kind ChangeKind
+	Path string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s", c.Kind, c.Path)
+}
+
+type DeployOptions struct {
+	DryRun  bool
+	Prune   bool
+	Restart bool
+}
+
+// DeployPlan is the diff between the source tree and the terminal's MQL5 folder.
+type DeployPlan struct {
+	SourceDir string
+	Mql5Dir   string
+	Changes   []Change
+	checksums map[string]string
+}
+
+// Mql5Dir returns the MQL5 folder of the terminal.
+func Mql5Dir(env *[]string) string {
+	return filepath.Join(InstallDir(env), "MQL5")
+}
+
+// PlanDeploy compares checksums of the source tree against the MQL5 folder. Stale files are only
+// scheduled for removal if prune is set and avly deployed them in the first place.
+func PlanDeploy(sourceDir, mql5Dir string, prune bool) (plan DeployPlan, err error) {
+	plan = DeployPlan{SourceDir: sourceDir, Mql5Dir: mql5Dir, checksums: map[string]string{}}
+
+	for _, folder := range DeployFolders {
+		root := filepath.Join(sourceDir, folder)
+		if _, errStat := os.Stat(root); errors.Is(errStat, os.ErrNotExist) {
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, errWalk error) error {
+			if errWalk != nil || d.IsDir() {
+				return errWalk
+			}
+			rel, errRel := filepath.Rel(sourceDir, path)
+			if errRel != nil {
+				return errRel
+			}
+			sum, errSum := fileChecksum(path)
+			if errSum != nil {
+				return errSum
+			}
+			plan.checksums[rel] = sum
+			switch current, errCur := fileChecksum(filepath.Join(mql5Dir, rel)); {
+			case errors.Is(errCur, os.ErrNotExist):
+				plan.Changes = append(plan.Changes, Change{Kind: ChangeAdd, Path: rel})
+			case errCur != nil:
+				return errCur
+			case current != sum:
+				plan.Changes = append(plan.Changes, Change{Kind: ChangeUpdate, Path: rel})
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	if prune {
+		manifest, errManifest := readDeployManifest(mql5Dir)
+		if errManifest != nil {
+			err = errManifest
+			return
+		}
+		for rel := range manifest {
+			if _, stillDeployed := plan.checksums[rel]; !stillDeployed {
+				plan.Changes = append(plan.Changes, Change{Kind: ChangeRemove, Path: rel})
+			}
+		}
+	}
+	sort.Slice(plan.Changes, func(i, j int) bool { return plan.Changes[i].Path < plan.Changes[j].Path })
+
+	return
+}
+
+// Apply executes the plan and updates the manifest. report is called after every successful change.
+func (p DeployPlan) Apply(report func(Change)) (err error) {
+	manifest, err := readDeployManifest(p.Mql5Dir)
+	if err != nil {
+		return
+	}
+
+	for _, change := range p.Changes {
+		target := filepath.Join(p.Mql5Dir, change.Path)
+		switch change.Kind {
+		case ChangeAdd, ChangeUpdate:
+			if err = hlp.CopyFile(filepath.Join(p.SourceDir, change.Path), target); err != nil {
+				return
+			}
+			manifest[change.Path] = p.checksums[change.Path]
+		case ChangeRemove:
+			if err = os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return
+			}
+			err = nil
+			delete(manifest, change.Path)
+		}
+		report(change)
+	}
+	// unchanged files deployed before the manifest existed are adopted, too
+	for rel, sum := range p.checksums {
+		manifest[rel] = sum
+	}
+
+	if err = writeDeployManifest(p.Mql5Dir, manifest); err != nil {
+		return
+	}
+
+	return hlp.Adopt(p.Mql5Dir)
+}
+
+// ChangedExperts returns the changed or removed Expert Advisor binaries of the plan.
+func (p DeployPlan) ChangedExperts() (experts []string) {
+	for _, change := range p.Changes {
+		if strings.HasPrefix(change.Path, "Experts"+string(filepath.Separator)) && strings.EqualFold(filepath.Ext(change.Path), ".ex5") {
+			experts = append(experts, change.Path)
+		}
+	}
+
+	return
+}
+
+func fileChecksum(path string) (sum string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return
+	}
+	sum = hex.EncodeToString(hash.Sum(nil))
+
+	return
+}
+
+func readDeployManifest(mql5Dir string) (manifest map[string]string, err error) {
+	manifest = map[string]string{}
+	raw, err := os.ReadFile(filepath.Join(mql5Dir, deployManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(raw, &manifest)
+
+	return
+}
+
+func writeDeployManifest(mql5Dir string, manifest map[string]string) (err error) {
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return
+	}
+
+	return hlp.WriteFresh(filepath.Join(mql5Dir, deployManifestName), raw, 0644)
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for rel, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func planString(plan DeployPlan) string {
+	var changes []string
+	for _, change := range plan.Changes {
+		changes = append(changes, filepath.ToSlash(change.String()))
+	}
+
+	return strings.Join(changes, ",")
+}
+
+func TestPlanDeployDetectsChangesByChecksum(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{"Experts/A.ex5": "a", "Experts/B.ex5": "b2", "Include/C.mqh": "c", "Unrelated/D.txt": "d"})
+	writeTree(t, dst, map[string]string{"Experts/B.ex5": "b1", "Include/C.mqh": "c"})
+
+	plan, err := PlanDeploy(src, dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := planString(plan); result != "+ Experts/A.ex5,~ Experts/B.ex5" {
+		t.Errorf("Expected '%s' to be '%s'", result, "+ Experts/A.ex5,~ Experts/B.ex5")
+	}
+}
+
+func TestPlanDeployPrunesOnlyDeployedFiles(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{"Experts/A.ex5": "a", "Experts/Old.ex5": "old"})
+	writeTree(t, dst, map[string]string{"Experts/Examples/Stock.ex5": "stock"})
+
+	plan, _ := PlanDeploy(src, dst, true)
+	if err := plan.Apply(func(Change) {}); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(src, "Experts", "Old.ex5"))
+
+	plan, err := PlanDeploy(src, dst, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := planString(plan); result != "- Experts/Old.ex5" {
+		t.Errorf("Expected '%s' to be '%s'", result, "- Experts/Old.ex5")
+	}
+	if err = plan.Apply(func(Change) {}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dst, "Experts", "Examples", "Stock.ex5")); err != nil {
+		t.Errorf("Expected stock file to survive pruning")
+	}
+}
+
+func TestParseChartExperts(t *testing.T) {
+	chart := "<chart>\nsymbol=EURUSD\n<expert>\nname=Foo\npath=Experts\\Team\\Foo.ex5\nexpertmode=1\n</expert>\n</chart>\n"
+
+	experts := ParseChartExperts(chart)
+	if len(experts) != 1 || filepath.ToSlash(experts[0]) != "Experts/Team/Foo.ex5" {
+		t.Errorf("Expected '%v' to be '%v'", experts, []string{"Experts/Team/Foo.ex5"})
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+type JobState string
+
+const (
+	JobPending JobState = "pending"
+	JobRunning JobState = "running"
+	JobDone    JobState = "done"
+	JobFailed  JobState = "failed"
+)
+
+// FarmSpec is a tester spec template plus the matrix of symbols, periods and input sets to run it on.
+type FarmSpec struct {
+	Name    string     `json:"name"`
+	Base    TesterSpec `json:"base"`
+	Symbols []string   `json:"symbols"`
+	Periods []string   `json:"periods"`
+	Inputs  []string   `json:"inputs,omitempty"`
+	// MaxInstances caps the concurrent tester instances; CPU and RAM cap them anyway
+	MaxInstances int `json:"maxInstances,omitempty"`
+	// InstanceMemoryMB is the RAM reserved per instance, defaults to 1024
+	InstanceMemoryMB int `json:"instanceMemoryMB,omitempty"`
+}
+
+// FarmJob is a single cell of the matrix.
+type FarmJob struct {
+	ID     string        `json:"id"`
+	Spec   TesterSpec    `json:"spec"`
+	State  JobState      `json:"state"`
+	Error  string        `json:"error,omitempty"`
+	Report *TesterReport `json:"report,omitempty"`
+}
+
+// Farm is the persisted job queue. It lives in $AVL_TESTER/farm-<name>/state.json, so a restarted
+// container continues where it stopped.
+type Farm struct {
+	Name string     `json:"name"`
+	Jobs []*FarmJob `json:"jobs"`
+	dir  string
+	mu   sync.Mutex
+}
+
+// LoadFarmSpec reads a farm spec. Missing names are taken from the file name.
+func LoadFarmSpec(path string) (spec FarmSpec, err error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(raw, &spec); err != nil {
+		err = fmt.Errorf("farm error: invalid spec %s: %w", path, err)
+		return
+	}
+	if len(spec.Name) == 0 {
+		spec.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if len(spec.Symbols) == 0 || len(spec.Periods) == 0 {
+		err = errors.New("farm error: at least one symbol and one period are required")
+	}
+
+	return
+}
+
+// Expand builds the jobs of the matrix (symbol × period × input set). Jobs are told by their ID when
+// the farm resumes, so the same job twice is an error.
+func (s FarmSpec) Expand() (jobs []*FarmJob, err error) {
+	inputs := s.Inputs
+	if len(inputs) == 0 {
+		inputs = []string{s.Base.Inputs}
+	}
+	names := inputNames(inputs)
+	seen := map[string]bool{}
+	for _, symbol := range s.Symbols {
+		for _, period := range s.Periods {
+			for _, input := range inputs {
+				spec := s.Base
+				spec.Symbol, spec.Period, spec.Inputs = symbol, period, input
+				if err = spec.Validate(); err != nil {
+					return
+				}
+				id := symbol + "_" + period
+				if len(input) > 0 {
+					id += "_" + names[input]
+				}
+				if seen[id] {
+					return nil, fmt.Errorf("farm error: job %s is listed twice", id)
+				}
+				seen[id] = true
+				jobs = append(jobs, &FarmJob{ID: id, Spec: spec, State: JobPending})
+			}
+		}
+	}
+
+	return
+}
+
+// inputNames names the input sets by their file name. Sets of the same name in different folders
+// get a short hash of their path appended.
+func inputNames(inputs []string) map[string]string {
+	count := map[string]int{}
+	for _, input := range uniqueStrings(inputs) {
+		count[filepath.Base(input)]++
+	}
+	names := map[string]string{}
+	for _, input := range inputs {
+		names[input] = strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		if count[filepath.Base(input)] > 1 {
+			sum := sha256.Sum256([]byte(input))
+			names[input] += "-" + hex.EncodeToString(sum[:4])
+		}
+	}
+
+	return names
+}
+
+func uniqueStrings(list []string) (unique []string) {
+	seen := map[string]bool{}
+	for _, item := range list {
+		if !seen[item] {
+			seen[item] = true
+			unique = append(unique, item)
+		}
+	}
+
+	return
+}
+
+// Concurrency returns how many instances may run at once: MaxInstances, capped by CPUs and available RAM.
+func (s FarmSpec) Concurrency() (instances int) {
+	instances = runtime.NumCPU()
+	if s.MaxInstances > 0 && s.MaxInstances < instances {
+		instances = s.MaxInstances
+	}
+	perInstance := s.InstanceMemoryMB
+	if perInstance <= 0 {
+		perInstance = 1024
+	}
+	if available, err := hlp.AvailableMemoryMB(); err == nil && available/perInstance < instances {
+		instances = available / perInstance
+	}
+	if instances < 1 {
+		instances = 1
+	}
+
+	return
+}
+
+// OpenFarm loads the persisted state of the farm or creates it. Jobs which were running when the
+// container stopped are queued again; finished ones are kept.
+func OpenFarm(env *[]string, spec FarmSpec) (farm *Farm, err error) {
+	farm = &Farm{Name: spec.Name, dir: filepath.Join(TesterBaseDir(env), "farm-"+spec.Name)}
+	if err = os.MkdirAll(farm.dir, 0755); err != nil {
+		return
+	}
+	jobs, err := spec.Expand()
+	if err != nil {
+		return
+	}
+
+	var persisted Farm
+	if raw, errRead := os.ReadFile(farm.statePath()); errRead == nil {
+		if err = json.Unmarshal(raw, &persisted); err != nil {
+			err = fmt.Errorf("farm error: corrupt state %s: %w", farm.statePath(), err)
+			return
+		}
+	}
+	known := map[string]*FarmJob{}
+	for _, job := range persisted.Jobs {
+		known[job.ID] = job
+	}
+	for _, job := range jobs {
+		if previous, ok := known[job.ID]; ok && previous.State == JobDone {
+			job = previous
+		}
+		farm.Jobs = append(farm.Jobs, job)
+	}
+	err = farm.save()
+
+	return
+}
+
+// Run works off all jobs which are not done with the given number of instances. Each instance gets its
+// own portable data dir and framebuffer. report is called whenever a job finished.
+func (f *Farm) Run(runner ifc.CmdRunner, env *[]string, instances int, creds *Credentials, report func(*FarmJob)) (err error) {
+	queue := make(chan *FarmJob, len(f.Jobs))
+	for _, job := range f.Jobs {
+		if job.State != JobDone {
+			queue <- job
+		}
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	var prepareErr error
+	display := TesterFirstDisplay - 1
+	for i := 0; i < instances; i++ {
+		display = hlp.FreeDisplay(display + 1)
+		instance, errPrepare := PrepareTesterInstance(env, fmt.Sprintf("farm-%d", i), display)
+		if errPrepare != nil {
+			prepareErr = errPrepare
+			break
+		}
+		wg.Add(1)
+		go func(instance TesterInstance) {
+			defer wg.Done()
+			for job := range queue {
+				f.setState(job, JobRunning, nil, nil)
+				result, errRun := RunBacktest(runner, env, instance, job.Spec, creds)
+				if errRun != nil {
+					f.setState(job, JobFailed, nil, errRun)
+				} else {
+					f.setState(job, JobDone, &result, nil)
+				}
+				report(job)
+			}
+		}(instance)
+	}
+	wg.Wait()
+	if prepareErr != nil {
+		return prepareErr
+	}
+
+	return f.WriteSummary()
+}
+
+// WriteSummary writes summary.json and summary.csv holding the results of all jobs.
+func (f *Farm) WriteSummary() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	raw, err := json.MarshalIndent(f.Jobs, "", "  ")
+	if err != nil {
+		return
+	}
+	if err = hlp.WriteFresh(filepath.Join(f.dir, "summary.json"), raw, 0644); err != nil {
+		return
+	}
+
+	file, err := hlp.CreateFresh(filepath.Join(f.dir, "summary.csv"), 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	w.Write([]string{"id", "symbol", "period", "inputs", "state", "net_profit", "profit_factor", "max_drawdown", "max_drawdown_percent", "trades", "error"})
+	for _, job := range f.Jobs {
+		row := []string{job.ID, job.Spec.Symbol, job.Spec.Period, job.Spec.Inputs, string(job.State), "", "", "", "", "", job.Error}
+		if job.Report != nil {
+			row[5] = strconv.FormatFloat(job.Report.NetProfit, 'f', 2, 64)
+			row[6] = strconv.FormatFloat(job.Report.ProfitFactor, 'f', 2, 64)
+			row[7] = strconv.FormatFloat(job.Report.MaxDrawdown, 'f', 2, 64)
+			row[8] = strconv.FormatFloat(job.Report.MaxDrawdownPercent, 'f', 2, 64)
+			row[9] = strconv.Itoa(job.Report.Trades)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// Dir returns the folder holding state and summaries.
+func (f *Farm) Dir() string {
+	return f.dir
+}
+
+func (f *Farm) setState(job *FarmJob, state JobState, result *TesterReport, errRun error) {
+	f.mu.Lock()
+	job.State, job.Report, job.Error = state, result, ""
+	if errRun != nil {
+		job.Error = errRun.Error()
+	}
+	f.mu.Unlock()
+	f.save()
+}
+
+func (f *Farm) statePath() string {
+	return filepath.Join(f.dir, "state.json")
+}
+
+func (f *Farm) save() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	raw, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := f.statePath() + ".tmp"
+	if err = hlp.WriteFresh(tmp, raw, 0644); err != nil {
+		return
+	}
+
+	return os.Rename(tmp, f.statePath())
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"strings"
+	"testing"
+)
+
+func sampleFarmSpec() FarmSpec {
+	return FarmSpec{
+		Name:    "macd",
+		Base:    TesterSpec{Expert: "MACD.ex5", From: "2022-01-01", To: "2022-06-30", Deposit: 10000},
+		Symbols: []string{"EURUSD", "GBPUSD"},
+		Periods: []string{"H1", "H4"},
+		Inputs:  []string{"/sets/fast.set", "/sets/slow.set"},
+	}
+}
+
+func TestFarmSpecExpandsMatrix(t *testing.T) {
+	jobs, err := sampleFarmSpec().Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 8 {
+		t.Errorf("len(jobs): Expected '%d' to be '%d'", len(jobs), 8)
+	}
+	if jobs[1].ID != "EURUSD_H1_slow" {
+		t.Errorf("jobs[1].ID: Expected '%s' to be '%s'", jobs[1].ID, "EURUSD_H1_slow")
+	}
+}
+
+func TestFarmSpecExpandTellsSetsOfTheSameName(t *testing.T) {
+	spec := sampleFarmSpec()
+	spec.Symbols, spec.Periods, spec.Inputs = []string{"EURUSD"}, []string{"H1"}, []string{"/sets/a/trend.set", "/sets/b/trend.set", "/sets/fast.set"}
+	jobs, err := spec.Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].ID == jobs[1].ID || !strings.HasPrefix(jobs[0].ID, "EURUSD_H1_trend-") {
+		t.Errorf("Expected '%s' and '%s' to be distinct IDs of trend sets", jobs[0].ID, jobs[1].ID)
+	}
+	if jobs[2].ID != "EURUSD_H1_fast" {
+		t.Errorf("jobs[2].ID: Expected '%s' to be '%s'", jobs[2].ID, "EURUSD_H1_fast")
+	}
+
+	spec.Inputs = []string{"/sets/fast.set", "/sets/fast.set"}
+	if _, err = spec.Expand(); err == nil {
+		t.Errorf("Expected an error for a job listed twice")
+	}
+}
+
+func TestOpenFarmResumesUnfinishedJobs(t *testing.T) {
+	env := []string{"AVL_TESTER=" + t.TempDir()}
+	farm, err := OpenFarm(&env, sampleFarmSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	farm.setState(farm.Jobs[0], JobDone, &TesterReport{NetProfit: 1}, nil)
+	farm.setState(farm.Jobs[1], JobRunning, nil, nil)
+
+	resumed, err := OpenFarm(&env, sampleFarmSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Jobs[0].State != JobDone || resumed.Jobs[0].Report == nil {
+		t.Errorf("Jobs[0]: Expected finished job to be kept")
+	}
+	if resumed.Jobs[1].State != JobPending {
+		t.Errorf("Jobs[1].State: Expected '%s' to be '%s'", resumed.Jobs[1].State, JobPending)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// LoginState is the outcome of the latest authorization attempt found inside the terminal journal.
+type LoginState int
+
+const (
+	LoginPending LoginState = iota
+	LoginAuthorized
+	LoginFailed
+)
+
+type EventKind string
+
+const (
+	EventAuthorized          EventKind = "authorized"
+	EventAuthFailed          EventKind = "authorization_failed"
+	EventConnectionLost      EventKind = "connection_lost"
+	EventConnectionRestored  EventKind = "connection_restored"
+	EventExpertLoaded        EventKind = "expert_loaded"
+	EventExpertRemoved       EventKind = "expert_removed"
+	EventTradeExecuted       EventKind = "trade_executed"
+	EventOrderFailed         EventKind = "order_failed"
+	EventAutoTradingEnabled  EventKind = "autotrading_enabled"
+	EventAutoTradingDisabled EventKind = "autotrading_disabled"
+)
+
+// LogOrigin tells which log a line was read from.
+type LogOrigin string
+
+const (
+	OriginTerminal LogOrigin = "terminal"
+	OriginExpert   LogOrigin = "expert"
+)
+
+// LogEntry is a single tab-separated line of a terminal or expert log.
+type LogEntry struct {
+	Origin  LogOrigin
+	Code    string
+	Level   string
+	Time    time.Time
+	Source  string
+	Message string
+}
+
+// Event is a typed journal event. Detail is a summary without account number or server, so it is safe
+// to be written to avly.log and status.
+type Event struct {
+	Kind    EventKind `json:"kind"`
+	Time    time.Time `json:"time"`
+	Origin  LogOrigin `json:"origin"`
+	Source  string    `json:"source"`
+	Detail  string    `json:"detail"`
+	Retcode int       `json:"retcode,omitempty"`
+}
+
+// known trade server return codes, as far as the journal only prints their text
+var retcodesByReason = map[string]int{
+	"requote":                        10004,
+	"request rejected":               10006,
+	"request canceled":               10007,
+	"invalid request":                10013,
+	"invalid volume":                 10014,
+	"invalid price":                  10015,
+	"invalid stops":                  10016,
+	"trade disabled":                 10017,
+	"market closed":                  10018,
+	"no money":                       10019,
+	"not enough money":               10019,
+	"prices changed":                 10020,
+	"off quotes":                     10021,
+	"no quotes":                      10021,
+	"too many requests":              10024,
+	"autotrading disabled by client": 10027,
+	"autotrading disabled by server": 10026,
+	"unsupported filling mode":       10030,
+	"no connection":                  10031,
+}
+
+var (
+	accountPrefixRegex  = regexp.MustCompile(`^'[^']*':\s*`)
+	authFailedRegex     = regexp.MustCompile(`authorization on .* failed(?: \((.*)\))?`)
+	expertRegex         = regexp.MustCompile(`^expert (.+ \(.+\)) (loaded successfully|removed)`)
+	dealRegex           = regexp.MustCompile(`^(deal #\d+ .+?) done`)
+	failedOrderRegex    = regexp.MustCompile(`^failed (.+?)\s*\[(.+)\]`)
+	ctradeRegex         = regexp.MustCompile(`^CTrade::\w+: (.+?)\s*\[(.+)\]`)
+	numericRetcodeRegex = regexp.MustCompile(`(?i)(?:retcode|error)\s*[=:]?\s*(10\d{3})`)
+)
+
+// JournalPath returns the terminal journal of the given day.
+func JournalPath(env *[]string, day time.Time) string {
+	return filepath.Join(InstallDir(env), "logs", day.Format("20060102")+".log")
+}
+
+// ExpertLogPath returns the expert log of the given day.
+func ExpertLogPath(env *[]string, day time.Time) string {
+	return filepath.Join(Mql5Dir(env), "Logs", day.Format("20060102")+".log")
+}
+
+// JournalSize returns the current size of today's terminal journal, used as offset for LastLoginState.
+func JournalSize(env *[]string) (size int64) {
+	if info, err := os.Stat(JournalPath(env, time.Now())); err == nil {
+		size = info.Size()
+	}
+
+	return
+}
+
+// LastLoginState scans today's terminal journal behind offset for the latest authorization message.
+func LastLoginState(env *[]string, offset int64) (state LoginState, err error) {
+	raw, err := os.ReadFile(JournalPath(env, time.Now()))
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	if offset > 0 && offset <= int64(len(raw)) {
+		raw = raw[offset:]
+	}
+
+	for _, line := range strings.Split(DecodeUTF16LE(raw), "\n") {
+		entry, ok := ParseLogLine(line, time.Now(), OriginTerminal)
+		if !ok {
+			continue
+		}
+		if event, ok := ClassifyEntry(entry); ok {
+			switch event.Kind {
+			case EventAuthorized:
+				state = LoginAuthorized
+			case EventAuthFailed:
+				state = LoginFailed
+			}
+		}
+	}
+
+	return
+}
+
+// ParseLogLine splits a log line into its code, level, time, source and message. day gives the date,
+// as lines only carry the time.
+func ParseLogLine(line string, day time.Time, origin LogOrigin) (entry LogEntry, ok bool) {
+	fields := strings.SplitN(strings.TrimRight(line, "\r\n"), "\t", 5)
+	if len(fields) < 5 {
+		return
+	}
+	clock, err := time.Parse("15:04:05.000", fields[2])
+	if err != nil {
+		return
+	}
+	entry = LogEntry{
+		Origin:  origin,
+		Code:    fields[0],
+		Level:   fields[1],
+		Time:    time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), clock.Nanosecond(), day.Location()),
+		Source:  fields[3],
+		Message: fields[4],
+	}
+	ok = true
+
+	return
+}
+
+// ClassifyEntry turns a log entry into a typed event, if it is one of the known kinds.
+func ClassifyEntry(entry LogEntry) (event Event, ok bool) {
+	msg := accountPrefixRegex.ReplaceAllString(entry.Message, "")
+	event = Event{Time: entry.Time, Origin: entry.Origin, Source: entry.Source}
+
+	switch {
+	case strings.Contains(msg, "authorized on"):
+		event.Kind, event.Detail = EventAuthorized, "authorized"
+	case authFailedRegex.MatchString(msg):
+		event.Kind, event.Detail = EventAuthFailed, "authorization failed"
+		if reason := authFailedRegex.FindStringSubmatch(msg)[1]; len(reason) > 0 {
+			event.Detail += " (" + reason + ")"
+		}
+	case strings.HasPrefix(msg, "connection to") && strings.HasSuffix(msg, "lost"):
+		event.Kind, event.Detail = EventConnectionLost, "connection lost"
+	case expertRegex.MatchString(msg):
+		match := expertRegex.FindStringSubmatch(msg)
+		event.Kind, event.Detail = EventExpertLoaded, match[1]
+		if match[2] == "removed" {
+			event.Kind = EventExpertRemoved
+		}
+	case strings.HasPrefix(msg, "automated trading is enabled"):
+		event.Kind, event.Detail = EventAutoTradingEnabled, msg
+	case strings.HasPrefix(msg, "automated trading is disabled"):
+		event.Kind, event.Detail = EventAutoTradingDisabled, msg
+	case dealRegex.MatchString(msg):
+		event.Kind, event.Detail = EventTradeExecuted, dealRegex.FindStringSubmatch(msg)[1]
+	case failedOrderRegex.MatchString(msg):
+		match := failedOrderRegex.FindStringSubmatch(msg)
+		event.Kind, event.Detail, event.Retcode = EventOrderFailed, match[1]+" ["+match[2]+"]", retcodesByReason[strings.ToLower(match[2])]
+	case ctradeRegex.MatchString(msg):
+		match := ctradeRegex.FindStringSubmatch(msg)
+		event.Detail = match[1] + " [" + match[2] + "]"
+		if strings.HasPrefix(strings.ToLower(match[2]), "done") {
+			event.Kind = EventTradeExecuted
+		} else {
+			event.Kind, event.Retcode = EventOrderFailed, retcodesByReason[strings.ToLower(match[2])]
+		}
+	case entry.Origin == OriginExpert && numericRetcodeRegex.MatchString(msg):
+		event.Kind, event.Detail = EventOrderFailed, msg
+	default:
+		return
+	}
+	if event.Kind == EventOrderFailed && event.Retcode == 0 {
+		if match := numericRetcodeRegex.FindStringSubmatch(msg); match != nil {
+			event.Retcode, _ = strconv.Atoi(match[1])
+		}
+	}
+	ok = true
+
+	return
+}
+
+// DecodeUTF16LE converts the terminal's UTF-16LE log encoding (optionally with BOM) into a string.
+func DecodeUTF16LE(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xff && raw[1] == 0xfe {
+		raw = raw[2:]
+	}
+	units := make([]uint16, len(raw)/2)
+	for i := 0; i < len(units); i++ {
+		units[i] = uint16(raw[2*i]) | uint16(raw[2*i+1])<<8
+	}
+
+	return strings.ReplaceAll(string(utf16.Decode(units)), "\r", "")
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func encodeUTF16LE(s string) (raw []byte) {
+	for _, unit := range utf16.Encode([]rune(s)) {
+		raw = append(raw, byte(unit), byte(unit>>8))
+	}
+
+	return
+}
+
+func classify(t *testing.T, line string, origin LogOrigin) Event {
+	entry, ok := ParseLogLine(line, time.Date(2022, 5, 4, 0, 0, 0, 0, time.UTC), origin)
+	if !ok {
+		t.Fatalf("Expected '%s' to be a log line", line)
+	}
+	event, ok := ClassifyEntry(entry)
+	if !ok {
+		t.Fatalf("Expected '%s' to be an event", line)
+	}
+
+	return event
+}
+
+func TestParseLogLine(t *testing.T) {
+	entry, ok := ParseLogLine("KO\t0\t10:15:30.123\tNetwork\t'1234': connection to Broker-Live lost", time.Date(2022, 5, 4, 0, 0, 0, 0, time.UTC), OriginTerminal)
+
+	if !ok || entry.Source != "Network" || entry.Time.Format("2006-01-02 15:04:05") != "2022-05-04 10:15:30" {
+		t.Errorf("Expected '%+v' to be a Network entry at 2022-05-04 10:15:30", entry)
+	}
+}
+
+func TestClassifyEntryHidesAccountAndServer(t *testing.T) {
+	event := classify(t, "KO\t0\t10:15:30.123\tNetwork\t'1234': authorization on Broker-Live failed (Invalid account)", OriginTerminal)
+
+	if event.Kind != EventAuthFailed || event.Detail != "authorization failed (Invalid account)" {
+		t.Errorf("Expected '%s: %s' to be '%s: %s'", event.Kind, event.Detail, EventAuthFailed, "authorization failed (Invalid account)")
+	}
+}
+
+func TestClassifyEntryKinds(t *testing.T) {
+	lines := map[string]EventKind{
+		"KO\t0\t10:00:00.000\tNetwork\t'1234': authorized on Broker-Live through Access Point EU (ping: 4.1 ms, build 3802)": EventAuthorized,
+		"KO\t0\t10:00:00.000\tExperts\texpert MACD Sample (EURUSD,H1) loaded successfully":                                   EventExpertLoaded,
+		"KO\t0\t10:00:00.000\tExperts\texpert MACD Sample (EURUSD,H1) removed":                                               EventExpertRemoved,
+		"KO\t0\t10:00:00.000\tExperts\tautomated trading is disabled because the account has been changed":                   EventAutoTradingDisabled,
+		"KO\t0\t10:00:00.000\tTrades\t'1234': deal #42 buy 0.1 EURUSD at 1.10000 done (based on order #41)":                  EventTradeExecuted,
+	}
+
+	for line, expected := range lines {
+		if event := classify(t, line, OriginTerminal); event.Kind != expected {
+			t.Errorf("Expected '%s' to be '%s'", event.Kind, expected)
+		}
+	}
+}
+
+func TestClassifyEntryOrderFailedRetcode(t *testing.T) {
+	terminal := classify(t, "KO\t2\t10:00:00.000\tTrades\t'1234': failed market buy 0.1 EURUSD [Market closed]", OriginTerminal)
+	expert := classify(t, "KO\t2\t10:00:00.000\tMACD Sample (EURUSD,H1)\tOrderSend failed, retcode=10016", OriginExpert)
+
+	if terminal.Kind != EventOrderFailed || terminal.Retcode != 10018 {
+		t.Errorf("Expected '%s/%d' to be '%s/%d'", terminal.Kind, terminal.Retcode, EventOrderFailed, 10018)
+	}
+	if expert.Kind != EventOrderFailed || expert.Retcode != 10016 {
+		t.Errorf("Expected '%s/%d' to be '%s/%d'", expert.Kind, expert.Retcode, EventOrderFailed, 10016)
+	}
+}
+
+func TestJournalTailerReportsNewCompleteLines(t *testing.T) {
+	env := []string{"WINEPREFIX=" + t.TempDir()}
+	now := time.Now()
+	path := JournalPath(&env, now)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.WriteFile(path, encodeUTF16LE("KO\t0\t09:00:00.000\tNetwork\t'1': authorized on X through Y\n"), 0644)
+
+	tailer := NewJournalTailer(&env)
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	defer file.Close()
+	file.Write(encodeUTF16LE("KO\t0\t10:00:00.000\tNetwork\t'1': connection to X lost\nKO\t0\t10:01:00.000\tNetwork\t'1': authorized on X"))
+
+	events, err := tailer.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Kind != EventConnectionLost {
+		t.Fatalf("Expected '%v' to be a single '%s' event", events, EventConnectionLost)
+	}
+
+	file.Write(encodeUTF16LE(" through Y\n"))
+	events, _ = tailer.Poll()
+	var kinds []string
+	for _, event := range events {
+		kinds = append(kinds, string(event.Kind))
+	}
+	if strings.Join(kinds, ",") != "authorized,connection_restored" {
+		t.Errorf("Expected '%s' to be '%s'", strings.Join(kinds, ","), "authorized,connection_restored")
+	}
+}
+
+func TestInstalledBuild(t *testing.T) {
+	env := []string{"WINEPREFIX=" + t.TempDir()}
+	if build := InstalledBuild(&env); build != "" {
+		t.Errorf("Expected '%v' to be empty", build)
+	}
+	older := JournalPath(&env, time.Date(2022, 5, 3, 0, 0, 0, 0, time.UTC))
+	os.MkdirAll(filepath.Dir(older), 0755)
+	os.WriteFile(older, encodeUTF16LE("KO\t0\t09:00:00.000\tTerminal\tMetaTrader 5 x64 build 3260 started for MetaQuotes Software Corp.\n"), 0644)
+	newer := JournalPath(&env, time.Date(2022, 5, 4, 0, 0, 0, 0, time.UTC))
+	os.WriteFile(newer, encodeUTF16LE("KO\t0\t09:00:00.000\tTerminal\tMetaTrader 5 x64 build 3320 started for MetaQuotes Software Corp.\n"), 0644)
+
+	if build := InstalledBuild(&env); build != "3320" {
+		t.Errorf("Expected '%v' to be '%v'", build, "3320")
+	}
+}
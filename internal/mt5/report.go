@@ -0,0 +1,104 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TesterReport holds the key figures of a Strategy Tester report.
+type TesterReport struct {
+	NetProfit          float64 `json:"netProfit"`
+	GrossProfit        float64 `json:"grossProfit"`
+	GrossLoss          float64 `json:"grossLoss"`
+	ProfitFactor       float64 `json:"profitFactor"`
+	MaxDrawdown        float64 `json:"maxDrawdown"`
+	MaxDrawdownPercent float64 `json:"maxDrawdownPercent"`
+	Trades             int     `json:"trades"`
+	RecoveryFactor     float64 `json:"recoveryFactor"`
+	ExpectedPayoff     float64 `json:"expectedPayoff"`
+}
+
+var (
+	reportCellRegex  = regexp.MustCompile(`(?is)<(td|Cell)[^>]*>(.*?)</(td|Cell)>`)
+	reportTagRegex   = regexp.MustCompile(`(?s)<[^>]*>`)
+	reportValueRegex = regexp.MustCompile(`^(-?[\d\s]*[\d](?:\.\d+)?)(?:\s*\((-?[\d.]+)%\))?`)
+)
+
+// ParseTesterReport reads the figures of an HTML or XML (SpreadsheetML) report. Both formats are
+// tables of label cells followed by value cells.
+func ParseTesterReport(report string) (result TesterReport, err error) {
+	var cells []string
+	for _, match := range reportCellRegex.FindAllStringSubmatch(report, -1) {
+		text := strings.TrimSpace(html.UnescapeString(reportTagRegex.ReplaceAllString(match[2], "")))
+		text = strings.ReplaceAll(text, " ", " ")
+		if len(text) > 0 {
+			cells = append(cells, text)
+		}
+	}
+
+	found := map[string]bool{}
+	for i := 0; i+1 < len(cells); i++ {
+		label := strings.TrimSuffix(cells[i], ":")
+		if found[label] {
+			// the deals table repeats some words, the summary comes first
+			continue
+		}
+		value, percent, ok := parseReportValue(cells[i+1])
+		if !ok {
+			continue
+		}
+		switch label {
+		case "Total Net Profit":
+			result.NetProfit = value
+		case "Gross Profit":
+			result.GrossProfit = value
+		case "Gross Loss":
+			result.GrossLoss = value
+		case "Profit Factor":
+			result.ProfitFactor = value
+		case "Recovery Factor":
+			result.RecoveryFactor = value
+		case "Expected Payoff":
+			result.ExpectedPayoff = value
+		case "Total Trades":
+			result.Trades = int(value)
+		case "Equity Drawdown Maximal":
+			result.MaxDrawdown, result.MaxDrawdownPercent = value, percent
+		default:
+			continue
+		}
+		found[label] = true
+	}
+	if !found["Total Net Profit"] {
+		err = errors.New("report error: no summary found in tester report")
+	}
+
+	return
+}
+
+// parseReportValue reads numbers like "1 234.56" or "123.45 (1.23%)".
+func parseReportValue(cell string) (value, percent float64, ok bool) {
+	match := reportValueRegex.FindStringSubmatch(cell)
+	if match == nil {
+		return
+	}
+	value, errValue := strconv.ParseFloat(strings.Join(strings.Fields(match[1]), ""), 64)
+	if errValue != nil {
+		return
+	}
+	if len(match[2]) > 0 {
+		percent, _ = strconv.ParseFloat(match[2], 64)
+	}
+	ok = true
+
+	return
+}
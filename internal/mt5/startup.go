@@ -0,0 +1,74 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+const startupIniName = "startup.ini"
+
+// WriteStartupIni writes a transient '/config:' file carrying the broker login into TerminalRuntimeDir.
+// The file is created with 0600 permissions and has to be removed with RemoveStartupIni as soon as possible.
+func WriteStartupIni(env *[]string, creds Credentials) (path string, err error) {
+	if len(RuntimeDir(env)) == 0 {
+		err = errors.New("startup ini error: AVL_RUNTIME is not set")
+		return
+	}
+	dir := TerminalRuntimeDir(env)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	path = filepath.Join(dir, startupIniName)
+	err = writePrivateIni(path, commonSection(creds))
+
+	return
+}
+
+// RemoveStartupIni deletes the transient startup file. A missing file is not an error.
+func RemoveStartupIni(env *[]string) (err error) {
+	if len(RuntimeDir(env)) == 0 {
+		return
+	}
+	if err = os.Remove(filepath.Join(TerminalRuntimeDir(env), startupIniName)); errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+
+	return
+}
+
+func commonSection(creds Credentials) string {
+	var b strings.Builder
+	b.WriteString("[Common]\r\n")
+	fmt.Fprintf(&b, "Login=%s\r\n", creds.Login)
+	fmt.Fprintf(&b, "Password=%s\r\n", creds.Password)
+	fmt.Fprintf(&b, "Server=%s\r\n", creds.Server)
+
+	return b.String()
+}
+
+// writePrivateIni writes content readable by the owner only, as it may carry credentials. The folder
+// may belong to the user of the terminal, so a file or link left at path is replaced, not followed.
+func writePrivateIni(path, content string) (err error) {
+	file, err := hlp.CreateFresh(path, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if _, err = file.WriteString(content); err != nil {
+		return
+	}
+
+	// the terminal may run as another user than avly
+	return hlp.Adopt(path)
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// JournalTailer follows today's terminal journal and expert log and turns new lines into events.
+// Files are switched at midnight, when the terminal starts a new one.
+type JournalTailer struct {
+	env          *[]string
+	files        map[LogOrigin]*tailedFile
+	disconnected bool
+	now          func() time.Time
+}
+
+type tailedFile struct {
+	path   string
+	offset int64
+	// rest keeps an incomplete last line until the terminal finished writing it
+	rest []byte
+}
+
+// NewJournalTailer starts tailing at the current end of the logs, so only new lines are reported.
+func NewJournalTailer(env *[]string) *JournalTailer {
+	t := &JournalTailer{env: env, files: map[LogOrigin]*tailedFile{}, now: time.Now}
+	for _, origin := range []LogOrigin{OriginTerminal, OriginExpert} {
+		file := &tailedFile{path: t.pathOf(origin)}
+		if info, err := os.Stat(file.path); err == nil {
+			file.offset = info.Size()
+		}
+		t.files[origin] = file
+	}
+
+	return t
+}
+
+// Poll reads the lines appended since the last call and returns their events in order.
+func (t *JournalTailer) Poll() (events []Event, err error) {
+	for _, origin := range []LogOrigin{OriginTerminal, OriginExpert} {
+		file := t.files[origin]
+		if path := t.pathOf(origin); path != file.path {
+			file.path, file.offset, file.rest = path, 0, nil
+		}
+		lines, errRead := file.readLines()
+		if errRead != nil {
+			err = errRead
+			continue
+		}
+		for _, line := range lines {
+			entry, ok := ParseLogLine(line, t.now(), origin)
+			if !ok {
+				continue
+			}
+			event, ok := ClassifyEntry(entry)
+			if !ok {
+				continue
+			}
+			events = append(events, t.track(event)...)
+		}
+	}
+
+	return
+}
+
+// track derives connection_restored from an authorization following a lost connection.
+func (t *JournalTailer) track(event Event) []Event {
+	switch event.Kind {
+	case EventConnectionLost:
+		t.disconnected = true
+	case EventAuthorized:
+		if t.disconnected {
+			t.disconnected = false
+			restored := event
+			restored.Kind, restored.Detail = EventConnectionRestored, "connection restored"
+			return []Event{event, restored}
+		}
+	}
+
+	return []Event{event}
+}
+
+func (t *JournalTailer) pathOf(origin LogOrigin) string {
+	if origin == OriginExpert {
+		return ExpertLogPath(t.env, t.now())
+	}
+
+	return JournalPath(t.env, t.now())
+}
+
+func (f *tailedFile) readLines() (lines []string, err error) {
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if info, errStat := file.Stat(); errStat == nil && info.Size() < f.offset {
+		// truncated or replaced
+		f.offset, f.rest = 0, nil
+	}
+	if _, err = file.Seek(f.offset, io.SeekStart); err != nil {
+		return
+	}
+	chunk, err := io.ReadAll(file)
+	if err != nil {
+		return
+	}
+	f.offset += int64(len(chunk))
+
+	buf := append(f.rest, chunk...)
+	// complete lines end with a UTF-16LE '\n' (0x0a 0x00) on an even position
+	end := -1
+	for i := len(buf) - 2; i >= 0; i-- {
+		if i%2 == 0 && buf[i] == '\n' && buf[i+1] == 0 {
+			end = i + 2
+			break
+		}
+	}
+	if end < 0 {
+		f.rest = buf
+		return
+	}
+	f.rest = append([]byte{}, buf[end:]...)
+	for _, line := range strings.Split(DecodeUTF16LE(buf[:end]), "\n") {
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return
+}
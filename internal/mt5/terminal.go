@@ -0,0 +1,60 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+const (
+	// TerminalExecutable is the process name of the trading terminal.
+	TerminalExecutable = "terminal64.exe"
+	// installSubdir is where mt5setup.exe puts the terminal inside a Wine prefix.
+	installSubdir = "dosdevices/c:/Program Files/MetaTrader 5"
+)
+
+// InstallDir returns the terminal's install directory inside the prefix of env. As the terminal runs
+// with '/portable', this is its data directory, too.
+func InstallDir(env *[]string) string {
+	return filepath.Join(hlp.EnvValue(env, "WINEPREFIX"), installSubdir)
+}
+
+// RuntimeDir returns the directory for transient files which must not outlive the container (tmpfs).
+func RuntimeDir(env *[]string) string {
+	return hlp.EnvValue(env, "AVL_RUNTIME")
+}
+
+// TerminalRuntimeDir returns the folder inside the runtime directory for transient files the terminal
+// reads. Unlike the rest of the runtime directory, it belongs to the user of the terminal.
+func TerminalRuntimeDir(env *[]string) string {
+	return filepath.Join(RuntimeDir(env), "terminal")
+}
+
+var buildRegex = regexp.MustCompile(`MetaTrader 5 x64 build (\d+)`)
+
+// InstalledBuild returns the terminal build the newest journal reports on start, or an empty string
+// if the terminal never ran.
+func InstalledBuild(env *[]string) string {
+	journals, _ := filepath.Glob(filepath.Join(InstallDir(env), "logs", "*.log"))
+	sort.Sort(sort.Reverse(sort.StringSlice(journals)))
+	for _, journal := range journals {
+		raw, err := os.ReadFile(journal)
+		if err != nil {
+			continue
+		}
+		if matches := buildRegex.FindAllStringSubmatch(DecodeUTF16LE(raw), -1); len(matches) > 0 {
+			return matches[len(matches)-1][1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,277 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/9tmark/avly-trader/internal/config"
+	"github.com/9tmark/avly-trader/internal/display"
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+// TesterPeriods are the chart periods known by the Strategy Tester.
+var TesterPeriods = []string{"M1", "M2", "M3", "M4", "M5", "M6", "M10", "M12", "M15", "M20", "M30", "H1", "H2", "H3", "H4", "H6", "H8", "H12", "D1", "W1", "MN1"}
+
+// TesterSpec describes a single Strategy Tester run.
+type TesterSpec struct {
+	// Expert is relative to MQL5/Experts, e.g. "Examples/MACD/MACD Sample.ex5"
+	Expert string `json:"expert"`
+	Symbol string `json:"symbol"`
+	Period string `json:"period"`
+	// From and To are formatted as YYYY-MM-DD
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Model: 0 every tick, 1 1 minute OHLC, 2 open prices only, 3 math calculations, 4 every tick based on real ticks
+	Model    int     `json:"model"`
+	Deposit  float64 `json:"deposit"`
+	Currency string  `json:"currency,omitempty"`
+	Leverage int     `json:"leverage,omitempty"`
+	// Inputs is the path of a .set file holding the expert's input parameters
+	Inputs string `json:"inputs,omitempty"`
+	// TimeoutMinutes caps the run; defaults to 120
+	TimeoutMinutes int `json:"timeoutMinutes,omitempty"`
+}
+
+// TesterInstance is a portable terminal data dir with a Wine prefix of its own, separate from the
+// live trading instance.
+type TesterInstance struct {
+	Name string
+	Dir  string
+	// Prefix is the instance's WINEPREFIX, so its processes are never taken for the live terminal's
+	Prefix  string
+	Display int
+	// Screen is the geometry of the instance's framebuffer
+	Screen display.Geometry
+}
+
+// TesterFirstDisplay keeps tester framebuffers away from the live one ($DISPLAY).
+const TesterFirstDisplay = 10
+
+const (
+	testerReportName = "avly-report"
+	// testerDisplayName holds the display of an instance while it runs
+	testerDisplayName = "avly-display"
+	testerIniName     = "tester.ini"
+	// testerPrefixSuffix names the Wine prefix of an instance next to its data dir
+	testerPrefixSuffix = ".wineprefix"
+)
+
+// LoadTesterSpec reads and validates a JSON tester spec.
+func LoadTesterSpec(path string) (spec TesterSpec, err error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(raw, &spec); err != nil {
+		err = fmt.Errorf("tester error: invalid spec %s: %w", path, err)
+		return
+	}
+	err = spec.Validate()
+
+	return
+}
+
+// Validate checks the spec and fills in defaults.
+func (s *TesterSpec) Validate() (err error) {
+	if len(s.Expert) == 0 || len(s.Symbol) == 0 {
+		return errors.New("tester error: expert and symbol are required")
+	}
+	var knownPeriod bool
+	for _, period := range TesterPeriods {
+		knownPeriod = knownPeriod || period == s.Period
+	}
+	if !knownPeriod {
+		return fmt.Errorf("tester error: unknown period '%s'", s.Period)
+	}
+	from, errFrom := time.Parse("2006-01-02", s.From)
+	to, errTo := time.Parse("2006-01-02", s.To)
+	if errFrom != nil || errTo != nil || !from.Before(to) {
+		return errors.New("tester error: from and to need to be dates (YYYY-MM-DD) in ascending order")
+	}
+	if s.Model < 0 || s.Model > 4 {
+		return fmt.Errorf("tester error: unknown model %d", s.Model)
+	}
+	if s.Deposit <= 0 {
+		return errors.New("tester error: deposit needs to be positive")
+	}
+	if len(s.Currency) == 0 {
+		s.Currency = "USD"
+	}
+	if s.Leverage == 0 {
+		s.Leverage = 100
+	}
+	if s.TimeoutMinutes == 0 {
+		s.TimeoutMinutes = 120
+	}
+
+	return
+}
+
+// Ini renders the '[Tester]' section. The report is written to reports/avly-report.htm of the instance.
+func (s TesterSpec) Ini() string {
+	expert := strings.ReplaceAll(s.Expert, "/", "\\")
+	expert = strings.TrimPrefix(expert, "Experts\\")
+	date := func(d string) string { return strings.ReplaceAll(d, "-", ".") }
+
+	var b strings.Builder
+	b.WriteString("[Tester]\r\n")
+	fmt.Fprintf(&b, "Expert=%s\r\n", expert)
+	if len(s.Inputs) > 0 {
+		fmt.Fprintf(&b, "ExpertParameters=%s\r\n", filepath.Base(s.Inputs))
+	}
+	fmt.Fprintf(&b, "Symbol=%s\r\n", s.Symbol)
+	fmt.Fprintf(&b, "Period=%s\r\n", s.Period)
+	fmt.Fprintf(&b, "Model=%d\r\n", s.Model)
+	fmt.Fprintf(&b, "FromDate=%s\r\n", date(s.From))
+	fmt.Fprintf(&b, "ToDate=%s\r\n", date(s.To))
+	// %g turns large deposits into exponents, which the tester does not read
+	fmt.Fprintf(&b, "Deposit=%s\r\n", strconv.FormatFloat(s.Deposit, 'f', -1, 64))
+	fmt.Fprintf(&b, "Currency=%s\r\n", s.Currency)
+	fmt.Fprintf(&b, "Leverage=1:%d\r\n", s.Leverage)
+	b.WriteString("Optimization=0\r\n")
+	b.WriteString("Visual=0\r\n")
+	fmt.Fprintf(&b, "Report=reports\\%s\r\n", testerReportName)
+	b.WriteString("ReplaceReport=1\r\n")
+	b.WriteString("ShutdownTerminal=1\r\n")
+
+	return b.String()
+}
+
+// TesterBaseDir returns the folder holding all tester instances.
+func TesterBaseDir(env *[]string) string {
+	return hlp.EnvValue(env, "AVL_TESTER")
+}
+
+// PrepareTesterInstance clones the live terminal into its own portable data dir and the live Wine
+// prefix (without the terminal) into the instance's prefix on first use. Later calls only sync the
+// MQL5 artifacts, so freshly deployed experts are tested. Logs are never cloned.
+func PrepareTesterInstance(env *[]string, name string, display int) (instance TesterInstance, err error) {
+	cfg, err := config.Load(env)
+	if err != nil {
+		return
+	}
+	instance = TesterInstance{
+		Name:    name,
+		Dir:     filepath.Join(TesterBaseDir(env), name),
+		Prefix:  filepath.Join(TesterBaseDir(env), name+testerPrefixSuffix),
+		Display: display,
+		Screen:  cfg.DisplayOf(name),
+	}
+	template := InstallDir(env)
+	if _, err = os.Stat(filepath.Join(template, TerminalExecutable)); err != nil {
+		err = fmt.Errorf("tester error: no terminal installed at %s", template)
+		return
+	}
+
+	if _, errStat := os.Stat(filepath.Join(instance.Dir, TerminalExecutable)); errStat != nil {
+		err = hlp.CopyTree(template, instance.Dir, func(rel string) bool {
+			base := strings.ToLower(filepath.Base(rel))
+			return base == "logs" || rel == testerIniName || rel == "reports"
+		})
+		if err != nil {
+			return
+		}
+	}
+	if _, errStat := os.Stat(filepath.Join(instance.Prefix, "system.reg")); errStat != nil {
+		terminal := filepath.Join("drive_c", "Program Files", "MetaTrader 5")
+		err = hlp.CopyTree(hlp.EnvValue(env, "WINEPREFIX"), instance.Prefix, func(rel string) bool {
+			return rel == terminal
+		})
+		if err != nil {
+			return
+		}
+	}
+	plan, err := PlanDeploy(Mql5Dir(env), filepath.Join(instance.Dir, "MQL5"), false)
+	if err != nil {
+		return
+	}
+	if err = plan.Apply(func(Change) {}); err != nil {
+		return
+	}
+	if err = hlp.Adopt(instance.Prefix); err != nil {
+		return
+	}
+	err = hlp.Adopt(instance.Dir)
+
+	return
+}
+
+// WriteIni puts the spec (and the broker login, if any) into the instance's '/config:' file and
+// copies the input set file to where the tester looks for it.
+func (i TesterInstance) WriteIni(spec TesterSpec, creds *Credentials) (path string, err error) {
+	if len(spec.Inputs) > 0 {
+		if err = hlp.CopyFile(spec.Inputs, filepath.Join(i.Dir, "MQL5", "Profiles", "Tester", filepath.Base(spec.Inputs))); err != nil {
+			return
+		}
+	}
+	content := spec.Ini()
+	if creds != nil {
+		content = commonSection(*creds) + content
+	}
+	path = filepath.Join(i.Dir, testerIniName)
+	err = writePrivateIni(path, content)
+
+	return
+}
+
+// CmdLine returns the command line starting the instance's terminal with its ini.
+func (i TesterInstance) CmdLine() string {
+	return strings.Join([]string{
+		"wine",
+		hlp.ShellQuote(filepath.Join(i.Dir, TerminalExecutable)),
+		"/portable",
+		hlp.ShellQuote("/config:" + hlp.WinePath(filepath.Join(i.Dir, testerIniName))),
+	}, " ")
+}
+
+// Env returns a copy of env pointing to the instance's display and prefix.
+func (i TesterInstance) Env(env *[]string) []string {
+	instanceEnv := append([]string{}, *env...)
+	hlp.SetEnvValue(&instanceEnv, "DISPLAY", fmt.Sprintf(":%d", i.Display))
+	hlp.SetEnvValue(&instanceEnv, "WINEPREFIX", i.Prefix)
+
+	return instanceEnv
+}
+
+// RunningTesterDisplay returns the display of the tester instance name while it runs a job.
+func RunningTesterDisplay(env *[]string, name string) (display string, err error) {
+	raw, err := os.ReadFile(filepath.Join(TesterBaseDir(env), filepath.Base(name), testerDisplayName))
+	if errors.Is(err, os.ErrNotExist) {
+		err = fmt.Errorf("tester error: instance '%s' is not running", name)
+		return
+	}
+	display = strings.TrimSpace(string(raw))
+
+	return
+}
+
+// ReportPath returns the path of the report written by the last run (HTML by default, XML if
+// the terminal was told so).
+func (i TesterInstance) ReportPath() string {
+	base := filepath.Join(i.Dir, "reports", testerReportName)
+	if _, err := os.Stat(base + ".xml"); err == nil {
+		return base + ".xml"
+	}
+
+	return base + ".htm"
+}
+
+// ClearRun removes the ini and the report of a previous run.
+func (i TesterInstance) ClearRun() {
+	base := filepath.Join(i.Dir, "reports", testerReportName)
+	os.Remove(filepath.Join(i.Dir, testerIniName))
+	os.Remove(base + ".htm")
+	os.Remove(base + ".xml")
+}
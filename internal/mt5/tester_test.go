@@ -0,0 +1,87 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleHTMLReport = `<table>
+<tr align=right><td nowrap colspan=3>Total Net Profit:</td><td nowrap><b>1 234.56</b></td>
+<td nowrap colspan=3>Balance Drawdown Absolute:</td><td nowrap><b>12.00</b></td></tr>
+<tr align=right><td nowrap colspan=3>Gross Profit:</td><td nowrap><b>2 000.00</b></td>
+<td nowrap colspan=3>Equity Drawdown Maximal:</td><td nowrap><b>321.10 (3.05%)</b></td></tr>
+<tr align=right><td nowrap colspan=3>Profit Factor:</td><td nowrap><b>1.61</b></td>
+<td nowrap colspan=3>Total Trades:</td><td nowrap><b>42</b></td></tr>
+</table>`
+
+const sampleXMLReport = `<Row><Cell><Data ss:Type="String">Total Net Profit:</Data></Cell><Cell><Data ss:Type="Number">-50.5</Data></Cell></Row>
+<Row><Cell><Data ss:Type="String">Total Trades:</Data></Cell><Cell><Data ss:Type="Number">7</Data></Cell></Row>`
+
+func TestParseTesterReportHTML(t *testing.T) {
+	report, err := ParseTesterReport(sampleHTMLReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.NetProfit != 1234.56 {
+		t.Errorf("NetProfit: Expected '%v' to be '%v'", report.NetProfit, 1234.56)
+	}
+	if report.MaxDrawdown != 321.10 || report.MaxDrawdownPercent != 3.05 {
+		t.Errorf("MaxDrawdown: Expected '%v (%v%%)' to be '321.1 (3.05%%)'", report.MaxDrawdown, report.MaxDrawdownPercent)
+	}
+	if report.Trades != 42 || report.ProfitFactor != 1.61 {
+		t.Errorf("Expected '%d' trades and profit factor '%v' to be '42' and '1.61'", report.Trades, report.ProfitFactor)
+	}
+}
+
+func TestParseTesterReportXML(t *testing.T) {
+	report, err := ParseTesterReport(sampleXMLReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.NetProfit != -50.5 || report.Trades != 7 {
+		t.Errorf("Expected '%v/%d' to be '-50.5/7'", report.NetProfit, report.Trades)
+	}
+}
+
+func TestParseTesterReportWithoutSummary(t *testing.T) {
+	if _, err := ParseTesterReport("<html></html>"); err == nil || !strings.HasPrefix(err.Error(), "report error") {
+		t.Errorf("err: Expected '%v' to be a 'report error'", err)
+	}
+}
+
+func TestTesterSpecIni(t *testing.T) {
+	spec := TesterSpec{Expert: "Experts/Examples/MACD.ex5", Symbol: "EURUSD", Period: "H1", From: "2022-01-01", To: "2022-06-30", Deposit: 10000, Inputs: "/opt/mql5/sets/macd.set"}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	ini := spec.Ini()
+	for _, expected := range []string{"Expert=Examples\\MACD.ex5\r\n", "ExpertParameters=macd.set\r\n", "Deposit=10000\r\n", "FromDate=2022.01.01\r\n", "Leverage=1:100\r\n", "ShutdownTerminal=1\r\n"} {
+		if !strings.Contains(ini, expected) {
+			t.Errorf("Expected ini to contain '%s'", strings.TrimSpace(expected))
+		}
+	}
+}
+
+func TestTesterSpecIniLargeDeposit(t *testing.T) {
+	for deposit, expected := range map[float64]string{1000000: "Deposit=1000000\r\n", 2500000.5: "Deposit=2500000.5\r\n"} {
+		spec := TesterSpec{Expert: "A.ex5", Symbol: "EURUSD", Period: "H1", From: "2022-01-01", To: "2022-06-30", Deposit: deposit}
+		if ini := spec.Ini(); !strings.Contains(ini, expected) {
+			t.Errorf("Expected ini to contain '%s'", strings.TrimSpace(expected))
+		}
+	}
+}
+
+func TestTesterSpecValidateRejectsUnknownPeriod(t *testing.T) {
+	spec := TesterSpec{Expert: "A.ex5", Symbol: "EURUSD", Period: "H5", From: "2022-01-01", To: "2022-06-30", Deposit: 10000}
+
+	if err := spec.Validate(); err == nil || !strings.HasPrefix(err.Error(), "tester error") {
+		t.Errorf("err: Expected '%v' to be a 'tester error'", err)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mt5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+// RunBacktest runs spec on the instance's terminal and its own framebuffer, waits until the terminal
+// shuts down (ShutdownTerminal=1) and parses the report. The report is saved as JSON next to it.
+func RunBacktest(runner ifc.CmdRunner, env *[]string, instance TesterInstance, spec TesterSpec, creds *Credentials) (report TesterReport, err error) {
+	instance.ClearRun()
+	if _, err = instance.WriteIni(spec, creds); err != nil {
+		return
+	}
+	// the ini may carry the broker login
+	defer os.Remove(filepath.Join(instance.Dir, testerIniName))
+
+	instanceEnv := instance.Env(env)
+	_, xvfbProc, err := runner.RunCmdAsync(fmt.Sprintf("Xvfb :%d -screen 0 %s -dpi %d > $AVL_PROCESS_LOGS/xvfb-%s.log 2>&1", instance.Display, instance.Screen.WHD(), instance.Screen.DPI, instance.Name), &instanceEnv)
+	defer hlp.KillProcGroup(xvfbProc)
+	if err != nil {
+		return
+	}
+	// tells 'avly -screenshot -instance' where to look
+	hlp.WriteFresh(filepath.Join(instance.Dir, testerDisplayName), []byte(fmt.Sprintf(":%d", instance.Display)), 0644)
+	defer os.Remove(filepath.Join(instance.Dir, testerDisplayName))
+
+	// the prefix is the instance's own, so this does not touch the live terminal
+	hlp.SetWineDPI(runner, &instanceEnv, instance.Screen.DPI)
+	_, terminalProc, err := runner.RunCmdAsync(instance.CmdLine()+fmt.Sprintf(" > $AVL_PROCESS_LOGS/tester-%s.log 2>&1", instance.Name), &instanceEnv)
+	if err != nil {
+		return
+	}
+	if terminalProc == nil {
+		err = errors.New("tester error: terminal was not started")
+		return
+	}
+	finished := make(chan error, 1)
+	go func() {
+		finished <- terminalProc.Wait()
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Duration(spec.TimeoutMinutes) * time.Minute):
+		hlp.KillProcGroup(terminalProc)
+		err = fmt.Errorf("tester error: run on instance '%s' timed out after %d minutes", instance.Name, spec.TimeoutMinutes)
+		return
+	}
+
+	raw, err := os.ReadFile(instance.ReportPath())
+	if err != nil {
+		err = fmt.Errorf("tester error: terminal of instance '%s' did not write a report", instance.Name)
+		return
+	}
+	if report, err = ParseTesterReport(DecodeText(raw)); err != nil {
+		return
+	}
+	if summary, errJSON := json.MarshalIndent(report, "", "  "); errJSON == nil {
+		hlp.WriteFresh(filepath.Join(instance.Dir, "reports", testerReportName+".json"), summary, 0644)
+	}
+
+	return
+}
@@ -0,0 +1,264 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+const (
+	// MonoMSI and GeckoMSI are the installers in THIRD_PARTY a recipe runs by default
+	MonoMSI  = "wine-mono-7.1.1-x86.msi"
+	GeckoMSI = "wine_gecko-2.47-x86_64.msi"
+	// terminalSetup installs the terminal, as in prepare
+	terminalSetup = "mt5setup.exe"
+
+	uninstallKey = `software\microsoft\windows\currentversion\uninstall\`
+)
+
+// Repair is an action fixing an issue of a prefix.
+type Repair string
+
+const (
+	// RepairRebuild replaces the prefix, from a snapshot if one is available
+	RepairRebuild         Repair = "rebuild"
+	RepairRemoveLock      Repair = "remove-lock"
+	RepairRelink          Repair = "relink"
+	RepairWineboot        Repair = "wineboot"
+	RepairReinstallMono   Repair = "reinstall-mono"
+	RepairReinstallGecko  Repair = "reinstall-gecko"
+	RepairReinstallTarget Repair = "reinstall-terminal"
+)
+
+// repairOrder runs cheap repairs first; later ones rely on a working wineserver and registry
+var repairOrder = []Repair{RepairRebuild, RepairRemoveLock, RepairRelink, RepairWineboot, RepairReinstallMono, RepairReinstallGecko, RepairReinstallTarget}
+
+// Issue is a problem found by Check.
+type Issue struct {
+	Check   string `json:"check"`
+	Problem string `json:"problem"`
+	Repair  Repair `json:"repair"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s (repair: %s)", i.Check, i.Problem, i.Repair)
+}
+
+// drives are the mappings wineboot creates inside dosdevices
+var drives = []struct{ name, target string }{{"c:", "../drive_c"}, {"z:", "/"}}
+
+var versionRegex = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// Checker validates a prefix.
+type Checker struct {
+	Prefix string
+	// InstallDir is the folder of the terminal inside Prefix
+	InstallDir string
+	// MonoVersion and GeckoVersion are expected to be installed, empty skips the check
+	MonoVersion, GeckoVersion string
+	// WineserverRunning disables the check for stale locks, as the lock may be in use
+	WineserverRunning bool
+	// Recipe provides the commands reinstalling components, Timeout bounds each of them
+	Recipe  Recipe
+	Timeout time.Duration
+}
+
+// MSIVersion returns the version inside an installer name like wine-mono-7.1.1-x86.msi.
+func MSIVersion(name string) string {
+	return versionRegex.FindString(name)
+}
+
+// Check returns the issues of the prefix. A prefix needing a rebuild is not checked any further.
+func (c Checker) Check() (issues []Issue) {
+	if info, err := os.Stat(filepath.Join(c.Prefix, "drive_c")); err != nil || !info.IsDir() {
+		return []Issue{{Check: "structure", Problem: "drive_c is missing", Repair: RepairRebuild}}
+	}
+
+	var hive Hive
+	for _, name := range []string{"system.reg", "user.reg", "userdef.reg"} {
+		loaded, err := LoadHive(filepath.Join(c.Prefix, name))
+		switch {
+		case os.IsNotExist(err):
+			issues = append(issues, Issue{Check: "registry", Problem: name + " is missing", Repair: RepairWineboot})
+		case err != nil:
+			return []Issue{{Check: "registry", Problem: err.Error(), Repair: RepairRebuild}}
+		case name == "system.reg":
+			hive = loaded
+		}
+	}
+
+	for _, drive := range drives {
+		link := filepath.Join(c.Prefix, "dosdevices", drive.name)
+		if actual, err := os.Readlink(link); err != nil || actual != drive.target {
+			issues = append(issues, Issue{Check: "drives", Problem: fmt.Sprintf("%s does not point to %s", drive.name, drive.target), Repair: RepairRelink})
+		}
+	}
+
+	if dir := c.ServerDir(); !c.WineserverRunning && len(dir) > 0 {
+		if _, err := os.Stat(dir); err == nil && !lockHeld(filepath.Join(dir, "lock")) {
+			issues = append(issues, Issue{Check: "wineserver", Problem: "stale lock in " + dir, Repair: RepairRemoveLock})
+		}
+	}
+
+	if hive.Keys != nil {
+		issues = append(issues, checkComponent(hive, "mono", "Wine Mono", c.MonoVersion, RepairReinstallMono)...)
+		issues = append(issues, checkComponent(hive, "gecko", "Wine Gecko", c.GeckoVersion, RepairReinstallGecko)...)
+	}
+
+	if _, err := os.Stat(filepath.Join(c.InstallDir, "terminal64.exe")); err != nil {
+		issues = append(issues, Issue{Check: "terminal", Problem: "terminal64.exe is not installed", Repair: RepairReinstallTarget})
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		return repairRank(issues[i].Repair) < repairRank(issues[j].Repair)
+	})
+
+	return
+}
+
+// lockHeld tells whether a process holds the lock a wineserver keeps on the lock file of its server
+// folder while it runs. If that cannot be told, the lock counts as held.
+func lockHeld(path string) bool {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK}
+	if err = syscall.FcntlFlock(file.Fd(), syscall.F_GETLK, &lock); err != nil {
+		return true
+	}
+
+	return lock.Type != syscall.F_UNLCK
+}
+
+// ServerDir returns the folder the wineserver of the prefix keeps its socket and lock in:
+// /tmp/.wine-<uid>/server-<dev>-<inode>, as named by Wine.
+func (c Checker) ServerDir() string {
+	info, err := os.Stat(c.Prefix)
+	if err != nil {
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("/tmp/.wine-%d/server-%x-%x", stat.Uid, uint64(stat.Dev), stat.Ino)
+}
+
+// InstalledVersion returns the version of the component registered under displayName, e.g.
+// "Wine Mono", in the uninstall keys of hive.
+func InstalledVersion(hive Hive, displayName string) (version string, ok bool) {
+	for key, values := range hive.Keys {
+		if !strings.Contains(key, uninstallKey) {
+			continue
+		}
+		if name, found := values["displayname"]; found && strings.HasPrefix(name.Data, displayName) {
+			return values["displayversion"].Data, true
+		}
+	}
+
+	return
+}
+
+func checkComponent(hive Hive, check, displayName, expected string, repair Repair) []Issue {
+	if len(expected) == 0 {
+		return nil
+	}
+	version, ok := InstalledVersion(hive, displayName)
+	switch {
+	case !ok:
+		return []Issue{{Check: check, Problem: displayName + " is not installed", Repair: repair}}
+	case version != expected:
+		return []Issue{{Check: check, Problem: fmt.Sprintf("%s %s is installed instead of %s", displayName, version, expected), Repair: repair}}
+	}
+
+	return nil
+}
+
+func repairRank(repair Repair) int {
+	for i, candidate := range repairOrder {
+		if candidate == repair {
+			return i
+		}
+	}
+
+	return len(repairOrder)
+}
+
+// Repairs returns the distinct repairs of issues in the order to run them.
+func Repairs(issues []Issue) (repairs []Repair) {
+	for _, repair := range repairOrder {
+		for _, issue := range issues {
+			if issue.Repair == repair {
+				repairs = append(repairs, repair)
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// Fix runs repair on the prefix of c, except for RepairRebuild which needs the caller to prepare or
+// restore the prefix. runner should start commands as the owner of the prefix.
+func (c Checker) Fix(runner ifc.CmdRunner, env *[]string, repair Repair) (err error) {
+	switch repair {
+	case RepairRemoveLock:
+		if lockHeld(filepath.Join(c.ServerDir(), "lock")) {
+			return fmt.Errorf("prefix error: the lock in %s is held by a running wineserver", c.ServerDir())
+		}
+		return os.RemoveAll(c.ServerDir())
+	case RepairRelink:
+		for _, drive := range drives {
+			link := filepath.Join(c.Prefix, "dosdevices", drive.name)
+			if err = os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+				return
+			}
+			os.Remove(link)
+			if err = os.Symlink(drive.target, link); err != nil {
+				return
+			}
+			if err = hlp.Adopt(link); err != nil {
+				return
+			}
+		}
+	case RepairWineboot, RepairReinstallMono, RepairReinstallGecko:
+		step, _ := c.Recipe.Step(map[Repair]string{RepairWineboot: "wineboot", RepairReinstallMono: "mono", RepairReinstallGecko: "gecko"}[repair])
+		err = runStep(runner, env, step.CmdLine, c.timeout())
+	case RepairReinstallTarget:
+		// the installer starts the terminal when done, which is stopped along with it
+		setup, errSetup := InstallTerminal(runner, env, c.InstallDir, c.timeout())
+		hlp.KillProcGroup(setup)
+		err = errSetup
+	default:
+		err = fmt.Errorf("prefix error: %s needs to be done by the caller", repair)
+	}
+
+	return
+}
+
+func (c Checker) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+
+	return DefaultStepTimeout
+}
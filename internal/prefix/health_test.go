@@ -0,0 +1,130 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// healthyPrefix returns a checker of a prefix without issues.
+func healthyPrefix(t *testing.T) Checker {
+	dir := t.TempDir()
+	install := filepath.Join(dir, "drive_c", "Program Files", "MetaTrader 5")
+	os.MkdirAll(install, 0755)
+	os.WriteFile(filepath.Join(install, "terminal64.exe"), []byte("MZ"), 0644)
+	os.WriteFile(filepath.Join(dir, "system.reg"), []byte(testHive+`
+[Software\\Microsoft\\Windows\\CurrentVersion\\Uninstall\\{4A7C36A8}] 1651234567
+"DisplayName"="Wine Gecko (64-bit)"
+"DisplayVersion"="2.47"
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "user.reg"), []byte(hiveHeader+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "userdef.reg"), []byte(hiveHeader+"\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "dosdevices"), 0755)
+	os.Symlink("../drive_c", filepath.Join(dir, "dosdevices", "c:"))
+	os.Symlink("/", filepath.Join(dir, "dosdevices", "z:"))
+
+	return Checker{Prefix: dir, InstallDir: install, MonoVersion: MSIVersion(MonoMSI), GeckoVersion: MSIVersion(GeckoMSI), WineserverRunning: true}
+}
+
+func TestCheckHealthyPrefix(t *testing.T) {
+	if issues := healthyPrefix(t).Check(); len(issues) != 0 {
+		t.Errorf("Expected '%v' to be empty", issues)
+	}
+}
+
+func TestCheckFindsIssues(t *testing.T) {
+	checker := healthyPrefix(t)
+	os.Remove(filepath.Join(checker.Prefix, "dosdevices", "c:"))
+	os.Remove(filepath.Join(checker.Prefix, "user.reg"))
+	os.Remove(filepath.Join(checker.InstallDir, "terminal64.exe"))
+	checker.MonoVersion = "8.0.0"
+
+	issues := checker.Check()
+	repairs := Repairs(issues)
+	expected := []Repair{RepairRelink, RepairWineboot, RepairReinstallMono, RepairReinstallTarget}
+	if len(repairs) != len(expected) {
+		t.Fatalf("Expected '%v' to be '%v' (%v)", repairs, expected, issues)
+	}
+	for i := range expected {
+		if repairs[i] != expected[i] {
+			t.Errorf("Expected '%v' to be '%v'", repairs[i], expected[i])
+		}
+	}
+
+	if err := checker.Fix(nil, nil, RepairRelink); err != nil {
+		t.Fatal(err)
+	}
+	if target, _ := os.Readlink(filepath.Join(checker.Prefix, "dosdevices", "c:")); target != "../drive_c" {
+		t.Errorf("Expected '%v' to be '%v'", target, "../drive_c")
+	}
+}
+
+func TestCheckCorruptHiveNeedsRebuild(t *testing.T) {
+	checker := healthyPrefix(t)
+	os.WriteFile(filepath.Join(checker.Prefix, "system.reg"), []byte(hiveHeader+"\n[Software\n"), 0644)
+
+	issues := checker.Check()
+	if len(issues) != 1 || issues[0].Repair != RepairRebuild {
+		t.Errorf("Expected '%v' to be a single rebuild", issues)
+	}
+}
+
+func TestCheckStaleLock(t *testing.T) {
+	checker := healthyPrefix(t)
+	checker.WineserverRunning = false
+	lock := checker.ServerDir()
+	if err := os.MkdirAll(lock, 0700); err != nil {
+		t.Skip("cannot create " + lock)
+	}
+	defer os.Remove(filepath.Dir(lock))
+	defer os.RemoveAll(lock)
+
+	issues := checker.Check()
+	if len(issues) != 1 || issues[0].Repair != RepairRemoveLock {
+		t.Fatalf("Expected '%v' to be a single stale lock", issues)
+	}
+	checker.Fix(nil, nil, RepairRemoveLock)
+	if _, err := os.Stat(lock); err == nil {
+		t.Errorf("Expected '%v' to be removed", lock)
+	}
+}
+
+// fOFDSetlk takes an open file description lock, which conflicts with the locks of the same process
+const fOFDSetlk = 37
+
+func TestCheckHeldLockIsNotStale(t *testing.T) {
+	checker := healthyPrefix(t)
+	checker.WineserverRunning = false
+	dir := checker.ServerDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Skip("cannot create " + dir)
+	}
+	defer os.Remove(filepath.Dir(dir))
+	defer os.RemoveAll(dir)
+	file, err := os.Create(filepath.Join(dir, "lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK}
+	if err = syscall.FcntlFlock(file.Fd(), fOFDSetlk, &lock); err != nil {
+		t.Skip("cannot lock " + file.Name())
+	}
+
+	if issues := checker.Check(); len(issues) != 0 {
+		t.Errorf("Expected '%v' to be empty", issues)
+	}
+	if err = checker.Fix(nil, nil, RepairRemoveLock); err == nil {
+		t.Errorf("Expected an error for the held lock")
+	}
+	if _, err = os.Stat(dir); err != nil {
+		t.Errorf("Expected '%v' to be kept", dir)
+	}
+}
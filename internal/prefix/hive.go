@@ -0,0 +1,201 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const hiveHeader = "WINE REGISTRY Version 2"
+
+// Value is a registry value as stored in a Wine hive. Data of strings is unescaped, of dwords it is
+// the 8 hex digits and of binary types the comma separated hex bytes.
+type Value struct {
+	Type string
+	Data string
+}
+
+// Hive is a parsed system.reg, user.reg or userdef.reg. Key paths and value names are lower case,
+// as the registry is case-insensitive; the default value of a key has the empty name.
+type Hive struct {
+	Arch string
+	Keys map[string]map[string]Value
+}
+
+// LoadHive parses the hive at path.
+func LoadHive(path string) (hive Hive, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if hive, err = ParseHive(file); err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+	}
+
+	return
+}
+
+// ParseHive reads the text format Wine keeps its registry in.
+func ParseHive(r io.Reader) (hive Hive, err error) {
+	hive.Keys = map[string]map[string]Value{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() || strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "\ufeff") != hiveHeader {
+		return hive, fmt.Errorf("registry error: missing header %q", hiveHeader)
+	}
+
+	var current map[string]Value
+	number := 1
+	for scanner.Scan() {
+		number++
+		line := scanner.Text()
+		// binary values continue on the next lines
+		for strings.HasSuffix(line, "\\") && scanner.Scan() {
+			number++
+			line = strings.TrimSuffix(line, "\\") + strings.TrimSpace(scanner.Text())
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case len(trimmed) == 0 || strings.HasPrefix(trimmed, ";"):
+		case strings.HasPrefix(trimmed, "#arch="):
+			hive.Arch = strings.TrimPrefix(trimmed, "#arch=")
+		case strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "["):
+			end := strings.LastIndex(trimmed, "]")
+			if end < 0 {
+				return hive, fmt.Errorf("registry error: line %d: unterminated key", number)
+			}
+			key := strings.ToLower(strings.ReplaceAll(trimmed[1:end], `\\`, `\`))
+			if hive.Keys[key] == nil {
+				hive.Keys[key] = map[string]Value{}
+			}
+			current = hive.Keys[key]
+		case current == nil:
+			return hive, fmt.Errorf("registry error: line %d: value outside of a key", number)
+		default:
+			name, value, errValue := parseValueLine(trimmed)
+			if errValue != nil {
+				return hive, fmt.Errorf("registry error: line %d: %w", number, errValue)
+			}
+			current[strings.ToLower(name)] = value
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return hive, fmt.Errorf("registry error: %w", err)
+	}
+
+	return
+}
+
+// Value returns the value name of key; an empty name selects the default value.
+func (h Hive) Value(key, name string) (value Value, ok bool) {
+	values, ok := h.Keys[strings.ToLower(key)]
+	if !ok {
+		return
+	}
+	value, ok = values[strings.ToLower(name)]
+
+	return
+}
+
+func parseValueLine(line string) (name string, value Value, err error) {
+	rest := line
+	if strings.HasPrefix(rest, "@=") {
+		rest = rest[1:]
+	} else {
+		if name, rest, err = unquote(rest); err != nil {
+			return
+		}
+	}
+	if !strings.HasPrefix(rest, "=") {
+		return name, value, fmt.Errorf("missing '=' after value name")
+	}
+	value, err = parseData(rest[1:])
+
+	return
+}
+
+func parseData(data string) (value Value, err error) {
+	switch {
+	case strings.HasPrefix(data, `"`):
+		value.Type = "sz"
+		value.Data, _, err = unquote(data)
+	case strings.HasPrefix(data, `str(2):"`):
+		value.Type = "expand_sz"
+		value.Data, _, err = unquote(strings.TrimPrefix(data, "str(2):"))
+	case strings.HasPrefix(data, `str(7):"`):
+		value.Type = "multi_sz"
+		value.Data, _, err = unquote(strings.TrimPrefix(data, "str(7):"))
+	case strings.HasPrefix(data, "dword:"):
+		value.Type, value.Data = "dword", strings.ToLower(strings.TrimPrefix(data, "dword:"))
+		if _, errParse := strconv.ParseUint(value.Data, 16, 32); errParse != nil || len(value.Data) != 8 {
+			err = fmt.Errorf("invalid dword %q", value.Data)
+		}
+	case strings.HasPrefix(data, "hex"):
+		kind, bytes, found := strings.Cut(data, ":")
+		if !found {
+			return value, fmt.Errorf("invalid binary value")
+		}
+		value.Type, value.Data = kind, strings.ToLower(strings.ReplaceAll(bytes, " ", ""))
+	default:
+		err = fmt.Errorf("unknown value type in %q", data)
+	}
+
+	return
+}
+
+// unquote reads a quoted, escaped string from the start of s and returns the rest behind it.
+func unquote(s string) (text, rest string, err error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", s, fmt.Errorf("expected quoted string")
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", "", fmt.Errorf("unterminated escape")
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '0':
+				b.WriteByte(0)
+			case 'x':
+				end := i + 1
+				for end < len(s) && end < i+5 && strings.IndexByte("0123456789abcdefABCDEF", s[end]) >= 0 {
+					end++
+				}
+				code, errCode := strconv.ParseUint(s[i+1:end], 16, 32)
+				if errCode != nil {
+					return "", "", fmt.Errorf("invalid escape")
+				}
+				b.WriteRune(rune(code))
+				i = end - 1
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"strings"
+	"testing"
+)
+
+const testHive = `WINE REGISTRY Version 2
+;; All keys relative to \\Machine
+
+#arch=win64
+
+[Software\\Microsoft\\Windows\\CurrentVersion\\Uninstall\\{DE624609-C6B5-486A-9274-EF0B854F6BC5}] 1651234567
+#time=1d85f2a3b4c5d6e
+"DisplayName"="Wine Mono Runtime"
+"DisplayVersion"="7.1.1"
+"EstimatedSize"=dword:0001d4c0
+
+[Control Panel\\Desktop] 1651234567
+@="default"
+"LogPixels"=dword:00000060
+"Wallpaper"=str(2):"%SystemRoot%\\web.bmp"
+"Quoted"="say \"hi\"\x263a"
+"Blob"=hex:01,02,03,\
+  04,05
+`
+
+func TestParseHive(t *testing.T) {
+	hive, err := ParseHive(strings.NewReader(testHive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hive.Arch != "win64" {
+		t.Errorf("Expected '%v' to be '%v'", hive.Arch, "win64")
+	}
+	cases := []struct {
+		key, name string
+		expected  Value
+	}{
+		{`Control Panel\Desktop`, "", Value{"sz", "default"}},
+		{`control panel\desktop`, "logpixels", Value{"dword", "00000060"}},
+		{`Control Panel\Desktop`, "Wallpaper", Value{"expand_sz", `%SystemRoot%\web.bmp`}},
+		{`Control Panel\Desktop`, "Quoted", Value{"sz", `say "hi"☺`}},
+		{`Control Panel\Desktop`, "Blob", Value{"hex", "01,02,03,04,05"}},
+	}
+	for _, c := range cases {
+		if value, ok := hive.Value(c.key, c.name); !ok || value != c.expected {
+			t.Errorf("Expected '%v' to be '%v'", value, c.expected)
+		}
+	}
+	if version, ok := InstalledVersion(hive, "Wine Mono"); !ok || version != "7.1.1" {
+		t.Errorf("Expected '%v' to be '%v'", version, "7.1.1")
+	}
+}
+
+func TestParseHiveRejectsCorruption(t *testing.T) {
+	for _, corrupt := range []string{
+		"",
+		"REGEDIT4\n",
+		"WINE REGISTRY Version 2\n\"Orphan\"=\"value\"\n",
+		"WINE REGISTRY Version 2\n[Key\n",
+		"WINE REGISTRY Version 2\n[Key] 1\n\"Open=\"value\n",
+		"WINE REGISTRY Version 2\n[Key] 1\n\"Dword\"=dword:xyz\n",
+	} {
+		if _, err := ParseHive(strings.NewReader(corrupt)); err == nil {
+			t.Errorf("Expected an error for '%v'", corrupt)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+const (
+	ArchWin64 = "win64"
+	ArchWin32 = "win32"
+
+	DefaultWindowsVersion = "win10"
+	// DefaultStepTimeout bounds a step of the recipe; .NET verbs take several minutes
+	DefaultStepTimeout = 20 * time.Minute
+
+	// recipeStateName records the steps done inside the prefix, so snapshots carry it along
+	recipeStateName = ".avly-recipe.json"
+	// installPollInterval is how often the terminal setup is checked for completion
+	installPollInterval = 2 * time.Second
+)
+
+// DefaultWinetricks are the verbs run unless the recipe lists others.
+var DefaultWinetricks = []string{"corefonts"}
+
+var (
+	verbRegex           = regexp.MustCompile(`^[a-z0-9_.=-]+$`)
+	windowsVersionRegex = regexp.MustCompile(`^(win(11|10|81|8|7|2k8|2k3|2k|xp|98|95)|vista)$`)
+)
+
+// Recipe describes how a prefix is built. Steps already done are skipped, so verbs added later only
+// run those.
+type Recipe struct {
+	// Arch is win64 (default) or win32; it cannot change for an existing prefix
+	Arch string `json:"arch,omitempty"`
+	// WindowsVersion is the version reported to programs, as known by winecfg (default win10)
+	WindowsVersion string `json:"windowsVersion,omitempty"`
+	// MonoMSI and GeckoMSI name the installers in THIRD_PARTY
+	MonoMSI  string `json:"monoMsi,omitempty"`
+	GeckoMSI string `json:"geckoMsi,omitempty"`
+	// Winetricks are verbs like corefonts, vcrun2019 or dotnet48, run in order
+	Winetricks []string `json:"winetricks,omitempty"`
+}
+
+// Step is a part of a recipe. A step is done once it ran with the same Spec.
+type Step struct {
+	Name    string
+	Spec    string
+	CmdLine string
+}
+
+// StepRecord is a step done, as kept inside the prefix.
+type StepRecord struct {
+	Spec string    `json:"spec"`
+	Done time.Time `json:"done"`
+}
+
+// RecipeState maps step names to the steps done.
+type RecipeState map[string]StepRecord
+
+// Validate checks the recipe.
+func (r Recipe) Validate() error {
+	if r.Arch != "" && r.Arch != ArchWin64 && r.Arch != ArchWin32 {
+		return fmt.Errorf("recipe error: arch must be %s or %s", ArchWin64, ArchWin32)
+	}
+	if r.WindowsVersion != "" && !windowsVersionRegex.MatchString(r.WindowsVersion) {
+		return fmt.Errorf("recipe error: unknown Windows version %s", r.WindowsVersion)
+	}
+	for _, msi := range []string{r.MonoMSI, r.GeckoMSI} {
+		if msi != "" && (strings.ContainsRune(msi, '/') || !strings.HasSuffix(msi, ".msi")) {
+			return fmt.Errorf("recipe error: %s needs to be the name of an .msi file in THIRD_PARTY", msi)
+		}
+	}
+	for _, verb := range r.Winetricks {
+		if !verbRegex.MatchString(verb) {
+			return fmt.Errorf("recipe error: invalid winetricks verb %q", verb)
+		}
+	}
+
+	return nil
+}
+
+// WithDefaults fills unset fields.
+func (r Recipe) WithDefaults() Recipe {
+	if len(r.Arch) == 0 {
+		r.Arch = ArchWin64
+	}
+	if len(r.WindowsVersion) == 0 {
+		r.WindowsVersion = DefaultWindowsVersion
+	}
+	if len(r.MonoMSI) == 0 {
+		r.MonoMSI = MonoMSI
+	}
+	if len(r.GeckoMSI) == 0 {
+		r.GeckoMSI = GeckoMSI
+	}
+	if r.Winetricks == nil {
+		r.Winetricks = DefaultWinetricks
+	}
+
+	return r
+}
+
+// Steps returns the steps of the recipe in order. Each command waits for the wineserver to exit,
+// so a step is complete once its command returns.
+func (r Recipe) Steps() (steps []Step) {
+	r = r.WithDefaults()
+	// Mono and Gecko are installed from THIRD_PARTY, so wineboot must not offer to download them
+	steps = append(steps,
+		Step{"wineboot", r.Arch, "WINEARCH=" + r.Arch + " WINEDLLOVERRIDES='mscoree,mshtml=' wine wineboot -u"},
+		Step{"windows-version", r.WindowsVersion, "wine winecfg -v " + r.WindowsVersion},
+		Step{"mono", r.MonoMSI, "wine msiexec /i \"$THIRD_PARTY\"/" + hlp.ShellQuote(r.MonoMSI) + " /qn"},
+		Step{"gecko", r.GeckoMSI, "wine msiexec /i \"$THIRD_PARTY\"/" + hlp.ShellQuote(r.GeckoMSI) + " /qn"},
+	)
+	for _, verb := range r.Winetricks {
+		steps = append(steps, Step{"winetricks:" + verb, verb, "winetricks -f --unattended " + verb})
+	}
+	for i := range steps {
+		steps[i].CmdLine += " >> $AVL_PROCESS_LOGS/wine.log 2>&1 && wineserver -w"
+	}
+
+	return
+}
+
+// Step returns the step called name.
+func (r Recipe) Step(name string) (step Step, ok bool) {
+	for _, candidate := range r.Steps() {
+		if candidate.Name == name {
+			return candidate, true
+		}
+	}
+
+	return
+}
+
+// LoadRecipeState reads the steps done in prefixDir; a prefix without record has none done.
+func LoadRecipeState(prefixDir string) (state RecipeState, err error) {
+	state = RecipeState{}
+	raw, err := os.ReadFile(filepath.Join(prefixDir, recipeStateName))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(raw, &state); err != nil {
+		err = fmt.Errorf("recipe error: invalid %s: %w", recipeStateName, err)
+	}
+
+	return
+}
+
+// Save records state in prefixDir.
+func (s RecipeState) Save(prefixDir string) (err error) {
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return
+	}
+	path := filepath.Join(prefixDir, recipeStateName)
+	if err = hlp.WriteFresh(path, raw, 0644); err != nil {
+		return
+	}
+
+	return hlp.Adopt(path)
+}
+
+// Pending returns the steps of r not done in prefixDir. A prefix built for another arch is an error,
+// as it needs to be rebuilt.
+func (r Recipe) Pending(prefixDir string) (pending []Step, err error) {
+	state, err := LoadRecipeState(prefixDir)
+	if err != nil {
+		return
+	}
+	arch := r.WithDefaults().Arch
+	if hive, errHive := LoadHive(filepath.Join(prefixDir, "system.reg")); errHive == nil && len(hive.Arch) > 0 && hive.Arch != arch {
+		return nil, fmt.Errorf("recipe error: prefix was built for %s, the recipe needs %s: rebuild it", hive.Arch, arch)
+	}
+	for _, step := range r.Steps() {
+		if record, ok := state[step.Name]; !ok || record.Spec != step.Spec {
+			pending = append(pending, step)
+		}
+	}
+
+	return
+}
+
+// ApplyRecipe runs the pending steps of r on the prefix of env and records each one done, so an
+// interrupted run resumes with the failed step. runner should start commands as the owner of the
+// prefix.
+func ApplyRecipe(runner ifc.CmdRunner, env *[]string, r Recipe, timeout time.Duration, logf func(string, ...interface{})) (ran []string, err error) {
+	prefixDir := hlp.EnvValue(env, "WINEPREFIX")
+	pending, err := r.Pending(prefixDir)
+	if err != nil {
+		return
+	}
+	state, err := LoadRecipeState(prefixDir)
+	if err != nil {
+		return
+	}
+	for _, step := range pending {
+		logf("prefix recipe: %s (%s)...", step.Name, step.Spec)
+		if err = runStep(runner, env, step.CmdLine, timeout); err != nil {
+			return ran, fmt.Errorf("recipe error: %s: %w", step.Name, err)
+		}
+		state[step.Name] = StepRecord{Spec: step.Spec, Done: time.Now().UTC()}
+		if err = state.Save(prefixDir); err != nil {
+			return
+		}
+		ran = append(ran, step.Name)
+	}
+
+	return
+}
+
+// runStep runs cmdLine, giving up after timeout.
+func runStep(runner ifc.CmdRunner, env *[]string, cmdLine string, timeout time.Duration) error {
+	_, _, err := runner.RunCmdSync(fmt.Sprintf("timeout %d sh -c %s", int(timeout.Seconds()), hlp.ShellQuote(cmdLine)), env)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 124 {
+		return fmt.Errorf("did not complete within %s", timeout)
+	}
+
+	return err
+}
+
+// InstallTerminal runs the terminal setup and waits until terminal64.exe is in installDir and the
+// setup exited. The setup starts the terminal when done; it is left to the caller, as is setup.
+func InstallTerminal(runner ifc.CmdRunner, env *[]string, installDir string, timeout time.Duration) (setup *exec.Cmd, err error) {
+	_, setup, err = runner.RunCmdAsync("wine $THIRD_PARTY/"+terminalSetup+" /auto >> $AVL_PROCESS_LOGS/wine.log 2>&1", env)
+	if err != nil {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(installPollInterval)
+		if _, errStat := os.Stat(filepath.Join(installDir, "terminal64.exe")); errStat != nil {
+			continue
+		}
+		if running, _, _ := runner.RunCmdSync("pidof "+terminalSetup, env); len(running) == 0 {
+			return
+		}
+	}
+
+	return setup, fmt.Errorf("prefix error: %s did not complete within %s", terminalSetup, timeout)
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+func TestRecipeSteps(t *testing.T) {
+	steps := Recipe{WindowsVersion: "win7", Winetricks: []string{"corefonts", "vcrun2019"}}.Steps()
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Name)
+		if !strings.HasSuffix(step.CmdLine, "&& wineserver -w") {
+			t.Errorf("Expected '%v' to wait for the wineserver", step.CmdLine)
+		}
+	}
+	expected := "wineboot,windows-version,mono,gecko,winetricks:corefonts,winetricks:vcrun2019"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Expected '%v' to be '%v'", strings.Join(names, ","), expected)
+	}
+	if !strings.HasPrefix(steps[0].CmdLine, "WINEARCH=win64 ") {
+		t.Errorf("Expected '%v' to create a win64 prefix", steps[0].CmdLine)
+	}
+	if steps[1].Spec != "win7" || steps[2].Spec != MonoMSI {
+		t.Errorf("Expected '%v' and '%v' to be '%v' and '%v'", steps[1].Spec, steps[2].Spec, "win7", MonoMSI)
+	}
+
+	if defaults := (Recipe{}).Steps(); defaults[len(defaults)-1].Name != "winetricks:corefonts" {
+		t.Errorf("Expected '%v' to be '%v'", defaults[len(defaults)-1].Name, "winetricks:corefonts")
+	}
+	if none := (Recipe{Winetricks: []string{}}).Steps(); len(none) != 4 {
+		t.Errorf("Expected '%v' to be '%v'", len(none), 4)
+	}
+}
+
+func TestRecipeValidate(t *testing.T) {
+	if err := (Recipe{Arch: ArchWin32, WindowsVersion: "win81", MonoMSI: "wine-mono-8.0.0-x86.msi", Winetricks: []string{"dotnet48", "fontsmooth=rgb"}}).Validate(); err != nil {
+		t.Errorf("Expected '%v' to be '%v'", err, nil)
+	}
+	for _, invalid := range []Recipe{
+		{Arch: "arm64"},
+		{WindowsVersion: "win12"},
+		{GeckoMSI: "../gecko.msi"},
+		{MonoMSI: "mono.exe"},
+		{Winetricks: []string{"corefonts; rm -rf /"}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected an error for '%+v'", invalid)
+		}
+	}
+}
+
+func TestApplyRecipeRunsPendingSteps(t *testing.T) {
+	dir := t.TempDir()
+	env := []string{"WINEPREFIX=" + dir}
+	logf := func(string, ...interface{}) {}
+	recipe := Recipe{Winetricks: []string{"corefonts"}}
+
+	runner := &ifc.SpySafeCmdRunner{}
+	ran, err := ApplyRecipe(runner, &env, recipe, time.Minute, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 5 || runner.Calls != 5 {
+		t.Errorf("Expected '%v' and '%v' to be '%v'", len(ran), runner.Calls, 5)
+	}
+	if !strings.HasPrefix(runner.LastCommand, "timeout 60 sh -c ") {
+		t.Errorf("Expected '%v' to be bounded by timeout", runner.LastCommand)
+	}
+
+	runner = &ifc.SpySafeCmdRunner{}
+	if ran, _ = ApplyRecipe(runner, &env, recipe, time.Minute, logf); len(ran) != 0 || runner.Calls != 0 {
+		t.Errorf("Expected '%v' to be empty", ran)
+	}
+
+	recipe.Winetricks = append(recipe.Winetricks, "vcrun2019")
+	recipe.WindowsVersion = "win7"
+	runner = &ifc.SpySafeCmdRunner{}
+	ran, _ = ApplyRecipe(runner, &env, recipe, time.Minute, logf)
+	if strings.Join(ran, ",") != "windows-version,winetricks:vcrun2019" {
+		t.Errorf("Expected '%v' to be '%v'", ran, "windows-version,winetricks:vcrun2019")
+	}
+
+	state, err := LoadRecipeState(dir)
+	if err != nil || state["winetricks:vcrun2019"].Spec != "vcrun2019" {
+		t.Errorf("Expected '%v' to record vcrun2019 (%v)", state, err)
+	}
+}
+
+func TestRecipePendingRefusesOtherArch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "system.reg"), []byte(testHive), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Recipe{Arch: ArchWin32}).Pending(dir); err == nil {
+		t.Errorf("Expected an error for a win64 prefix and a win32 recipe")
+	}
+	if pending, err := (Recipe{}).Pending(dir); err != nil || len(pending) != 5 {
+		t.Errorf("Expected '%v' to be '%v' (%v)", len(pending), 5, err)
+	}
+}
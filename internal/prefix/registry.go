@@ -0,0 +1,422 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+// SourceConfig marks desired values of the config file, as opposed to those of .reg files
+const SourceConfig = "config"
+
+// roots maps registry roots to the hive keeping them and the path of the root inside the hive
+var roots = map[string]struct{ hive, path string }{
+	"HKEY_CURRENT_USER":  {"user.reg", ""},
+	"HKCU":               {"user.reg", ""},
+	"HKEY_LOCAL_MACHINE": {"system.reg", ""},
+	"HKLM":               {"system.reg", ""},
+	"HKEY_CLASSES_ROOT":  {"system.reg", `software\classes`},
+	"HKCR":               {"system.reg", `software\classes`},
+}
+
+// regTypes maps the types of 'wine reg' to those of hives
+var regTypes = map[string]string{
+	"REG_SZ":        "sz",
+	"REG_EXPAND_SZ": "expand_sz",
+	"REG_DWORD":     "dword",
+	"REG_BINARY":    "hex",
+}
+
+// RegistrySetting is a value of the config file avly keeps in the registry of the prefix.
+type RegistrySetting struct {
+	// Key starts with its root, e.g. HKCU\Software\Wine\WineDbg
+	Key string `json:"key"`
+	// Name is empty for the default value of Key
+	Name string `json:"name,omitempty"`
+	// Type is REG_SZ (default), REG_EXPAND_SZ, REG_DWORD or REG_BINARY (hex bytes like "01,ff")
+	Type  string  `json:"type,omitempty"`
+	Value RegData `json:"value"`
+	// Delete removes the value instead
+	Delete bool `json:"delete,omitempty"`
+}
+
+// RegData is the data of a setting, written as string or number in the config file.
+type RegData string
+
+func (d *RegData) UnmarshalJSON(raw []byte) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		*d = RegData(text)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err != nil {
+		return fmt.Errorf("registry error: value must be a string or number")
+	}
+	*d = RegData(number.String())
+
+	return nil
+}
+
+// Desired is a value as it should be found in a hive.
+type Desired struct {
+	// Hive is the file name of the hive, Key the lower case path inside it
+	Hive, Key string
+	// Display is the key as configured, for reports
+	Display string
+	Name    string
+	Value   Value
+	Delete  bool
+	// Source is SourceConfig or the name of the .reg file
+	Source string
+}
+
+func (d Desired) String() string {
+	name := d.Name
+	if len(name) == 0 {
+		name = "(default)"
+	}
+
+	return d.Display + " " + name
+}
+
+// Drift is a desired value the hive differs from. Actual is nil if the value is missing.
+type Drift struct {
+	Desired Desired
+	Actual  *Value
+}
+
+func (d Drift) String() string {
+	actual := "missing"
+	if d.Actual != nil {
+		actual = d.Actual.String()
+	}
+	want := d.Desired.Value.String()
+	if d.Desired.Delete {
+		want = "deleted"
+	}
+
+	return fmt.Sprintf("%s: %s, want %s (%s)", d.Desired.String(), actual, want, d.Desired.Source)
+}
+
+func (v Value) String() string {
+	return v.Type + ":" + v.Data
+}
+
+// Desired resolves the setting into the value expected in a hive.
+func (s RegistrySetting) Desired() (desired Desired, err error) {
+	if desired, err = resolveKey(s.Key); err != nil {
+		return
+	}
+	desired.Name, desired.Delete, desired.Source = s.Name, s.Delete, SourceConfig
+	if s.Delete {
+		return
+	}
+	kind := strings.ToUpper(s.Type)
+	if len(kind) == 0 {
+		kind = "REG_SZ"
+	}
+	hiveType, ok := regTypes[kind]
+	if !ok {
+		return desired, fmt.Errorf("registry error: %s: unsupported type %s", s.Key, s.Type)
+	}
+	data := string(s.Value)
+	switch hiveType {
+	case "dword":
+		number, errParse := strconv.ParseUint(data, 0, 32)
+		if errParse != nil {
+			return desired, fmt.Errorf("registry error: %s: invalid dword %s", s.Key, data)
+		}
+		data = fmt.Sprintf("%08x", number)
+	case "hex":
+		data = strings.ToLower(strings.ReplaceAll(data, " ", ""))
+		if _, errHex := hex.DecodeString(strings.ReplaceAll(data, ",", "")); errHex != nil {
+			return desired, fmt.Errorf("registry error: %s: invalid binary %s", s.Key, data)
+		}
+	}
+	desired.Value = Value{Type: hiveType, Data: data}
+
+	return
+}
+
+// CmdLine returns the 'wine reg' command applying the setting.
+func (s RegistrySetting) CmdLine() string {
+	name := "/ve"
+	if len(s.Name) > 0 {
+		name = "/v " + hlp.ShellQuote(s.Name)
+	}
+	if s.Delete {
+		return fmt.Sprintf("wine reg delete %s %s /f", hlp.ShellQuote(s.Key), name)
+	}
+	kind := strings.ToUpper(s.Type)
+	if len(kind) == 0 {
+		kind = "REG_SZ"
+	}
+	data := string(s.Value)
+	if kind == "REG_BINARY" {
+		data = strings.ReplaceAll(strings.ReplaceAll(data, ",", ""), " ", "")
+	}
+
+	return fmt.Sprintf("wine reg add %s %s /t %s /d %s /f", hlp.ShellQuote(s.Key), name, kind, hlp.ShellQuote(data))
+}
+
+// Settings configure the Wine prefix.
+type Settings struct {
+	// Recipe describes how the prefix is built
+	Recipe Recipe `json:"recipe"`
+	// Registry lists values kept in the registry, next to those of RegFiles
+	Registry []RegistrySetting `json:"registry,omitempty"`
+	// RegFiles are relative to THIRD_PARTY
+	RegFiles []string `json:"regFiles,omitempty"`
+}
+
+// Validate checks the settings which do not depend on the environment.
+func (s Settings) Validate() error {
+	if err := s.Recipe.Validate(); err != nil {
+		return err
+	}
+	for _, setting := range s.Registry {
+		if _, err := setting.Desired(); err != nil {
+			return err
+		}
+	}
+	for _, file := range s.RegFiles {
+		if filepath.IsAbs(file) || strings.HasPrefix(filepath.Clean(file), "..") {
+			return fmt.Errorf("registry error: %s needs to be relative to THIRD_PARTY", file)
+		}
+	}
+
+	return nil
+}
+
+// DesiredState returns all values of s, reading the .reg files of env.
+func (s Settings) DesiredState(env *[]string) (desired []Desired, err error) {
+	for _, setting := range s.Registry {
+		resolved, errResolve := setting.Desired()
+		if errResolve != nil {
+			return nil, errResolve
+		}
+		desired = append(desired, resolved)
+	}
+	for _, name := range s.RegFiles {
+		fromFile, errFile := LoadRegFile(filepath.Join(hlp.EnvValue(env, "THIRD_PARTY"), name))
+		if errFile != nil {
+			return nil, errFile
+		}
+		for i := range fromFile {
+			fromFile[i].Source = name
+		}
+		desired = append(desired, fromFile...)
+	}
+
+	return
+}
+
+// DetectDrift compares desired with the hives of the prefix.
+func DetectDrift(prefixDir string, desired []Desired) (drifts []Drift, err error) {
+	hives := map[string]Hive{}
+	for _, want := range desired {
+		hive, ok := hives[want.Hive]
+		if !ok {
+			if hive, err = LoadHive(filepath.Join(prefixDir, want.Hive)); err != nil {
+				return
+			}
+			hives[want.Hive] = hive
+		}
+		actual, found := hive.Value(want.Key, want.Name)
+		switch {
+		case want.Delete && found:
+			drifts = append(drifts, Drift{Desired: want, Actual: &actual})
+		case !want.Delete && !found:
+			drifts = append(drifts, Drift{Desired: want})
+		case !want.Delete && actual != want.Value:
+			drifts = append(drifts, Drift{Desired: want, Actual: &actual})
+		}
+	}
+
+	return
+}
+
+// ApplyRegistry writes the drifted values of s into the prefix of env: settings of the config file
+// with 'wine reg', .reg files with 'wine regedit'. Values already in place are left alone. runner
+// should start commands as the owner of the prefix.
+func ApplyRegistry(runner ifc.CmdRunner, env *[]string, s Settings) (applied []string, err error) {
+	desired, err := s.DesiredState(env)
+	if err != nil {
+		return
+	}
+	drifts, err := DetectDrift(hlp.EnvValue(env, "WINEPREFIX"), desired)
+	if err != nil {
+		return
+	}
+	drifted := map[string]bool{}
+	for _, drift := range drifts {
+		drifted[drift.Desired.Source+"|"+drift.Desired.String()] = true
+		if drift.Desired.Source != SourceConfig {
+			drifted[drift.Desired.Source] = true
+		}
+	}
+
+	for _, setting := range s.Registry {
+		resolved, _ := setting.Desired()
+		if !drifted[SourceConfig+"|"+resolved.String()] {
+			continue
+		}
+		if _, _, err = runner.RunCmdSync(setting.CmdLine()+" >> $AVL_PROCESS_LOGS/wine.log 2>&1", env); err != nil {
+			return
+		}
+		applied = append(applied, resolved.String())
+	}
+	for _, name := range s.RegFiles {
+		if !drifted[name] {
+			continue
+		}
+		path := hlp.WinePath(filepath.Join(hlp.EnvValue(env, "THIRD_PARTY"), name))
+		if _, _, err = runner.RunCmdSync("wine regedit /S "+hlp.ShellQuote(path)+" >> $AVL_PROCESS_LOGS/wine.log 2>&1", env); err != nil {
+			return
+		}
+		applied = append(applied, name)
+	}
+
+	return
+}
+
+// LoadRegFile parses a .reg file as written by regedit ("REGEDIT4" or version 5.00, UTF-16 or not).
+func LoadRegFile(path string) (desired []Desired, err error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if desired, err = ParseRegFile(bytes.NewReader(decodeRegText(raw))); err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+	}
+
+	return
+}
+
+// ParseRegFile reads the values and deletions of a .reg file. Deleted keys are not supported.
+func ParseRegFile(r io.Reader) (desired []Desired, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("registry error: empty .reg file")
+	}
+	header := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "\ufeff")
+	if header != "REGEDIT4" && header != "Windows Registry Editor Version 5.00" {
+		return nil, fmt.Errorf("registry error: unknown .reg header %q", header)
+	}
+
+	var current *Desired
+	number := 1
+	for scanner.Scan() {
+		number++
+		line := strings.TrimSpace(scanner.Text())
+		for strings.HasSuffix(line, "\\") && scanner.Scan() {
+			number++
+			line = strings.TrimSuffix(line, "\\") + strings.TrimSpace(scanner.Text())
+		}
+		switch {
+		case len(line) == 0 || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[-"):
+			return nil, fmt.Errorf("registry error: line %d: deleting keys is not supported", number)
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("registry error: line %d: unterminated key", number)
+			}
+			key, errKey := resolveKey(line[1 : len(line)-1])
+			if errKey != nil {
+				return nil, fmt.Errorf("line %d: %w", number, errKey)
+			}
+			current = &key
+		case current == nil:
+			return nil, fmt.Errorf("registry error: line %d: value outside of a key", number)
+		default:
+			want := *current
+			rest := line
+			if strings.HasPrefix(rest, "@=") {
+				rest = rest[1:]
+			} else if want.Name, rest, err = unquote(rest); err != nil {
+				return nil, fmt.Errorf("registry error: line %d: %w", number, err)
+			}
+			if !strings.HasPrefix(rest, "=") {
+				return nil, fmt.Errorf("registry error: line %d: missing '='", number)
+			}
+			if rest == "=-" {
+				want.Delete = true
+			} else if want.Value, err = parseRegData(rest[1:]); err != nil {
+				return nil, fmt.Errorf("registry error: line %d: %w", number, err)
+			}
+			desired = append(desired, want)
+		}
+	}
+
+	return desired, scanner.Err()
+}
+
+// parseRegData converts data of a .reg file into the representation of hives, which keep strings
+// as text where .reg files use UTF-16 hex bytes.
+func parseRegData(data string) (value Value, err error) {
+	for kind, hiveType := range map[string]string{"hex(2):": "expand_sz", "hex(7):": "multi_sz"} {
+		if strings.HasPrefix(data, kind) {
+			raw, errHex := hex.DecodeString(strings.ReplaceAll(strings.TrimPrefix(data, kind), ",", ""))
+			if errHex != nil || len(raw)%2 != 0 {
+				return value, fmt.Errorf("invalid %s value", hiveType)
+			}
+			units := make([]uint16, len(raw)/2)
+			for i := range units {
+				units[i] = uint16(raw[2*i]) | uint16(raw[2*i+1])<<8
+			}
+			text := string(utf16.Decode(units))
+			if hiveType == "expand_sz" {
+				text = strings.TrimSuffix(text, "\x00")
+			}
+			return Value{Type: hiveType, Data: text}, nil
+		}
+	}
+
+	return parseData(data)
+}
+
+func resolveKey(key string) (desired Desired, err error) {
+	root, path, _ := strings.Cut(key, `\`)
+	location, ok := roots[strings.ToUpper(root)]
+	if !ok {
+		return desired, fmt.Errorf("registry error: %s: unsupported root %s", key, root)
+	}
+	path = strings.ToLower(strings.Trim(path, `\`))
+	if len(location.path) > 0 {
+		path = strings.Trim(location.path+`\`+path, `\`)
+	}
+
+	return Desired{Hive: location.hive, Key: path, Display: key}, nil
+}
+
+// decodeRegText converts UTF-16LE files, as written by regedit of Windows, to UTF-8.
+func decodeRegText(raw []byte) []byte {
+	if len(raw) < 2 || raw[0] != 0xff || raw[1] != 0xfe {
+		return raw
+	}
+	units := make([]uint16, (len(raw)-2)/2)
+	for i := range units {
+		units[i] = uint16(raw[2+2*i]) | uint16(raw[3+2*i])<<8
+	}
+
+	return []byte(string(utf16.Decode(units)))
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testUserHive = `WINE REGISTRY Version 2
+;; All keys relative to \\User\\S-1-5-21-0-0-0-1000
+
+#arch=win64
+
+[Control Panel\\Desktop] 1651234567
+"LogPixels"=dword:00000060
+"FontSmoothing"="2"
+
+[Software\\Wine\\WineDbg] 1651234567
+"ShowCrashDialog"=dword:00000001
+`
+
+const testRegFile = "Windows Registry Editor Version 5.00\r\n" +
+	"\r\n" +
+	"; keep fonts crisp\r\n" +
+	"[HKEY_CURRENT_USER\\Control Panel\\Desktop]\r\n" +
+	"\"FontSmoothing\"=\"2\"\r\n" +
+	"\"Wallpaper\"=hex(2):25,00,53,00,\\\r\n" +
+	"  52,00,25,00,00,00\r\n" +
+	"\"Obsolete\"=-\r\n"
+
+func TestParseRegFile(t *testing.T) {
+	desired, err := ParseRegFile(strings.NewReader(testRegFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(desired) != 3 {
+		t.Fatalf("Expected '%v' to be '%v'", len(desired), 3)
+	}
+	expected := []Desired{
+		{Hive: "user.reg", Key: `control panel\desktop`, Name: "FontSmoothing", Value: Value{"sz", "2"}},
+		{Hive: "user.reg", Key: `control panel\desktop`, Name: "Wallpaper", Value: Value{"expand_sz", "%SR%"}},
+		{Hive: "user.reg", Key: `control panel\desktop`, Name: "Obsolete", Delete: true},
+	}
+	for i, want := range expected {
+		got := desired[i]
+		if got.Hive != want.Hive || got.Key != want.Key || got.Name != want.Name || got.Value != want.Value || got.Delete != want.Delete {
+			t.Errorf("Expected '%+v' to be '%+v'", got, want)
+		}
+	}
+
+	for _, invalid := range []string{"", "REGEDIT5\n", "REGEDIT4\n\"Orphan\"=\"x\"\n", "REGEDIT4\n[-HKEY_CURRENT_USER\\Software]\n", "REGEDIT4\n[HKEY_USERS\\x]\n"} {
+		if _, err := ParseRegFile(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected an error for '%v'", invalid)
+		}
+	}
+}
+
+func TestRegistrySettingDesired(t *testing.T) {
+	var settings Settings
+	raw := `{"registry": [
+		{"key": "HKCU\\Software\\Wine\\WineDbg", "name": "ShowCrashDialog", "type": "REG_DWORD", "value": 0},
+		{"key": "HKEY_LOCAL_MACHINE\\Software\\Avly", "value": "on"},
+		{"key": "HKCR\\.mq5", "type": "reg_binary", "value": "0A, ff"}
+	]}`
+	if err := json.Unmarshal([]byte(raw), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Desired{
+		{Hive: "user.reg", Key: `software\wine\winedbg`, Name: "ShowCrashDialog", Value: Value{"dword", "00000000"}},
+		{Hive: "system.reg", Key: `software\avly`, Value: Value{"sz", "on"}},
+		{Hive: "system.reg", Key: `software\classes\.mq5`, Value: Value{"hex", "0a,ff"}},
+	}
+	for i, want := range expected {
+		got, err := settings.Registry[i].Desired()
+		if err != nil || got.Hive != want.Hive || got.Key != want.Key || got.Name != want.Name || got.Value != want.Value {
+			t.Errorf("Expected '%+v' to be '%+v'", got, want)
+		}
+	}
+
+	if cmdLine := settings.Registry[0].CmdLine(); cmdLine != `wine reg add 'HKCU\Software\Wine\WineDbg' /v 'ShowCrashDialog' /t REG_DWORD /d '0' /f` {
+		t.Errorf("Expected '%v' to be a 'wine reg add' of ShowCrashDialog", cmdLine)
+	}
+	if cmdLine := settings.Registry[1].CmdLine(); !strings.Contains(cmdLine, " /ve ") {
+		t.Errorf("Expected '%v' to set the default value", cmdLine)
+	}
+
+	for _, invalid := range []RegistrySetting{
+		{Key: `HKU\x`, Value: "1"},
+		{Key: `HKCU\x`, Type: "REG_QWORD", Value: "1"},
+		{Key: `HKCU\x`, Type: "REG_DWORD", Value: "-1"},
+		{Key: `HKCU\x`, Type: "REG_BINARY", Value: "zz"},
+	} {
+		if _, err := invalid.Desired(); err == nil {
+			t.Errorf("Expected an error for '%+v'", invalid)
+		}
+	}
+	if err := (Settings{RegFiles: []string{"../etc/tweaks.reg"}}).Validate(); err == nil {
+		t.Errorf("Expected an error for a .reg file outside of THIRD_PARTY")
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	prefixDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(prefixDir, "user.reg"), []byte(testUserHive), 0644); err != nil {
+		t.Fatal(err)
+	}
+	desired := []Desired{
+		{Hive: "user.reg", Key: `control panel\desktop`, Name: "LogPixels", Value: Value{"dword", "00000060"}},
+		{Hive: "user.reg", Key: `control panel\desktop`, Name: "FontSmoothing", Delete: true},
+		{Hive: "user.reg", Key: `software\wine\winedbg`, Name: "ShowCrashDialog", Value: Value{"dword", "00000000"}},
+		{Hive: "user.reg", Key: `software\wine\winedbg`, Name: "Missing", Value: Value{"sz", "x"}},
+		{Hive: "user.reg", Key: `software\wine\winedbg`, Name: "Gone", Delete: true},
+	}
+	drifts, err := DetectDrift(prefixDir, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, drift := range drifts {
+		names = append(names, drift.Desired.Name)
+	}
+	if strings.Join(names, ",") != "FontSmoothing,ShowCrashDialog,Missing" {
+		t.Errorf("Expected '%v' to be '%v'", names, "FontSmoothing,ShowCrashDialog,Missing")
+	}
+	if drifts[2].Actual != nil {
+		t.Errorf("Expected '%v' to be '%v'", drifts[2].Actual, nil)
+	}
+
+	if _, err := DetectDrift(prefixDir, []Desired{{Hive: "system.reg", Key: "x"}}); err == nil {
+		t.Errorf("Expected an error for a missing hive")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+// killGrace is how long processes get to exit after a signal
+const killGrace = 2 * time.Second
+
+// Process is a process of the Wine session of a prefix.
+type Process struct {
+	Pid  int
+	Name string
+}
+
+func (p Process) String() string {
+	return fmt.Sprintf("%s (%d)", p.Name, p.Pid)
+}
+
+// SessionProcesses returns the Wine processes, the wineserver included, whose environment points
+// WINEPREFIX to prefixDir. procRoot is /proc outside of tests. Processes of other users are skipped
+// unless avly runs as root.
+func SessionProcesses(procRoot, prefixDir string) (procs []Process, err error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return
+	}
+	binding := []byte("WINEPREFIX=" + filepath.Clean(prefixDir))
+	self := os.Getpid()
+	for _, entry := range entries {
+		pid, errAtoi := strconv.Atoi(entry.Name())
+		if errAtoi != nil || pid == self {
+			continue
+		}
+		dir := filepath.Join(procRoot, entry.Name())
+		environ, errEnv := os.ReadFile(filepath.Join(dir, "environ"))
+		if errEnv != nil {
+			continue
+		}
+		bound := false
+		for _, variable := range bytes.Split(environ, []byte{0}) {
+			if bytes.Equal(bytes.TrimRight(variable, "/"), binding) {
+				bound = true
+				break
+			}
+		}
+		if name := wineProcessName(dir); bound && len(name) > 0 {
+			procs = append(procs, Process{Pid: pid, Name: name})
+		}
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].Pid < procs[j].Pid })
+
+	return
+}
+
+// wineProcessName returns the name of the process in dir if it belongs to Wine: the wineserver and
+// loaders ("wine...") or Windows executables, empty otherwise.
+func wineProcessName(dir string) string {
+	comm, _ := os.ReadFile(filepath.Join(dir, "comm"))
+	if name := strings.TrimSpace(string(comm)); strings.HasPrefix(name, "wine") {
+		return name
+	}
+	cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+	argv0 := string(bytes.SplitN(cmdline, []byte{0}, 2)[0])
+	if !strings.HasSuffix(strings.ToLower(argv0), ".exe") {
+		return ""
+	}
+
+	return argv0[strings.LastIndexAny(argv0, `/\`)+1:]
+}
+
+// TeardownSession ends the Wine session of the prefix of env: it has the wineserver kill its
+// processes, waits up to timeout for it to exit and kills whatever is still bound to the prefix.
+// Processes of other prefixes, like those of tester instances, are left alone. It returns the
+// processes which had to be killed and those which survived. runner should start commands as the
+// owner of the prefix.
+func TeardownSession(runner ifc.CmdRunner, env *[]string, timeout time.Duration) (killed, leftovers []Process, err error) {
+	prefixDir := hlp.EnvValue(env, "WINEPREFIX")
+	// both fail without a running wineserver, which is fine
+	runner.RunCmdSync("wineserver -k >> $AVL_PROCESS_LOGS/wine.log 2>&1", env)
+	runner.RunCmdSync(fmt.Sprintf("timeout %d wineserver -w", int(timeout.Seconds())), env)
+
+	procs, err := SessionProcesses("/proc", prefixDir)
+	for _, signal := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		if err != nil || len(procs) == 0 {
+			return
+		}
+		for _, proc := range procs {
+			syscall.Kill(proc.Pid, signal)
+			if signal == syscall.SIGTERM {
+				killed = append(killed, proc)
+			}
+		}
+		time.Sleep(killGrace)
+		procs, err = SessionProcesses("/proc", prefixDir)
+	}
+	leftovers = procs
+
+	return
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSessionProcesses(t *testing.T) {
+	procRoot := t.TempDir()
+	fake := func(pid, comm, cmdline, environ string) {
+		dir := filepath.Join(procRoot, pid)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0644)
+		os.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0644)
+		os.WriteFile(filepath.Join(dir, "environ"), []byte(environ), 0644)
+	}
+	bound := "HOME=/home/avly\x00WINEPREFIX=/opt/.mtprfx/\x00DISPLAY=:1\x00"
+	fake("210", "wineserver", "/opt/wine-staging/bin/wineserver\x00", bound)
+	fake("42", "services.exe", "C:\\windows\\system32\\services.exe\x00", bound)
+	fake("77", "terminal64.exe", "C:\\Program Files\\MetaTrader 5\\terminal64.exe\x00/portable\x00", bound)
+	fake("78", "plugplay.exe", "C:\\windows\\system32\\plugplay.exe\x00", bound)
+	fake("90", "sh", "sh\x00-c\x00wine terminal64.exe\x00", bound)
+	fake("91", "explorer.exe", "C:\\windows\\explorer.exe\x00", "WINEPREFIX=/opt/.other\x00")
+	// a tester instance runs the same executable in a prefix of its own
+	fake("92", "terminal64.exe", "Z:\\var\\tmp\\avly-tester\\backtest\\terminal64.exe\x00", "WINEPREFIX=/var/tmp/avly-tester/backtest.wineprefix\x00DISPLAY=:90\x00")
+	fake("self", "avly", "avly\x00", bound)
+
+	procs, err := SessionProcesses(procRoot, "/opt/.mtprfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, proc := range procs {
+		names = append(names, proc.String())
+	}
+	expected := "services.exe (42),terminal64.exe (77),plugplay.exe (78),wineserver (210)"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Expected '%v' to be '%v'", strings.Join(names, ","), expected)
+	}
+}
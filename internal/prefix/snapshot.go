@@ -0,0 +1,245 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+const (
+	archiveExt  = ".tar.zst"
+	metadataExt = ".json"
+	// LatestSnapshot selects the newest snapshot wherever a name is expected
+	LatestSnapshot = "latest"
+)
+
+// snapshotExcludes keeps data the terminal recreates and saved broker logins (credentials come from
+// secrets) out of snapshots. Paths are relative to the prefix.
+var snapshotExcludes = []string{
+	"./drive_c/Program Files/MetaTrader 5/logs/*",
+	"./drive_c/Program Files/MetaTrader 5/bases/*",
+	"./drive_c/Program Files/MetaTrader 5/Tester/*",
+	"./drive_c/Program Files/MetaTrader 5/MQL5/Logs/*",
+	"./drive_c/Program Files/MetaTrader 5/config/accounts.dat",
+}
+
+// Snapshot is the metadata of an archived prefix, stored next to the archive.
+type Snapshot struct {
+	Name        string    `json:"name"`
+	Created     time.Time `json:"created"`
+	WineVersion string    `json:"wineVersion"`
+	MT5Build    string    `json:"mt5Build,omitempty"`
+	SHA256      string    `json:"sha256"`
+	Size        int64     `json:"size"`
+}
+
+// SnapshotDir returns the folder of snapshots: AVL_PREFIX_SNAPSHOTS or $THIRD_PARTY/prefix-snapshots,
+// so new containers can be seeded from them.
+func SnapshotDir(env *[]string) string {
+	if dir := hlp.EnvValue(env, "AVL_PREFIX_SNAPSHOTS"); len(dir) > 0 {
+		return dir
+	}
+
+	return filepath.Join(hlp.EnvValue(env, "THIRD_PARTY"), "prefix-snapshots")
+}
+
+// ArchivePath returns the location of the archive of s inside dir.
+func (s Snapshot) ArchivePath(dir string) string {
+	return filepath.Join(dir, s.Name+archiveExt)
+}
+
+// WineVersion returns the output of 'wine --version', like "wine-7.2 (Staging)".
+func WineVersion(runner ifc.CmdRunner, env *[]string) (version string, err error) {
+	version, _, err = runner.RunCmdSync("wine --version", env)
+
+	return strings.TrimSpace(version), err
+}
+
+// CreateSnapshot archives the prefix of env into the snapshot dir. The Wine processes of the prefix
+// must have exited, otherwise the registry may be archived half-written.
+func CreateSnapshot(runner ifc.CmdRunner, env *[]string, mt5Build string, now time.Time) (snapshot Snapshot, err error) {
+	dir := SnapshotDir(env)
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return
+	}
+	snapshot = Snapshot{Name: "prefix-" + now.UTC().Format("20060102-150405"), Created: now.UTC(), MT5Build: mt5Build}
+	if snapshot.WineVersion, err = WineVersion(runner, env); err != nil {
+		return
+	}
+
+	archive := snapshot.ArchivePath(dir)
+	tmp := filepath.Join(dir, "."+snapshot.Name+archiveExt)
+	defer os.Remove(tmp)
+	var excludes []string
+	for _, exclude := range snapshotExcludes {
+		excludes = append(excludes, "--exclude="+hlp.ShellQuote(exclude))
+	}
+	// tar of focal (1.30) predates --zstd
+	cmdLine := fmt.Sprintf("tar --use-compress-program=zstd -cf %s %s -C \"$WINEPREFIX\" .", hlp.ShellQuote(tmp), strings.Join(excludes, " "))
+	if _, _, err = runner.RunCmdSync(cmdLine, env); err != nil {
+		return
+	}
+	// the prefix may hold data of the broker account
+	if err = os.Chmod(tmp, 0600); err != nil {
+		return
+	}
+	if snapshot.SHA256, snapshot.Size, err = checksum(tmp); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, archive); err != nil {
+		return
+	}
+	raw, _ := json.MarshalIndent(snapshot, "", "  ")
+	if err = os.WriteFile(filepath.Join(dir, snapshot.Name+metadataExt), raw, 0640); err != nil {
+		os.Remove(archive)
+	}
+
+	return
+}
+
+// ListSnapshots returns the snapshots of dir with an archive, oldest first.
+func ListSnapshots(dir string) (snapshots []Snapshot, err error) {
+	metadata, err := filepath.Glob(filepath.Join(dir, "*"+metadataExt))
+	if err != nil {
+		return
+	}
+	for _, path := range metadata {
+		raw, errRead := os.ReadFile(path)
+		if errRead != nil {
+			continue
+		}
+		var snapshot Snapshot
+		if json.Unmarshal(raw, &snapshot) != nil || len(snapshot.Name) == 0 {
+			continue
+		}
+		if _, errStat := os.Stat(snapshot.ArchivePath(dir)); errStat != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+
+	return
+}
+
+// FindSnapshot returns the snapshot name of dir, or the newest one for LatestSnapshot.
+func FindSnapshot(dir, name string) (snapshot Snapshot, err error) {
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		return
+	}
+	if name == LatestSnapshot && len(snapshots) > 0 {
+		return snapshots[len(snapshots)-1], nil
+	}
+	for _, candidate := range snapshots {
+		if candidate.Name == name {
+			return candidate, nil
+		}
+	}
+
+	return snapshot, fmt.Errorf("prefix error: no snapshot %s in %s", name, dir)
+}
+
+// Verify compares the archive of s with its checksum.
+func (s Snapshot) Verify(dir string) error {
+	sum, _, err := checksum(s.ArchivePath(dir))
+	if err != nil {
+		return err
+	}
+	if sum != s.SHA256 {
+		return fmt.Errorf("prefix error: checksum of snapshot %s does not match, the archive is corrupt", s.Name)
+	}
+
+	return nil
+}
+
+// RestoreSnapshot replaces the prefix of env with the verified snapshot. The replaced prefix is kept
+// as $WINEPREFIX.previous until the next restore.
+func RestoreSnapshot(runner ifc.CmdRunner, env *[]string, snapshot Snapshot) (err error) {
+	dir := SnapshotDir(env)
+	if err = snapshot.Verify(dir); err != nil {
+		return
+	}
+	prefix := filepath.Clean(hlp.EnvValue(env, "WINEPREFIX"))
+	if len(prefix) < 2 {
+		return errors.New("prefix error: WINEPREFIX is not set")
+	}
+	restored, previous := prefix+".restore", prefix+".previous"
+	if err = os.RemoveAll(restored); err != nil {
+		return
+	}
+	if err = os.MkdirAll(restored, 0755); err != nil {
+		return
+	}
+	cmdLine := fmt.Sprintf("tar --use-compress-program=zstd -xf %s -C %s", hlp.ShellQuote(snapshot.ArchivePath(dir)), hlp.ShellQuote(restored))
+	if _, _, err = runner.RunCmdSync(cmdLine, env); err != nil {
+		os.RemoveAll(restored)
+		return
+	}
+	if err = os.RemoveAll(previous); err != nil {
+		return
+	}
+	if err = os.Rename(prefix, previous); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	return os.Rename(restored, prefix)
+}
+
+// PruneSnapshots removes all but the newest keep snapshots of dir.
+func PruneSnapshots(dir string, keep int) (removed []Snapshot, err error) {
+	if keep < 0 {
+		return nil, fmt.Errorf("prefix error: cannot keep %d snapshots", keep)
+	}
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		return
+	}
+	for len(snapshots) > keep {
+		snapshot := snapshots[0]
+		if err = os.Remove(snapshot.ArchivePath(dir)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		if err = os.Remove(filepath.Join(dir, snapshot.Name+metadataExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		err = nil
+		removed = append(removed, snapshot)
+		snapshots = snapshots[1:]
+	}
+
+	return
+}
+
+func checksum(path string) (sum string, size int64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if size, err = io.Copy(hash, file); err != nil {
+		return
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package prefix
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ifc "github.com/9tmark/avly-trader/internal/interfaces"
+)
+
+// snapshotEnv returns a prefix with a registry file and a terminal log, and a 'wine' stub on PATH.
+func snapshotEnv(t *testing.T) []string {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	root := t.TempDir()
+	bin := filepath.Join(root, "bin")
+	os.MkdirAll(bin, 0755)
+	os.WriteFile(filepath.Join(bin, "wine"), []byte("#!/bin/sh\necho wine-7.2 '(Staging)'\n"), 0755)
+	prefix := filepath.Join(root, "prefix")
+	terminal := filepath.Join(prefix, "drive_c", "Program Files", "MetaTrader 5")
+	os.MkdirAll(filepath.Join(terminal, "logs"), 0755)
+	os.WriteFile(filepath.Join(prefix, "system.reg"), []byte("WINE REGISTRY Version 2\n"), 0644)
+	os.WriteFile(filepath.Join(terminal, "terminal64.exe"), []byte("MZ"), 0644)
+	os.WriteFile(filepath.Join(terminal, "logs", "20220504.log"), []byte("log"), 0644)
+
+	return []string{
+		"WINEPREFIX=" + prefix,
+		"AVL_PREFIX_SNAPSHOTS=" + filepath.Join(root, "snapshots"),
+		"PATH=" + bin + ":" + os.Getenv("PATH"),
+	}
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	env := snapshotEnv(t)
+	runner := &ifc.SafeCmdRunner{}
+	prefix := filepath.Join(filepath.Dir(SnapshotDir(&env)), "prefix")
+
+	snapshot, err := CreateSnapshot(runner, &env, "3320", time.Date(2022, 5, 4, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Name != "prefix-20220504-120000" || snapshot.WineVersion != "wine-7.2 (Staging)" || snapshot.MT5Build != "3320" || len(snapshot.SHA256) != 64 {
+		t.Errorf("Unexpected snapshot '%+v'", snapshot)
+	}
+	found, err := FindSnapshot(SnapshotDir(&env), LatestSnapshot)
+	if err != nil || found.SHA256 != snapshot.SHA256 {
+		t.Errorf("Expected '%+v' to be '%+v' (%v)", found, snapshot, err)
+	}
+
+	// break the prefix
+	os.Remove(filepath.Join(prefix, "system.reg"))
+	if err := RestoreSnapshot(runner, &env, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(prefix, "system.reg")); err != nil {
+		t.Errorf("Expected system.reg to be restored")
+	}
+	if _, err := os.Stat(filepath.Join(prefix, "drive_c", "Program Files", "MetaTrader 5", "logs", "20220504.log")); err == nil {
+		t.Errorf("Expected terminal logs to be left out")
+	}
+	if _, err := os.Stat(prefix + ".previous"); err != nil {
+		t.Errorf("Expected the replaced prefix to be kept")
+	}
+}
+
+func TestRestoreRejectsCorruptArchive(t *testing.T) {
+	env := snapshotEnv(t)
+	runner := &ifc.SafeCmdRunner{}
+	snapshot, err := CreateSnapshot(runner, &env, "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, _ := os.OpenFile(snapshot.ArchivePath(SnapshotDir(&env)), os.O_APPEND|os.O_WRONLY, 0600)
+	file.Write([]byte("garbage"))
+	file.Close()
+
+	if err := RestoreSnapshot(runner, &env, snapshot); err == nil {
+		t.Errorf("Expected a checksum error")
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		snapshot := Snapshot{Name: "prefix-" + string(rune('a'+i)), Created: start.Add(time.Duration(i) * time.Hour)}
+		os.WriteFile(snapshot.ArchivePath(dir), []byte("archive"), 0600)
+		os.WriteFile(filepath.Join(dir, snapshot.Name+metadataExt), []byte(`{"name":"`+snapshot.Name+`","created":"`+snapshot.Created.Format(time.RFC3339)+`"}`), 0640)
+	}
+
+	removed, err := PruneSnapshots(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 3 || removed[0].Name != "prefix-a" {
+		t.Errorf("Expected '%v' to be the three oldest snapshots", removed)
+	}
+	left, _ := ListSnapshots(dir)
+	if len(left) != 1 || left[0].Name != "prefix-d" {
+		t.Errorf("Expected '%v' to be '[prefix-d]'", left)
+	}
+	if _, err = PruneSnapshots(dir, -1); err == nil {
+		t.Errorf("Expected an error for a negative number of snapshots to keep")
+	}
+}
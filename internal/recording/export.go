@@ -0,0 +1,118 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package recording
+
+import (
+	"errors"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MaxGIFFrames bounds GIF exports, which are encoded in memory; longer spans are thinned out evenly,
+// a frame directory keeps them all
+const MaxGIFFrames = 600
+
+// Frames calls fn for all recorded frames of dir within [from, to], oldest first.
+func Frames(dir string, from, to time.Time, fn func(Frame) error) (err error) {
+	segments, err := ListSegments(dir)
+	if err != nil {
+		return
+	}
+	for i, segment := range segments {
+		// a segment ends where the next one starts
+		if segment.Start.After(to) || (i+1 < len(segments) && segments[i+1].Start.Before(from)) {
+			continue
+		}
+		if err = ReadSegment(segment.Path, from, to, fn); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Export writes the frames of dir within [from, to] to out: an animated GIF of at most MaxGIFFrames
+// if out ends in ".gif", otherwise a directory of PNG files named by their time. It returns the
+// number of frames.
+func Export(dir string, from, to time.Time, out string) (count int, err error) {
+	if !from.Before(to) {
+		return 0, errors.New("recording error: 'from' must be before 'to'")
+	}
+	if strings.EqualFold(filepath.Ext(out), ".gif") {
+		return exportGIF(dir, from, to, out)
+	}
+
+	return exportFrames(dir, from, to, out)
+}
+
+func exportFrames(dir string, from, to time.Time, out string) (count int, err error) {
+	if err = os.MkdirAll(out, 0750); err != nil {
+		return
+	}
+	err = Frames(dir, from, to, func(frame Frame) (err error) {
+		file, err := os.OpenFile(filepath.Join(out, frame.Time.UTC().Format(segmentTimeFormat)+".png"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+		if err != nil {
+			return
+		}
+		if err = png.Encode(file, frame.Image); err != nil {
+			file.Close()
+			return
+		}
+		count++
+
+		return file.Close()
+	})
+
+	return
+}
+
+func exportGIF(dir string, from, to time.Time, out string) (count int, err error) {
+	animation := &gif.GIF{}
+	var previous time.Time
+	// frames closer to the previous one than step are skipped, so the span fits MaxGIFFrames
+	step := to.Sub(from) / (MaxGIFFrames - 1)
+	err = Frames(dir, from, to, func(frame Frame) error {
+		if len(animation.Image) > 0 && frame.Time.Sub(previous) < step {
+			return nil
+		}
+		if len(animation.Delay) > 0 {
+			// GIF delays are in 1/100 s
+			animation.Delay[len(animation.Delay)-1] = int(frame.Time.Sub(previous) / (10 * time.Millisecond))
+		}
+		paletted := image.NewPaletted(frame.Image.Rect, palette.Plan9)
+		draw.Draw(paletted, paletted.Rect, frame.Image, image.Point{}, draw.Src)
+		animation.Image = append(animation.Image, paletted)
+		animation.Delay = append(animation.Delay, 100)
+		previous = frame.Time
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if len(animation.Image) == 0 {
+		return 0, errors.New("recording error: no frames recorded in that span")
+	}
+	file, err := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return
+	}
+	if err = gif.EncodeAll(file, animation); err != nil {
+		file.Close()
+		os.Remove(out)
+		return
+	}
+
+	return len(animation.Image), file.Close()
+}
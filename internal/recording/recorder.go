@@ -0,0 +1,100 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package recording
+
+import (
+	"image"
+	"os"
+	"time"
+)
+
+// Recorder samples a display into segments of Dir.
+type Recorder struct {
+	Dir      string
+	Settings Settings
+	// Grab returns the current framebuffer
+	Grab func() (*image.RGBA, error)
+	// Active tells whether to record at all, e.g. only while VNC clients are connected; nil means always
+	Active func() bool
+	Logf   func(format string, a ...interface{})
+
+	segment *SegmentWriter
+	path    string
+	lastErr string
+}
+
+// Run records until stop is closed.
+func (r *Recorder) Run(stop <-chan struct{}) {
+	r.Settings = r.Settings.WithDefaults()
+	ticker := time.NewTicker(r.Settings.Interval())
+	defer ticker.Stop()
+	defer r.closeSegment()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			r.report(r.Step(now))
+		}
+	}
+}
+
+// Step samples one frame at now, rotating segments by span and geometry.
+func (r *Recorder) Step(now time.Time) (err error) {
+	if r.Active != nil && !r.Active() {
+		r.closeSegment()
+		return
+	}
+	img, err := r.Grab()
+	if err != nil {
+		return
+	}
+	span := time.Duration(r.Settings.SegmentMinutes) * time.Minute
+	if r.segment != nil && (!r.segment.Fits(img) || now.Sub(r.segment.Start) >= span) {
+		r.closeSegment()
+	}
+	if r.segment == nil {
+		if err = os.MkdirAll(r.Dir, 0750); err != nil {
+			return
+		}
+		path := SegmentPath(r.Dir, now)
+		if r.segment, err = CreateSegment(path, img.Rect.Dx(), img.Rect.Dy(), r.Settings.KeyframeEvery, now); err != nil {
+			return
+		}
+		r.path = path
+		r.prune(now)
+	}
+
+	return r.segment.Write(now, img)
+}
+
+func (r *Recorder) closeSegment() {
+	if r.segment == nil {
+		return
+	}
+	r.report(r.segment.Close())
+	r.segment = nil
+}
+
+func (r *Recorder) prune(now time.Time) {
+	maxAge := time.Duration(r.Settings.MaxAgeHours) * time.Hour
+	maxSize := int64(r.Settings.MaxSizeMB) << 20
+	_, err := Prune(r.Dir, maxAge, maxSize, now, r.path)
+	r.report(err)
+}
+
+// report logs an error once until a different one, or none, occurs.
+func (r *Recorder) report(err error) {
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	if message != r.lastErr && len(message) > 0 && r.Logf != nil {
+		r.Logf("recording: %s", message)
+	}
+	r.lastErr = message
+}
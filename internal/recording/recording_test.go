@@ -0,0 +1,242 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package recording
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testImage(width, height int, marker int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x40, A: 0xff})
+		}
+	}
+	// changes a single tile
+	img.SetRGBA(marker%width, 0, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+
+	return img
+}
+
+func TestSegmentRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segment"+SegmentExt)
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	writer, err := CreateSegment(path, 70, 40, 2, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []*image.RGBA
+	for i := 0; i < 5; i++ {
+		img := testImage(70, 40, i*20)
+		written = append(written, img)
+		if err := writer.Write(start.Add(time.Duration(i)*time.Second), img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var read []Frame
+	err = ReadSegment(path, start, start.Add(time.Hour), func(frame Frame) error {
+		read = append(read, frame)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(written) {
+		t.Fatalf("Expected '%v' to be '%v'", len(read), len(written))
+	}
+	for i := range read {
+		if !bytes.Equal(read[i].Image.Pix, written[i].Pix) {
+			t.Errorf("Expected frame %d to equal the written image", i)
+		}
+		if !read[i].Time.Equal(start.Add(time.Duration(i) * time.Second)) {
+			t.Errorf("Expected '%v' to be '%v'", read[i].Time, start.Add(time.Duration(i)*time.Second))
+		}
+	}
+}
+
+func TestSegmentWriteRejectsOtherSize(t *testing.T) {
+	writer, err := CreateSegment(filepath.Join(t.TempDir(), "segment"+SegmentExt), 10, 10, 0, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if err := writer.Write(time.Now(), testImage(20, 10, 0)); err == nil {
+		t.Errorf("Expected an error for a frame of another size")
+	}
+}
+
+func TestReadTruncatedSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segment"+SegmentExt)
+	start := time.Now()
+	writer, err := CreateSegment(path, 40, 40, 0, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		writer.Write(start.Add(time.Duration(i)*time.Second), testImage(40, 40, i*10))
+	}
+	// the recorder was killed: flushed, but no gzip trailer
+	writer.file.Close()
+
+	count := 0
+	err = ReadSegment(path, start.Add(-time.Second), start.Add(time.Minute), func(Frame) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected '%v' to be '%v'", count, 3)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2022, 5, 10, 0, 0, 0, 0, time.UTC)
+	var paths []string
+	for _, age := range []time.Duration{100 * time.Hour, 50 * time.Hour, 20 * time.Hour, time.Hour} {
+		path := SegmentPath(dir, now.Add(-age))
+		os.WriteFile(path, make([]byte, 1000), 0640)
+		paths = append(paths, path)
+	}
+
+	removed, err := Prune(dir, 72*time.Hour, 2500, now, paths[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first by age, the second by size
+	if len(removed) != 2 || removed[0] != paths[0] || removed[1] != paths[1] {
+		t.Errorf("Expected '%v' to be '%v'", removed, paths[:2])
+	}
+
+	removed, _ = Prune(dir, 72*time.Hour, 0, now, paths[3])
+	if len(removed) != 1 || removed[0] != paths[2] {
+		t.Errorf("Expected '%v' to be '%v'", removed, paths[2:3])
+	}
+}
+
+func TestRecorderRotatesAndPauses(t *testing.T) {
+	dir := t.TempDir()
+	active := true
+	size := 30
+	recorder := &Recorder{
+		Dir:      dir,
+		Settings: Settings{SegmentMinutes: 1}.WithDefaults(),
+		Grab: func() (*image.RGBA, error) {
+			return testImage(size, 20, 0), nil
+		},
+		Active: func() bool { return active },
+	}
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		offset time.Duration
+		active bool
+		size   int
+	}{
+		{0, true, 30},
+		{10 * time.Second, true, 30},
+		// span exceeded
+		{70 * time.Second, true, 30},
+		// geometry changed
+		{80 * time.Second, true, 40},
+		{90 * time.Second, false, 40},
+		{100 * time.Second, true, 40},
+	}
+	for _, step := range steps {
+		active, size = step.active, step.size
+		if err := recorder.Step(start.Add(step.offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder.closeSegment()
+
+	segments, _ := ListSegments(dir)
+	if len(segments) != 4 {
+		t.Fatalf("Expected '%v' to be '%v'", len(segments), 4)
+	}
+	if !segments[3].Start.Equal(start.Add(100 * time.Second)) {
+		t.Errorf("Expected '%v' to be '%v'", segments[3].Start, start.Add(100*time.Second))
+	}
+	count := 0
+	Frames(dir, start, start.Add(time.Hour), func(Frame) error {
+		count++
+		return nil
+	})
+	if count != 5 {
+		t.Errorf("Expected '%v' to be '%v'", count, 5)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	writer, _ := CreateSegment(SegmentPath(dir, start), 40, 30, 0, start)
+	for i := 0; i < 4; i++ {
+		writer.Write(start.Add(time.Duration(i)*2*time.Second), testImage(40, 30, i*10))
+	}
+	writer.Close()
+
+	out := t.TempDir()
+	count, err := Export(dir, start.Add(time.Second), start.Add(time.Minute), filepath.Join(out, "frames"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(out, "frames"))
+	if count != 3 || len(entries) != 3 {
+		t.Errorf("Expected '%v' and '%v' to be '%v'", count, len(entries), 3)
+	}
+
+	animation := filepath.Join(out, "replay.gif")
+	if _, err := Export(dir, start, start.Add(time.Minute), animation); err != nil {
+		t.Fatal(err)
+	}
+	file, _ := os.Open(animation)
+	defer file.Close()
+	decoded, err := gif.DecodeAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Image) != 4 || decoded.Delay[0] != 200 {
+		t.Errorf("Expected '%v' frames with delay '%v' to be '4' with '200'", len(decoded.Image), decoded.Delay[0])
+	}
+
+	if _, err := Export(dir, start.Add(time.Hour), start.Add(2*time.Hour), animation); err == nil {
+		t.Errorf("Expected an error for a span without frames")
+	}
+}
+
+func TestExportThinsOutLongSpans(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	writer, _ := CreateSegment(SegmentPath(dir, start), 8, 8, 0, start)
+	for i := 0; i < 2*MaxGIFFrames; i++ {
+		writer.Write(start.Add(time.Duration(i)*time.Second), testImage(8, 8, i))
+	}
+	writer.Close()
+
+	animation := filepath.Join(t.TempDir(), "replay.gif")
+	count, err := Export(dir, start, start.Add(2*MaxGIFFrames*time.Second), animation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count > MaxGIFFrames || count < MaxGIFFrames/2 {
+		t.Errorf("Expected '%v' frames to fit '%v'", count, MaxGIFFrames)
+	}
+}
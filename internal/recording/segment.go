@@ -0,0 +1,282 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"time"
+)
+
+// A segment is a gzip stream of a header and frames. Keyframes hold all pixels (RGB), delta frames
+// only the tiles which changed since the previous frame. Each segment starts with a keyframe, so
+// segments can be read and deleted independently.
+const (
+	segmentMagic = "AVLREC1\n"
+	// SegmentExt is the file extension of segments
+	SegmentExt = ".avlrec"
+	tileSize   = 32
+
+	frameKey   byte = 'K'
+	frameDelta byte = 'D'
+)
+
+// Frame is a decoded screen image with the time it was sampled.
+type Frame struct {
+	Time  time.Time
+	Image *image.RGBA
+}
+
+// SegmentWriter appends frames to a segment file.
+type SegmentWriter struct {
+	file          *os.File
+	gz            *gzip.Writer
+	w             *bufio.Writer
+	width, height int
+	previous      []byte
+	keyframeEvery int
+	sinceKeyframe int
+	Start         time.Time
+	Frames        int
+}
+
+// CreateSegment starts a segment at path for frames of the given size. keyframeEvery > 0 forces
+// a keyframe every that many frames, which bounds the damage of a corrupt delta.
+func CreateSegment(path string, width, height, keyframeEvery int, start time.Time) (s *SegmentWriter, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return
+	}
+	gz, _ := gzip.NewWriterLevel(file, gzip.BestSpeed)
+	s = &SegmentWriter{file: file, gz: gz, w: bufio.NewWriter(gz), width: width, height: height, keyframeEvery: keyframeEvery, Start: start}
+	s.w.WriteString(segmentMagic)
+	binary.Write(s.w, binary.BigEndian, [2]uint16{uint16(width), uint16(height)})
+	if err = s.w.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// Fits tells whether img has the size of the segment.
+func (s *SegmentWriter) Fits(img *image.RGBA) bool {
+	return img.Rect.Dx() == s.width && img.Rect.Dy() == s.height
+}
+
+// Write appends img sampled at t.
+func (s *SegmentWriter) Write(t time.Time, img *image.RGBA) (err error) {
+	if !s.Fits(img) {
+		return errors.New("recording error: frame size differs from segment")
+	}
+	current := toRGB(img)
+	if err = binary.Write(s.w, binary.BigEndian, t.UnixNano()); err != nil {
+		return
+	}
+	if s.previous == nil || (s.keyframeEvery > 0 && s.sinceKeyframe >= s.keyframeEvery) {
+		s.w.WriteByte(frameKey)
+		_, err = s.w.Write(current)
+		s.sinceKeyframe = 0
+	} else {
+		s.w.WriteByte(frameDelta)
+		err = s.writeDelta(current)
+		s.sinceKeyframe++
+	}
+	if err != nil {
+		return
+	}
+	s.previous = current
+	s.Frames++
+	// a crash must not cost more than the frames of the last flush
+	if err = s.w.Flush(); err != nil {
+		return
+	}
+
+	return s.gz.Flush()
+}
+
+// Close finishes the gzip stream.
+func (s *SegmentWriter) Close() (err error) {
+	if err = s.w.Flush(); err != nil {
+		s.file.Close()
+		return
+	}
+	if err = s.gz.Close(); err != nil {
+		s.file.Close()
+		return
+	}
+
+	return s.file.Close()
+}
+
+func (s *SegmentWriter) writeDelta(current []byte) (err error) {
+	var changed []uint32
+	forEachTile(s.width, s.height, func(index uint32, rows [][2]int) {
+		for _, row := range rows {
+			if !bytes.Equal(current[row[0]:row[1]], s.previous[row[0]:row[1]]) {
+				changed = append(changed, index)
+				return
+			}
+		}
+	})
+	if err = binary.Write(s.w, binary.BigEndian, uint32(len(changed))); err != nil {
+		return
+	}
+	next := 0
+	forEachTile(s.width, s.height, func(index uint32, rows [][2]int) {
+		if next >= len(changed) || changed[next] != index || err != nil {
+			return
+		}
+		next++
+		if err = binary.Write(s.w, binary.BigEndian, index); err != nil {
+			return
+		}
+		for _, row := range rows {
+			if _, err = s.w.Write(current[row[0]:row[1]]); err != nil {
+				return
+			}
+		}
+	})
+
+	return
+}
+
+// ReadSegment decodes the frames of a segment within [from, to]. A truncated segment, as left by a
+// killed recorder, yields the frames written so far.
+func ReadSegment(path string, from, to time.Time, frame func(Frame) error) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("recording error: %s: %w", path, err)
+	}
+	r := bufio.NewReader(gz)
+
+	magic := make([]byte, len(segmentMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != segmentMagic {
+		return fmt.Errorf("recording error: %s is no segment", path)
+	}
+	var size [2]uint16
+	if err = binary.Read(r, binary.BigEndian, &size); err != nil {
+		return
+	}
+	width, height := int(size[0]), int(size[1])
+	current := make([]byte, width*height*3)
+
+	for {
+		var nanos int64
+		if err = binary.Read(r, binary.BigEndian, &nanos); err != nil {
+			break
+		}
+		var kind byte
+		if kind, err = r.ReadByte(); err != nil {
+			break
+		}
+		switch kind {
+		case frameKey:
+			_, err = io.ReadFull(r, current)
+		case frameDelta:
+			err = readDelta(r, width, height, current)
+		default:
+			err = fmt.Errorf("recording error: %s: corrupt frame", path)
+		}
+		if err != nil {
+			break
+		}
+		t := time.Unix(0, nanos)
+		if t.Before(from) || t.After(to) {
+			continue
+		}
+		if err = frame(Frame{Time: t, Image: fromRGB(current, width, height)}); err != nil {
+			return
+		}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+
+	return
+}
+
+func readDelta(r io.Reader, width, height int, current []byte) (err error) {
+	var count uint32
+	if err = binary.Read(r, binary.BigEndian, &count); err != nil {
+		return
+	}
+	tiles := map[uint32][][2]int{}
+	forEachTile(width, height, func(index uint32, rows [][2]int) {
+		tiles[index] = rows
+	})
+	for i := uint32(0); i < count; i++ {
+		var index uint32
+		if err = binary.Read(r, binary.BigEndian, &index); err != nil {
+			return
+		}
+		rows, ok := tiles[index]
+		if !ok {
+			return errors.New("recording error: corrupt delta")
+		}
+		for _, row := range rows {
+			if _, err = io.ReadFull(r, current[row[0]:row[1]]); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// forEachTile calls fn for each tile with the byte ranges of its rows inside an RGB buffer.
+func forEachTile(width, height int, fn func(index uint32, rows [][2]int)) {
+	var index uint32
+	for ty := 0; ty < height; ty += tileSize {
+		for tx := 0; tx < width; tx += tileSize {
+			var rows [][2]int
+			for y := ty; y < ty+tileSize && y < height; y++ {
+				end := tx + tileSize
+				if end > width {
+					end = width
+				}
+				rows = append(rows, [2]int{(y*width + tx) * 3, (y*width + end) * 3})
+			}
+			fn(index, rows)
+			index++
+		}
+	}
+}
+
+func toRGB(img *image.RGBA) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	rgb := make([]byte, 0, width*height*3)
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+width*4]
+		for x := 0; x < len(row); x += 4 {
+			rgb = append(rgb, row[x], row[x+1], row[x+2])
+		}
+	}
+
+	return rgb
+}
+
+func fromRGB(rgb []byte, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, j := 0, 0; i < len(rgb); i, j = i+3, j+4 {
+		img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = rgb[i], rgb[i+1], rgb[i+2], 0xff
+	}
+
+	return img
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package recording
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultFPS            = 1.0
+	MaxFPS                = 10.0
+	DefaultKeyframeEvery  = 60
+	DefaultSegmentMinutes = 10
+	DefaultMaxAgeHours    = 72
+	DefaultMaxSizeMB      = 2048
+)
+
+// Settings configure the recorder of the live display.
+type Settings struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// FPS is the number of frames sampled per second, fractions allowed (0.2 = every 5 seconds)
+	FPS float64 `json:"fps,omitempty"`
+	// KeyframeEvery stores a full frame after that many delta frames
+	KeyframeEvery int `json:"keyframeEvery,omitempty"`
+	// SegmentMinutes is the span of a segment file, the unit retention removes
+	SegmentMinutes int `json:"segmentMinutes,omitempty"`
+	MaxAgeHours    int `json:"maxAgeHours,omitempty"`
+	MaxSizeMB      int `json:"maxSizeMB,omitempty"`
+	// OnlyWithSessions pauses recording while no VNC client is connected
+	OnlyWithSessions bool `json:"onlyWithSessions,omitempty"`
+}
+
+// Validate checks the ranges of the settings.
+func (s Settings) Validate() error {
+	if s.FPS < 0 || s.FPS > MaxFPS {
+		return fmt.Errorf("recording error: fps must be between 0 and %g", MaxFPS)
+	}
+	if s.KeyframeEvery < 0 || s.SegmentMinutes < 0 || s.MaxAgeHours < 0 || s.MaxSizeMB < 0 {
+		return fmt.Errorf("recording error: negative limits are not allowed")
+	}
+
+	return nil
+}
+
+// WithDefaults fills unset fields.
+func (s Settings) WithDefaults() Settings {
+	if s.FPS == 0 {
+		s.FPS = DefaultFPS
+	}
+	if s.KeyframeEvery == 0 {
+		s.KeyframeEvery = DefaultKeyframeEvery
+	}
+	if s.SegmentMinutes == 0 {
+		s.SegmentMinutes = DefaultSegmentMinutes
+	}
+	if s.MaxAgeHours == 0 {
+		s.MaxAgeHours = DefaultMaxAgeHours
+	}
+	if s.MaxSizeMB == 0 {
+		s.MaxSizeMB = DefaultMaxSizeMB
+	}
+
+	return s
+}
+
+// Interval returns the time between two frames.
+func (s Settings) Interval() time.Duration {
+	return time.Duration(float64(time.Second) / s.FPS)
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package recording
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+// segmentTimeFormat names segments by their start (UTC), so names sort chronologically
+const segmentTimeFormat = "20060102-150405.000"
+
+// Dir returns the folder keeping the segments of env.
+func Dir(env *[]string) string {
+	return filepath.Join(hlp.EnvValue(env, "AVL_LOGS"), "recordings")
+}
+
+// SegmentInfo describes a segment file.
+type SegmentInfo struct {
+	Path  string
+	Start time.Time
+	Size  int64
+}
+
+// SegmentPath returns the file name of a segment starting at start.
+func SegmentPath(dir string, start time.Time) string {
+	return filepath.Join(dir, start.UTC().Format(segmentTimeFormat)+SegmentExt)
+}
+
+// ListSegments returns the segments of dir, oldest first.
+func ListSegments(dir string) (segments []SegmentInfo, err error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, SegmentExt) {
+			continue
+		}
+		start, errParse := time.ParseInLocation(segmentTimeFormat, strings.TrimSuffix(name, SegmentExt), time.UTC)
+		info, errInfo := entry.Info()
+		if errParse != nil || errInfo != nil {
+			continue
+		}
+		segments = append(segments, SegmentInfo{Path: filepath.Join(dir, name), Start: start, Size: info.Size()})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Start.Before(segments[j].Start)
+	})
+
+	return
+}
+
+// Prune removes segments older than maxAge and then the oldest ones until the rest fits maxSize
+// bytes. The segment at keep, which is being written, is never removed.
+func Prune(dir string, maxAge time.Duration, maxSize int64, now time.Time, keep string) (removed []string, err error) {
+	segments, err := ListSegments(dir)
+	if err != nil {
+		return
+	}
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+	}
+	for _, segment := range segments {
+		if segment.Path == keep {
+			continue
+		}
+		if now.Sub(segment.Start) <= maxAge && total <= maxSize {
+			break
+		}
+		if err = os.Remove(segment.Path); err != nil {
+			return
+		}
+		total -= segment.Size
+		removed = append(removed, segment.Path)
+	}
+
+	return
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// time zones are resolved without relying on the tzdata of the image
+	_ "time/tzdata"
+)
+
+// macros are the shorthands of common schedules
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+
+var dayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// field is a set of allowed values of a cron field; all tells whether it was "*"
+type field struct {
+	values map[int]bool
+	all    bool
+}
+
+// Planner tells when a task runs next.
+type Planner interface {
+	Next(after time.Time) time.Time
+}
+
+// Interval plans runs a fixed time apart, e.g. a restart every 7 days.
+type Interval time.Duration
+
+// ParseInterval reads a Go duration of at least a minute or a number of days like "7d".
+func ParseInterval(every string) (Interval, error) {
+	var duration time.Duration
+	var err error
+	if days := strings.TrimSuffix(every, "d"); days != every {
+		var n int
+		n, err = strconv.Atoi(days)
+		duration = time.Duration(n) * 24 * time.Hour
+	} else {
+		duration, err = time.ParseDuration(every)
+	}
+	if err != nil || duration < time.Minute {
+		return 0, fmt.Errorf("schedule error: interval %q needs to be at least 1m", every)
+	}
+
+	return Interval(duration), nil
+}
+
+// Next returns after plus the interval.
+func (i Interval) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(i))
+}
+
+// Cron is a parsed cron expression of five fields (minute, hour, day of month, month, day of week)
+// evaluated in a time zone.
+type Cron struct {
+	Expr                          string
+	Location                      *time.Location
+	minute, hour, dom, month, dow field
+}
+
+// ParseCron parses expr like "30 22 * * 1-5", "*/15 8-17 * * mon-fri" or "@daily" in the time zone
+// tz (empty means UTC).
+func ParseCron(expr, tz string) (cron Cron, err error) {
+	cron.Expr = expr
+	if cron.Location, err = time.LoadLocation(tz); err != nil {
+		return cron, fmt.Errorf("schedule error: unknown time zone %q", tz)
+	}
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		if expanded, ok := macros[fields[0]]; ok {
+			fields = strings.Fields(expanded)
+		}
+	}
+	if len(fields) != 5 {
+		return cron, fmt.Errorf("schedule error: %q needs 5 fields", expr)
+	}
+	specs := []struct {
+		target   *field
+		min, max int
+		names    map[string]int
+	}{
+		{&cron.minute, 0, 59, nil},
+		{&cron.hour, 0, 23, nil},
+		{&cron.dom, 1, 31, nil},
+		{&cron.month, 1, 12, monthNames},
+		{&cron.dow, 0, 7, dayNames},
+	}
+	for i, spec := range specs {
+		if *spec.target, err = parseField(fields[i], spec.min, spec.max, spec.names); err != nil {
+			return cron, fmt.Errorf("schedule error: %q: %w", expr, err)
+		}
+	}
+	// 7 is Sunday as well
+	if cron.dow.values[7] {
+		cron.dow.values[0] = true
+	}
+
+	return
+}
+
+func parseField(text string, min, max int, names map[string]int) (f field, err error) {
+	f.values = map[int]bool{}
+	f.all = strings.HasPrefix(text, "*")
+	for _, part := range strings.Split(text, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return f, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		from, to := min, max
+		if rangeText != "*" {
+			fromText, toText, isRange := strings.Cut(rangeText, "-")
+			if from, err = parseValue(fromText, names); err != nil {
+				return
+			}
+			to = from
+			if isRange {
+				if to, err = parseValue(toText, names); err != nil {
+					return
+				}
+			} else if hasStep {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return f, fmt.Errorf("%q is out of %d-%d", part, min, max)
+		}
+		for value := from; value <= to; value += step {
+			f.values[value] = true
+		}
+	}
+
+	return
+}
+
+func parseValue(text string, names map[string]int) (int, error) {
+	if value, ok := names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", text)
+	}
+
+	return value, nil
+}
+
+// matchesDay follows cron: if both day fields are restricted, either one matching suffices.
+func (c Cron) matchesDay(t time.Time) bool {
+	dom, dow := c.dom.values[t.Day()], c.dow.values[int(t.Weekday())]
+	if !c.dom.all && !c.dow.all {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+// Next returns the first time after after matching the expression. It returns the zero time if
+// there is none within five years, e.g. for February 30th.
+func (c Cron) Next(after time.Time) time.Time {
+	t := after.In(c.Location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case !c.month.values[int(t.Month())]:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.Location)
+		case !c.matchesDay(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.Location)
+		case !c.hour.values[t.Hour()]:
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.Location)
+		case !c.minute.values[t.Minute()]:
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// around daylight saving changes, wall clock arithmetic may not advance
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,208 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package schedule
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// a Thursday
+	after := time.Date(2022, 3, 24, 22, 7, 30, 0, time.UTC)
+	cases := []struct {
+		expr, tz string
+		expected time.Time
+	}{
+		{"*/15 * * * *", "", time.Date(2022, 3, 24, 22, 15, 0, 0, time.UTC)},
+		{"0 22 * * *", "", time.Date(2022, 3, 25, 22, 0, 0, 0, time.UTC)},
+		{"30 3 * * sun", "", time.Date(2022, 3, 27, 3, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", "", time.Date(2022, 3, 25, 9, 0, 0, 0, time.UTC)},
+		{"@monthly", "", time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", "", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// either day field matches if both are restricted
+		{"0 12 1 * 6", "", time.Date(2022, 3, 26, 12, 0, 0, 0, time.UTC)},
+		// 08:00 in London, which switches to summer time on March 27th
+		{"0 8 * * *", "Europe/London", time.Date(2022, 3, 25, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 0", "Europe/London", time.Date(2022, 3, 27, 7, 0, 0, 0, time.UTC)},
+		// 01:30 does not exist in London on March 27th
+		{"30 1 27 3 *", "Europe/London", time.Date(2023, 3, 27, 0, 30, 0, 0, time.UTC)},
+		{"0 0 30 2 *", "", time.Time{}},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr, c.tz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := cron.Next(after); !next.Equal(c.expected) {
+			t.Errorf("Expected '%v' to be '%v' (%s %s)", next.UTC(), c.expected, c.expr, c.tz)
+		}
+	}
+}
+
+func TestParseCronRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "@often", "x * * * *"} {
+		if _, err := ParseCron(expr, ""); err == nil {
+			t.Errorf("Expected an error for '%v'", expr)
+		}
+	}
+	if _, err := ParseCron("@daily", "Mars/Olympus"); err == nil {
+		t.Errorf("Expected an error for an unknown time zone")
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	if err := (Settings{}).Validate(); err != nil {
+		t.Errorf("Expected the default tasks to be valid: %v", err)
+	}
+	for _, invalid := range []Settings{
+		{Tasks: []Task{{Name: "a", Cron: "@daily", Action: ActionCleanUp}, {Name: "a", Cron: "@daily", Action: ActionRestart}}},
+		{Tasks: []Task{{Name: "a b", Cron: "@daily", Action: ActionCleanUp}}},
+		{Tasks: []Task{{Name: "a", Cron: "@daily", Action: "reboot"}}},
+		{Tasks: []Task{{Name: "a", Cron: "@daily", Action: ActionBackup, Target: "backups"}}},
+		{Tasks: []Task{{Name: "a", Cron: "@daily", Action: ActionCommand}}},
+		{Tasks: []Task{{Name: "a", Cron: "@daily", Action: ActionCommand, Command: "true", Timeout: "soon"}}},
+		{TimeZone: "Nowhere", Tasks: []Task{{Name: "a", Cron: "@daily", Action: ActionCleanUp}}},
+		{Windows: Windows{{Name: "w", Start: "@daily", Duration: "30s"}}},
+		{Windows: Windows{{Name: "w", Start: "@daily", Duration: "1h"}, {Name: "w", Start: "@weekly", Duration: "1h"}}},
+		{Tasks: []Task{{Name: "a", Cron: "@daily", Action: ActionRestart, Windows: []string{"weekend"}}}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected an error for '%+v'", invalid)
+		}
+	}
+}
+
+func TestSchedulerPersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", stateName)
+	settings := Settings{TimeZone: "UTC", Tasks: []Task{{Name: "cleanup", Cron: "0 3 * * *", Action: ActionCleanUp}}}
+	start := time.Date(2022, 3, 24, 12, 0, 0, 0, time.UTC)
+
+	scheduler, err := New(settings, path, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due := scheduler.Due(start.Add(14 * time.Hour)); len(due) != 0 {
+		t.Errorf("Expected '%v' to be empty", due)
+	}
+	ran := start.Add(15 * time.Hour)
+	if due := scheduler.Due(ran); len(due) != 1 {
+		t.Fatalf("Expected '%v' to be due", due)
+	}
+	if err = scheduler.Done(scheduler.Tasks[0], ran, errors.New("disk full")); err != nil {
+		t.Fatal(err)
+	}
+
+	// avly was down when the next run was due, so it is made up for on start
+	restarted := start.Add(3 * 24 * time.Hour)
+	scheduler, err = New(settings, path, restarted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := scheduler.State["cleanup"]
+	if state.LastRun == nil || !state.LastRun.Equal(ran) || state.LastResult != "disk full" {
+		t.Errorf("Expected '%+v' to keep the last run", state)
+	}
+	if due := scheduler.Due(restarted); len(due) != 1 {
+		t.Errorf("Expected the missed run to be due")
+	}
+
+	// a changed schedule is planned from now on
+	settings.Tasks[0].Cron = "0 4 * * *"
+	scheduler, _ = New(settings, path, restarted)
+	if next := scheduler.State["cleanup"].NextRun; !next.Equal(time.Date(2022, 3, 28, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected '%v' to be '%v'", next, time.Date(2022, 3, 28, 4, 0, 0, 0, time.UTC))
+	}
+}
+
+func TestWindowsAllow(t *testing.T) {
+	windows := Windows{
+		{Name: "weekend", Start: "0 22 * * fri", Duration: "48h"},
+		{Name: "rollover", Start: "55 21 * * mon-thu", Duration: "20m", TimeZone: "UTC"},
+	}
+	cases := []struct {
+		at      time.Time
+		names   []string
+		allowed bool
+		next    time.Time
+	}{
+		// Saturday noon
+		{time.Date(2022, 3, 26, 12, 0, 0, 0, time.UTC), nil, true, time.Time{}},
+		// Sunday 22:00, the weekend window just closed
+		{time.Date(2022, 3, 27, 22, 0, 0, 0, time.UTC), nil, false, time.Date(2022, 3, 28, 21, 55, 0, 0, time.UTC)},
+		// Tuesday during the rollover
+		{time.Date(2022, 3, 29, 22, 10, 0, 0, time.UTC), nil, true, time.Time{}},
+		{time.Date(2022, 3, 29, 22, 10, 0, 0, time.UTC), []string{"weekend"}, false, time.Date(2022, 4, 1, 22, 0, 0, 0, time.UTC)},
+		// Thursday during the London open
+		{time.Date(2022, 3, 31, 8, 0, 0, 0, time.UTC), nil, false, time.Date(2022, 3, 31, 21, 55, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		allowed, next := windows.Allow(c.at, c.names)
+		if allowed != c.allowed || !next.Equal(c.next) {
+			t.Errorf("Expected '%v %v' to be '%v %v' at %v", allowed, next, c.allowed, c.next, c.at)
+		}
+	}
+	if allowed, _ := (Windows{}).Allow(time.Now(), nil); !allowed {
+		t.Errorf("Expected no windows to allow maintenance any time")
+	}
+}
+
+func TestIsDisruptive(t *testing.T) {
+	no := false
+	cases := []struct {
+		task           Task
+		touchesHistory bool
+		expected       bool
+	}{
+		{Task{Action: ActionRestart}, false, true},
+		{Task{Action: ActionSnapshot}, false, true},
+		{Task{Action: ActionCleanUp}, false, false},
+		{Task{Action: ActionCleanUp}, true, true},
+		{Task{Action: ActionBackup}, false, false},
+		{Task{Action: ActionRestart, Disruptive: &no}, false, false},
+	}
+	for _, c := range cases {
+		if disruptive := c.task.IsDisruptive(c.touchesHistory); disruptive != c.expected {
+			t.Errorf("Expected '%v' to be '%v' (%s)", disruptive, c.expected, c.task.Action)
+		}
+	}
+}
+
+func TestIntervalTasks(t *testing.T) {
+	for every, expected := range map[string]time.Duration{"7d": 7 * 24 * time.Hour, "36h": 36 * time.Hour} {
+		if interval, err := ParseInterval(every); err != nil || time.Duration(interval) != expected {
+			t.Errorf("Expected '%v' to be '%v' (%v)", time.Duration(interval), expected, err)
+		}
+	}
+	for _, every := range []string{"0d", "30s", "weekly"} {
+		if _, err := ParseInterval(every); err == nil {
+			t.Errorf("Expected an error for '%v'", every)
+		}
+	}
+	if err := (Task{Name: "a", Cron: "@daily", Every: "7d", Action: ActionRestart}).Validate(); err == nil {
+		t.Errorf("Expected an error for both cron and every")
+	}
+
+	path := filepath.Join(t.TempDir(), stateName)
+	settings := Settings{Tasks: []Task{{Name: "restart", Every: "7d", Action: ActionRestart}}}
+	start := time.Date(2022, 6, 30, 12, 0, 0, 0, time.UTC)
+	scheduler, err := New(settings, path, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due := scheduler.Due(start.Add(6 * 24 * time.Hour)); len(due) != 0 {
+		t.Errorf("Expected '%v' to be empty", due)
+	}
+	// deferred to a maintenance window, the next run counts from the actual one
+	ran := start.Add(8 * 24 * time.Hour)
+	scheduler.Done(scheduler.Tasks[0], ran, nil)
+	if next := scheduler.State["restart"].NextRun; !next.Equal(ran.Add(7 * 24 * time.Hour)) {
+		t.Errorf("Expected '%v' to be '%v'", next, ran.Add(7*24*time.Hour))
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateName = "schedule.json"
+
+// TaskState is the persisted schedule of a task.
+type TaskState struct {
+	Spec       string     `json:"spec"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastResult string     `json:"lastResult,omitempty"`
+	NextRun    *time.Time `json:"nextRun,omitempty"`
+}
+
+// State maps task names to their schedule.
+type State map[string]TaskState
+
+// StatePath returns the location of the persisted schedule inside stateDir.
+func StatePath(stateDir string) string {
+	return filepath.Join(stateDir, stateName)
+}
+
+// LoadState reads the schedule at path; a missing file means none.
+func LoadState(path string) (state State, err error) {
+	state = State{}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(raw, &state); err != nil {
+		err = fmt.Errorf("schedule error: invalid %s: %w", path, err)
+	}
+
+	return
+}
+
+// Save writes the schedule to path.
+func (s State) Save(path string) (err error) {
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, raw, 0644); err != nil {
+		return
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Scheduler tells which tasks are due. Runs missed while avly was down are made up for once.
+type Scheduler struct {
+	Tasks []Task
+	State State
+	path  string
+	plans map[string]Planner
+}
+
+// New plans the tasks of settings, continuing the schedule persisted at path. Tasks new or with a
+// changed schedule are planned from now on.
+func New(settings Settings, path string, now time.Time) (s *Scheduler, err error) {
+	state, err := LoadState(path)
+	if err != nil {
+		return
+	}
+	s = &Scheduler{Tasks: settings.WithDefaults().Tasks, State: State{}, path: path, plans: map[string]Planner{}}
+	for _, task := range s.Tasks {
+		plan, errPlan := task.Planner()
+		if errPlan != nil {
+			return nil, errPlan
+		}
+		s.plans[task.Name] = plan
+		taskState, ok := state[task.Name]
+		if !ok || taskState.Spec != task.Spec() || taskState.NextRun == nil {
+			next := plan.Next(now)
+			taskState = TaskState{Spec: task.Spec(), LastRun: taskState.LastRun, LastResult: taskState.LastResult, NextRun: &next}
+		}
+		s.State[task.Name] = taskState
+	}
+	err = s.State.Save(path)
+
+	return
+}
+
+// Due returns the tasks whose next run is not after now, in the order of the config file.
+func (s *Scheduler) Due(now time.Time) (due []Task) {
+	for _, task := range s.Tasks {
+		if next := s.State[task.Name].NextRun; next != nil && !next.IsZero() && !next.After(now) {
+			due = append(due, task)
+		}
+	}
+
+	return
+}
+
+// Done records a run of task which ended at now with err and plans the next one.
+func (s *Scheduler) Done(task Task, now time.Time, err error) error {
+	state := s.State[task.Name]
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	next := s.plans[task.Name].Next(now)
+	state.LastRun, state.LastResult, state.NextRun = &now, result, &next
+	s.State[task.Name] = state
+
+	return s.State.Save(s.path)
+}
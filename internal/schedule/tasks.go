@@ -0,0 +1,236 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package schedule
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const (
+	ActionCleanUp    = "cleanup"
+	ActionRotateLogs = "rotate-logs"
+	ActionSnapshot   = "snapshot"
+	ActionRestart    = "restart"
+	ActionBackup     = "backup"
+	ActionCommand    = "command"
+
+	// DefaultCommandTimeout bounds custom commands without own timeout
+	DefaultCommandTimeout = time.Hour
+	// DefaultRestartTimeout is how long a restarted terminal has to reattach without own timeout
+	DefaultRestartTimeout = 5 * time.Minute
+	// DefaultQuietPeriod is how long the journal must not show trade activity before a restart
+	DefaultQuietPeriod = 5 * time.Minute
+)
+
+// DefaultTasks run unless the config file lists its own: the daily cleanup and weekly log rotation
+// which used to be hard-coded.
+var DefaultTasks = []Task{
+	{Name: "cleanup", Cron: "0 3 * * *", Action: ActionCleanUp},
+	{Name: "rotate-logs", Cron: "30 3 * * 0", Action: ActionRotateLogs},
+}
+
+var nameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Settings configure the tasks of the scheduler.
+type Settings struct {
+	// TimeZone applies to tasks without their own, e.g. "Europe/London" (default UTC)
+	TimeZone string `json:"timeZone,omitempty"`
+	// Tasks replace DefaultTasks; an empty list runs none
+	Tasks []Task `json:"tasks"`
+	// Windows restrict disruptive actions; without any, they run whenever due
+	Windows Windows `json:"windows,omitempty"`
+}
+
+// Task is an action run on a cron schedule.
+type Task struct {
+	Name string `json:"name"`
+	// Cron has five fields (minute hour day-of-month month day-of-week) or is a macro like @daily
+	Cron string `json:"cron,omitempty"`
+	// Every runs the task in intervals instead, as Go duration or in days like "7d"
+	Every    string `json:"every,omitempty"`
+	TimeZone string `json:"timeZone,omitempty"`
+	// Action is cleanup, rotate-logs, snapshot, restart, backup or command
+	Action string `json:"action"`
+	// Command is run by the command action as the user of the terminal
+	Command string `json:"command,omitempty"`
+	// Target is the absolute folder of the backup action
+	Target string `json:"target,omitempty"`
+	// Keep is the number of snapshots or backups kept (default 3)
+	Keep int `json:"keep,omitempty"`
+	// Timeout bounds the command action (default 1h) and the reattachment after a restart or
+	// snapshot (default 5m), as Go duration
+	Timeout string `json:"timeout,omitempty"`
+	// Quiet is how long the journal must not show trades before a restart or snapshot (default 5m)
+	Quiet string `json:"quiet,omitempty"`
+	// Disruptive overrides whether the task waits for a maintenance window (see IsDisruptive)
+	Disruptive *bool `json:"disruptive,omitempty"`
+	// Windows names the maintenance windows the task may run in (default: any)
+	Windows []string `json:"windows,omitempty"`
+}
+
+// WithDefaults fills unset fields.
+func (s Settings) WithDefaults() Settings {
+	if s.Tasks == nil {
+		s.Tasks = DefaultTasks
+	}
+	tasks := make([]Task, len(s.Tasks))
+	for i, task := range s.Tasks {
+		if len(task.TimeZone) == 0 {
+			task.TimeZone = s.TimeZone
+		}
+		if task.Keep == 0 {
+			task.Keep = 3
+		}
+		tasks[i] = task
+	}
+	s.Tasks = tasks
+	windows := make(Windows, len(s.Windows))
+	for i, window := range s.Windows {
+		if len(window.TimeZone) == 0 {
+			window.TimeZone = s.TimeZone
+		}
+		windows[i] = window
+	}
+	s.Windows = windows
+
+	return s
+}
+
+// Validate checks the settings.
+func (s Settings) Validate() error {
+	s = s.WithDefaults()
+	windows := map[string]bool{}
+	for _, window := range s.Windows {
+		if windows[window.Name] {
+			return fmt.Errorf("schedule error: window names must be unique: %q", window.Name)
+		}
+		windows[window.Name] = true
+		if err := window.Validate(); err != nil {
+			return err
+		}
+	}
+	names := map[string]bool{}
+	for _, task := range s.Tasks {
+		if !nameRegex.MatchString(task.Name) || names[task.Name] {
+			return fmt.Errorf("schedule error: task names must be unique and consist of letters, digits, '.', '_' or '-': %q", task.Name)
+		}
+		names[task.Name] = true
+		if err := task.Validate(); err != nil {
+			return err
+		}
+		for _, window := range task.Windows {
+			if !windows[window] {
+				return fmt.Errorf("schedule error: task %s refers to unknown window %q", task.Name, window)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate checks a task.
+func (t Task) Validate() error {
+	if _, err := t.Planner(); err != nil {
+		return fmt.Errorf("task %s: %w", t.Name, err)
+	}
+	switch t.Action {
+	case ActionCleanUp, ActionRotateLogs, ActionSnapshot, ActionRestart:
+	case ActionBackup:
+		if !filepath.IsAbs(t.Target) {
+			return fmt.Errorf("schedule error: task %s needs an absolute target", t.Name)
+		}
+	case ActionCommand:
+		if len(t.Command) == 0 {
+			return fmt.Errorf("schedule error: task %s needs a command", t.Name)
+		}
+	default:
+		return fmt.Errorf("schedule error: task %s has unknown action %q", t.Name, t.Action)
+	}
+	if t.Keep < 0 {
+		return fmt.Errorf("schedule error: task %s: keep must not be negative", t.Name)
+	}
+	if _, err := t.CommandTimeout(); err != nil {
+		return err
+	}
+	if _, err := t.QuietPeriod(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CommandTimeout returns the timeout of the command action.
+func (t Task) CommandTimeout() (time.Duration, error) {
+	return t.duration("timeout", t.Timeout, DefaultCommandTimeout)
+}
+
+// RestartTimeout returns how long a restarted terminal has to reattach.
+func (t Task) RestartTimeout() (time.Duration, error) {
+	return t.duration("timeout", t.Timeout, DefaultRestartTimeout)
+}
+
+// QuietPeriod returns how long the journal must not show trades before a restart.
+func (t Task) QuietPeriod() (time.Duration, error) {
+	return t.duration("quiet", t.Quiet, DefaultQuietPeriod)
+}
+
+func (t Task) duration(field, value string, def time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("schedule error: task %s: invalid %s %q", t.Name, field, value)
+	}
+
+	return duration, nil
+}
+
+// Restarts tells whether the action stops and launches the terminal.
+func (t Task) Restarts() bool {
+	return t.Action == ActionRestart || t.Action == ActionSnapshot
+}
+
+// Planner returns what plans the runs of the task: its cron expression or its interval.
+func (t Task) Planner() (Planner, error) {
+	if len(t.Every) == 0 {
+		return ParseCron(t.Cron, t.TimeZone)
+	}
+	if len(t.Cron) > 0 {
+		return nil, fmt.Errorf("schedule error: either cron or every, not both")
+	}
+
+	return ParseInterval(t.Every)
+}
+
+// IsDisruptive tells whether the task has to wait for a maintenance window. Restarts and snapshots
+// stop the terminal; a cleanup is disruptive if its rules touch history data.
+func (t Task) IsDisruptive(cleanupTouchesHistory bool) bool {
+	if t.Disruptive != nil {
+		return *t.Disruptive
+	}
+	switch t.Action {
+	case ActionRestart, ActionSnapshot:
+		return true
+	case ActionCleanUp:
+		return cleanupTouchesHistory
+	}
+
+	return false
+}
+
+// Spec identifies the schedule of a task, so a changed one is planned anew.
+func (t Task) Spec() string {
+	if len(t.Every) > 0 {
+		return "every " + t.Every
+	}
+
+	return t.Cron + " " + t.TimeZone
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window is a recurring period in which disruptive maintenance may run, e.g. the weekend close of
+// Forex markets or the daily rollover.
+type Window struct {
+	Name string `json:"name"`
+	// Start is a cron expression of when the window opens, e.g. "0 22 * * fri"
+	Start string `json:"start"`
+	// Duration is how long the window stays open, as Go duration like "48h"
+	Duration string `json:"duration"`
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Validate checks a window.
+func (w Window) Validate() error {
+	if !nameRegex.MatchString(w.Name) {
+		return fmt.Errorf("schedule error: window names must consist of letters, digits, '.', '_' or '-': %q", w.Name)
+	}
+	if _, err := ParseCron(w.Start, w.TimeZone); err != nil {
+		return fmt.Errorf("window %s: %w", w.Name, err)
+	}
+	if duration, err := time.ParseDuration(w.Duration); err != nil || duration < time.Minute {
+		return fmt.Errorf("schedule error: window %s: duration %q needs to be at least 1m", w.Name, w.Duration)
+	}
+
+	return nil
+}
+
+// OpenAt tells whether the window is open at t and, if so, when it closes.
+func (w Window) OpenAt(t time.Time) (end time.Time, open bool) {
+	cron, errCron := ParseCron(w.Start, w.TimeZone)
+	duration, errDuration := time.ParseDuration(w.Duration)
+	if errCron != nil || errDuration != nil {
+		return
+	}
+	// the latest start not longer ago than the duration
+	start := cron.Next(t.Add(-duration))
+	if start.IsZero() || start.After(t) {
+		return
+	}
+
+	return start.Add(duration), true
+}
+
+// NextOpen returns when the window opens next after t, or the zero time if never.
+func (w Window) NextOpen(t time.Time) time.Time {
+	cron, err := ParseCron(w.Start, w.TimeZone)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return cron.Next(t)
+}
+
+// Windows are the maintenance windows of the schedule.
+type Windows []Window
+
+// Allow tells whether disruptive maintenance may run at t, i.e. one of the windows named (all if
+// none) is open. Without any windows configured, it always may. Otherwise next is when the first of
+// them opens.
+func (ws Windows) Allow(t time.Time, names []string) (allowed bool, next time.Time) {
+	if len(ws) == 0 {
+		return true, time.Time{}
+	}
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	for _, window := range ws {
+		if len(wanted) > 0 && !wanted[window.Name] {
+			continue
+		}
+		if _, open := window.OpenAt(t); open {
+			return true, time.Time{}
+		}
+		if opens := window.NextOpen(t); !opens.IsZero() && (next.IsZero() || opens.Before(next)) {
+			next = opens
+		}
+	}
+
+	return
+}
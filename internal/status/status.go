@@ -0,0 +1,246 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileName = "status.json"
+	// recentEventsCap bounds the events kept in the status document
+	recentEventsCap = 20
+)
+
+// Status is the document written by the watching 'enter' process and read by 'avly -status'.
+// It must never carry credentials.
+type Status struct {
+	UpdatedAt time.Time       `json:"updatedAt"`
+	Journal   JournalStatus   `json:"journal"`
+	Broker    BrokerStatus    `json:"broker"`
+	Terminal  TerminalStatus  `json:"terminal"`
+	Display   DisplayStatus   `json:"display"`
+	VNC       VNCStatus       `json:"vnc"`
+	Prefix    PrefixStatus    `json:"prefix"`
+	Schedule  []ScheduledTask `json:"schedule,omitempty"`
+	// Deferred are disruptive actions waiting for a maintenance window
+	Deferred []DeferredAction `json:"deferred,omitempty"`
+	Restart  RestartStatus    `json:"restart"`
+}
+
+// RestartStatus is the outcome of the latest planned restart.
+type RestartStatus struct {
+	Task       string     `json:"task,omitempty"`
+	At         *time.Time `json:"at,omitempty"`
+	Screenshot string     `json:"screenshot,omitempty"`
+	// Missing is what did not come back, like the broker authorization or an expert
+	Missing     []string   `json:"missing,omitempty"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+}
+
+// DeferredAction is a disruptive action held back until a maintenance window opens.
+type DeferredAction struct {
+	Name   string    `json:"name"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	// Until is when the next maintenance window opens, if any does
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// ScheduledTask is the last and next run of a maintenance task.
+type ScheduledTask struct {
+	Name       string     `json:"name"`
+	Action     string     `json:"action"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastResult string     `json:"lastResult,omitempty"`
+	NextRun    *time.Time `json:"nextRun,omitempty"`
+}
+
+// PrefixStatus is the outcome of the latest check of the Wine prefix.
+type PrefixStatus struct {
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	// Issues are those left after repairing
+	Issues   []string `json:"issues,omitempty"`
+	Repaired []string `json:"repaired,omitempty"`
+	// LaunchFailures counts the failed launches since the last successful one
+	LaunchFailures int `json:"launchFailures,omitempty"`
+	// Leftovers are Wine processes which survived the latest stop
+	Leftovers []string `json:"leftovers,omitempty"`
+}
+
+// VNCStatus lists the connected VNC clients, as recorded in the audit log.
+type VNCStatus struct {
+	Sessions []VNCSession `json:"sessions"`
+}
+
+type VNCSession struct {
+	ID     string    `json:"id"`
+	Source string    `json:"source"`
+	Mode   string    `json:"mode"`
+	Via    string    `json:"via"`
+	Start  time.Time `json:"start"`
+}
+
+// DisplayStatus compares the geometry read from the running X server with the configured one.
+type DisplayStatus struct {
+	Actual     string `json:"actual,omitempty"`
+	Configured string `json:"configured"`
+}
+
+// TerminalStatus is the supervised state of the terminal's windows.
+type TerminalStatus struct {
+	Hung       bool       `json:"hung"`
+	Reason     string     `json:"reason,omitempty"`
+	Detail     string     `json:"detail,omitempty"`
+	HungSince  *time.Time `json:"hungSince,omitempty"`
+	MainWindow bool       `json:"mainWindow"`
+	Screenshot string     `json:"screenshot,omitempty"`
+}
+
+// BrokerStatus is the supervised broker connectivity.
+type BrokerStatus struct {
+	Healthy           bool       `json:"healthy"`
+	Connected         bool       `json:"connected"`
+	DisconnectedSince *time.Time `json:"disconnectedSince,omitempty"`
+	// Probe is "ok" or "failed" if a TCP probe to the trade server is configured
+	Probe        string     `json:"probe,omitempty"`
+	LastAction   string     `json:"lastAction,omitempty"`
+	LastActionAt *time.Time `json:"lastActionAt,omitempty"`
+}
+
+// JournalStatus is derived from the events of the terminal journal and the expert log.
+type JournalStatus struct {
+	// Connected is only meaningful if ConnectionKnown, i.e. an authorization or connection event was seen
+	Connected       bool           `json:"connected"`
+	ConnectionKnown bool           `json:"connectionKnown"`
+	AutoTrading     string         `json:"autoTrading,omitempty"`
+	LoadedExperts   []string       `json:"loadedExperts,omitempty"`
+	Counts          map[string]int `json:"counts,omitempty"`
+	// Retcodes counts failed orders by trade server return code, the only events carrying one
+	Retcodes map[int]int `json:"retcodes,omitempty"`
+	// LastTrade is the time of the latest trade or order, which may have left RecentEvents
+	LastTrade    *time.Time `json:"lastTrade,omitempty"`
+	RecentEvents []Event    `json:"recentEvents,omitempty"`
+}
+
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Source  string    `json:"source"`
+	Detail  string    `json:"detail"`
+	Retcode int       `json:"retcode,omitempty"`
+}
+
+// Store serializes updates of the status document of a process and persists them.
+type Store struct {
+	path   string
+	mu     sync.Mutex
+	status Status
+}
+
+// Path returns the location of the status document inside the runtime directory.
+func Path(runtimeDir string) string {
+	return filepath.Join(runtimeDir, fileName)
+}
+
+// NewStore starts a fresh status document; a previous one belongs to a dead process.
+func NewStore(runtimeDir string) *Store {
+	return &Store{path: Path(runtimeDir)}
+}
+
+// Update applies change and writes the document.
+func (s *Store) Update(change func(*Status)) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change(&s.status)
+	s.status.UpdatedAt = time.Now()
+	raw, err := json.MarshalIndent(s.status, "", "  ")
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, raw, 0600); err != nil {
+		return
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// Snapshot returns a copy of the current document.
+func (s *Store) Snapshot() (snapshot Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, _ := json.Marshal(s.status)
+	json.Unmarshal(raw, &snapshot)
+
+	return
+}
+
+// Load reads the document written by the watching process.
+func Load(runtimeDir string) (current Status, err error) {
+	raw, err := os.ReadFile(Path(runtimeDir))
+	if errors.Is(err, os.ErrNotExist) {
+		err = errors.New("status error: no status available, is 'enter' running?")
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(raw, &current)
+
+	return
+}
+
+// AddEvent records event in the journal section: counters, derived state and the ring of recent events.
+func (j *JournalStatus) AddEvent(event Event) {
+	if j.Counts == nil {
+		j.Counts = map[string]int{}
+	}
+	j.Counts[event.Kind]++
+	if event.Retcode > 0 {
+		if j.Retcodes == nil {
+			j.Retcodes = map[int]int{}
+		}
+		j.Retcodes[event.Retcode]++
+	}
+	j.RecentEvents = append(j.RecentEvents, event)
+	if len(j.RecentEvents) > recentEventsCap {
+		j.RecentEvents = j.RecentEvents[len(j.RecentEvents)-recentEventsCap:]
+	}
+}
+
+// Unhealthy lists the reasons why the instance is not healthy; empty means healthy.
+func (s Status) Unhealthy() (reasons []string) {
+	if !s.Broker.Healthy {
+		reason := "broker disconnected"
+		if s.Broker.DisconnectedSince != nil {
+			reason += " since " + s.Broker.DisconnectedSince.Format(time.RFC3339)
+		}
+		reasons = append(reasons, reason)
+	}
+	if s.Terminal.Hung {
+		reasons = append(reasons, "terminal hung ("+s.Terminal.Reason+")")
+	}
+	if len(s.Restart.Missing) > 0 {
+		reasons = append(reasons, "not back after planned restart: "+strings.Join(s.Restart.Missing, ", "))
+	}
+	for _, issue := range s.Prefix.Issues {
+		reasons = append(reasons, "prefix: "+issue)
+	}
+
+	return
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package vnc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+const (
+	sessionsName   = "sessions.json"
+	auditSpoolName = "vnc-audit.spool"
+	// bridgeConnsName holds a marker per connection of the web bridge, named by its local address
+	bridgeConnsName = "bridge"
+	auditName       = "vnc-audit.log"
+
+	ModeControl  = "control"
+	ModeViewOnly = "view-only"
+	ViaVNC       = "vnc"
+	ViaWeb       = "web"
+
+	EventConnect    = "connect"
+	EventDisconnect = "disconnect"
+)
+
+// Session is a connected VNC client.
+type Session struct {
+	ID     string    `json:"id"`
+	Source string    `json:"source"`
+	Mode   string    `json:"mode"`
+	Via    string    `json:"via"`
+	Start  time.Time `json:"start"`
+}
+
+// AuditRecord is a line of the audit log ($AVL_LOGS/vnc-audit.log, JSON lines). Records reach it
+// with FlushAudit.
+type AuditRecord struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	Session
+	End             *time.Time `json:"end,omitempty"`
+	DurationSeconds int64      `json:"durationSeconds,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+}
+
+// AuditPath returns the audit log of env.
+func AuditPath(env *[]string) string {
+	return filepath.Join(hlp.EnvValue(env, "AVL_LOGS"), auditName)
+}
+
+// SessionStarted records a connect and adds session to the active ones.
+func SessionStarted(env *[]string, session Session) error {
+	return withSessions(env, func(sessions map[string]Session) error {
+		sessions[session.ID] = session
+		return appendAudit(env, AuditRecord{Time: session.Start, Event: EventConnect, Session: session})
+	})
+}
+
+// SessionEnded records the disconnect of the session with id. Unknown ids are recorded without start.
+func SessionEnded(env *[]string, id string, source string, now time.Time) error {
+	return withSessions(env, func(sessions map[string]Session) error {
+		session, ok := sessions[id]
+		if !ok {
+			session = Session{ID: id, Source: source}
+		}
+		delete(sessions, id)
+		return appendAudit(env, endRecord(session, now, ""))
+	})
+}
+
+// EndAllSessions records the disconnect of all active sessions, e.g. as the VNC server was restarted.
+// The marked connections of the web bridge are forgotten with them.
+func EndAllSessions(env *[]string, now time.Time, reason string) error {
+	os.RemoveAll(filepath.Join(Dir(env), bridgeConnsName))
+
+	return withSessions(env, func(sessions map[string]Session) (err error) {
+		for id, session := range sessions {
+			if errAudit := appendAudit(env, endRecord(session, now, reason)); errAudit != nil {
+				err = errAudit
+			}
+			delete(sessions, id)
+		}
+		return
+	})
+}
+
+// ActiveSessions returns the connected clients, longest connected first.
+func ActiveSessions(env *[]string) (active []Session, err error) {
+	err = withSessions(env, func(sessions map[string]Session) error {
+		for _, session := range sessions {
+			active = append(active, session)
+		}
+		return nil
+	})
+	sort.Slice(active, func(i, j int) bool { return active[i].Start.Before(active[j].Start) })
+
+	return
+}
+
+// MarkBridgeConn records addr (ip:port) as the local end of a connection of the web bridge, so the
+// hooks of x11vnc can tell its sessions from other clients on the same host.
+func MarkBridgeConn(env *[]string, addr string) (err error) {
+	dir := filepath.Join(Dir(env), bridgeConnsName)
+	if err = makeDir(dir); err != nil {
+		return
+	}
+
+	return writePrivate(filepath.Join(dir, filepath.Base(addr)), nil)
+}
+
+// IsBridgeConn tells whether addr was marked by MarkBridgeConn.
+func IsBridgeConn(env *[]string, addr string) bool {
+	_, err := os.Lstat(filepath.Join(Dir(env), bridgeConnsName, filepath.Base(addr)))
+
+	return err == nil
+}
+
+// UnmarkBridgeConn forgets addr once its session is gone.
+func UnmarkBridgeConn(env *[]string, addr string) {
+	os.Remove(filepath.Join(Dir(env), bridgeConnsName, filepath.Base(addr)))
+}
+
+func endRecord(session Session, now time.Time, reason string) AuditRecord {
+	record := AuditRecord{Time: now, Event: EventDisconnect, Session: session, End: &now, Reason: reason}
+	if !session.Start.IsZero() {
+		record.DurationSeconds = int64(now.Sub(session.Start).Seconds())
+	}
+
+	return record
+}
+
+// withSessions runs change on the persisted active sessions under an exclusive lock, as x11vnc
+// runs a hook process per client.
+func withSessions(env *[]string, change func(map[string]Session) error) error {
+	return withLock(env, func() (err error) {
+		path := filepath.Join(Dir(env), sessionsName)
+		sessions := map[string]Session{}
+		raw, err := readPrivate(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		if len(raw) > 0 {
+			json.Unmarshal(raw, &sessions)
+		}
+		if err = change(sessions); err != nil {
+			return
+		}
+		raw, err = json.Marshal(sessions)
+		if err != nil {
+			return
+		}
+
+		return writePrivate(path, raw)
+	})
+}
+
+// withLock runs fn under the exclusive lock of the sessions and the audit spool.
+func withLock(env *[]string, fn func() error) (err error) {
+	if err = makeDir(Dir(env)); err != nil {
+		return
+	}
+	path := filepath.Join(Dir(env), sessionsName+".lock")
+	lock, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return
+	}
+	defer lock.Close()
+	if err = hlp.Adopt(path); err != nil {
+		return
+	}
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// appendAudit spools record in Dir, as the hooks of x11vnc run as its user and may not write into
+// the logs; FlushAudit moves it into the audit log.
+func appendAudit(env *[]string, record AuditRecord) (err error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(Dir(env), auditSpoolName)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if err = hlp.Adopt(path); err != nil {
+		return
+	}
+	_, err = file.Write(append(raw, '\n'))
+
+	return
+}
+
+// FlushAudit appends the spooled records to the audit log and returns them. Lines which are no
+// record, as only the user of the VNC server could have written them, are dropped.
+func FlushAudit(env *[]string) (flushed []AuditRecord, err error) {
+	err = withLock(env, func() (err error) {
+		path := filepath.Join(Dir(env), auditSpoolName)
+		raw, err := readPrivate(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return
+		}
+		var lines []byte
+		for _, line := range bytes.Split(raw, []byte("\n")) {
+			var record AuditRecord
+			if json.Unmarshal(line, &record) != nil {
+				continue
+			}
+			flushed = append(flushed, record)
+			line, _ = json.Marshal(record)
+			lines = append(append(lines, line...), '\n')
+		}
+		if len(lines) > 0 {
+			var file *os.File
+			if file, err = os.OpenFile(AuditPath(env), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640); err != nil {
+				return
+			}
+			_, err = file.Write(lines)
+			file.Close()
+			if err != nil {
+				return
+			}
+		}
+
+		return os.Remove(path)
+	})
+
+	return
+}
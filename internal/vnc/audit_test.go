@@ -0,0 +1,83 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package vnc
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSessionAudit(t *testing.T) {
+	env := []string{"AVL_RUNTIME=" + t.TempDir(), "AVL_LOGS=" + t.TempDir()}
+	start := time.Date(2022, 10, 3, 9, 0, 0, 0, time.UTC)
+
+	SessionStarted(&env, Session{ID: "0x1", Source: "10.0.0.5", Mode: ModeControl, Via: ViaVNC, Start: start})
+	SessionStarted(&env, Session{ID: "web-1", Source: "10.0.0.6", Mode: ModeViewOnly, Via: ViaWeb, Start: start})
+	if active, _ := ActiveSessions(&env); len(active) != 2 {
+		t.Fatalf("len(active): Expected '%d' to be '%d'", len(active), 2)
+	}
+	SessionEnded(&env, "0x1", "10.0.0.5", start.Add(90*time.Second))
+	EndAllSessions(&env, start.Add(2*time.Minute), "server restarted")
+	if active, _ := ActiveSessions(&env); len(active) != 0 {
+		t.Errorf("len(active): Expected '%d' to be '%d'", len(active), 0)
+	}
+
+	if flushed, err := FlushAudit(&env); err != nil || len(flushed) != 4 {
+		t.Fatalf("Expected 4 records flushed, got '%v' and '%v'", flushed, err)
+	}
+	if flushed, _ := FlushAudit(&env); len(flushed) != 0 {
+		t.Errorf("Expected '%v' to be flushed once", flushed)
+	}
+	file, err := os.Open(AuditPath(&env))
+	if err != nil {
+		t.Fatalf("Expected audit log, got '%s'", err.Error())
+	}
+	defer file.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record AuditRecord
+		json.Unmarshal(scanner.Bytes(), &record)
+		records = append(records, record)
+	}
+	if len(records) != 4 {
+		t.Fatalf("len(records): Expected '%d' to be '%d'", len(records), 4)
+	}
+	if ended := records[2]; ended.Event != EventDisconnect || ended.ID != "0x1" || ended.DurationSeconds != 90 || ended.Mode != ModeControl {
+		t.Errorf("Expected '%+v' to be the disconnect of 0x1 after 90 seconds", ended)
+	}
+	if ended := records[3]; ended.ID != "web-1" || ended.Reason != "server restarted" {
+		t.Errorf("Expected '%+v' to end web-1 as the server restarted", ended)
+	}
+}
+
+func TestBridgeConns(t *testing.T) {
+	env := []string{"AVL_RUNTIME=" + t.TempDir(), "AVL_LOGS=" + t.TempDir()}
+
+	if err := MarkBridgeConn(&env, "127.0.0.1:41234"); err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	// an SSH tunnel connects from the same host, but not from the same port
+	if IsBridgeConn(&env, "127.0.0.1:41235") {
+		t.Errorf("Expected 127.0.0.1:41235 not to be a bridge connection")
+	}
+	if !IsBridgeConn(&env, "127.0.0.1:41234") {
+		t.Errorf("Expected 127.0.0.1:41234 to be a bridge connection")
+	}
+	UnmarkBridgeConn(&env, "127.0.0.1:41234")
+	if IsBridgeConn(&env, "127.0.0.1:41234") {
+		t.Errorf("Expected 127.0.0.1:41234 to be forgotten")
+	}
+	MarkBridgeConn(&env, "127.0.0.1:41236")
+	EndAllSessions(&env, time.Now(), "server restarted")
+	if IsBridgeConn(&env, "127.0.0.1:41236") {
+		t.Errorf("Expected 127.0.0.1:41236 to be forgotten with the restart")
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package vnc
+
+import (
+	"crypto/des"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	hlp "github.com/9tmark/avly-trader/internal/helpers"
+)
+
+const (
+	defaultSecretsDir = "/run/secrets"
+	rfbAuthName       = "vnc-passwd"
+	otpName           = "vnc-otp"
+	otpStateName      = "vnc-otp.json"
+	bridgeStateName   = "vnc-bridge.json"
+	// passwordLength is all the RFB protocol looks at
+	passwordLength = 8
+	otpAlphabet    = "abcdefghijkmnpqrstuvwxyzACDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// DefaultOTPTTL is the lifetime of a one-time password unless AVL_VNC_OTP_TTL says otherwise
+	DefaultOTPTTL = 10 * time.Minute
+)
+
+// obfuscationKey is the fixed key of VNC password files (d3des key 23,82,107,6,35,78,88,7 with
+// each byte's bits reversed, as crypto/des expects them).
+var obfuscationKey = []byte{0xe8, 0x4a, 0xd6, 0x60, 0xc4, 0x72, 0x1a, 0xe0}
+
+// OTP is an issued one-time password. It admits a single connection until it expires.
+type OTP struct {
+	Password  string    `json:"-"`
+	ViewOnly  bool      `json:"viewOnly"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// otpState is a password of the one-time password file as kept in Dir: the outstanding one-time
+// password or the one of the web bridge.
+type otpState struct {
+	Password  string    `json:"password"`
+	ViewOnly  bool      `json:"viewOnly"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// Dir returns the folder inside the runtime dir holding password files and the sessions. It belongs
+// to the user of the VNC server, which reads the former and whose hooks record the latter.
+func Dir(env *[]string) string {
+	return filepath.Join(hlp.EnvValue(env, "AVL_RUNTIME"), "vnc")
+}
+
+// LoadPassword reads the VNC password from AVL_VNC_PASSWORD_FILE or $AVL_SECRETS_DIR/vnc_password.
+// found is false if there is none.
+func LoadPassword(env *[]string) (password string, found bool, err error) {
+	path := hlp.EnvValue(env, "AVL_VNC_PASSWORD_FILE")
+	explicit := len(path) > 0
+	if !explicit {
+		dir := hlp.EnvValue(env, "AVL_SECRETS_DIR")
+		if len(dir) == 0 {
+			dir = defaultSecretsDir
+		}
+		path = filepath.Join(dir, "vnc_password")
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("vnc error: could not read password secret %s: %w", path, err)
+	}
+	password = strings.TrimSpace(string(raw))
+	if len(password) == 0 {
+		return "", false, fmt.Errorf("vnc error: password secret %s is empty", path)
+	}
+
+	return password, true, nil
+}
+
+// EncryptPassword returns the content of a VNC password file (-rfbauth) for password.
+func EncryptPassword(password string) []byte {
+	block, _ := des.NewCipher(obfuscationKey)
+	plain := make([]byte, passwordLength)
+	copy(plain, password)
+	encrypted := make([]byte, passwordLength)
+	block.Encrypt(encrypted, plain)
+
+	return encrypted
+}
+
+// DecryptPassword reverses EncryptPassword.
+func DecryptPassword(encrypted []byte) string {
+	block, _ := des.NewCipher(obfuscationKey)
+	plain := make([]byte, passwordLength)
+	block.Decrypt(plain, encrypted[:passwordLength])
+
+	return strings.TrimRight(string(plain), "\x00")
+}
+
+// WriteRfbAuth writes the password file x11vnc reads with -rfbauth, readable by the owner only.
+func WriteRfbAuth(env *[]string, password string) (path string, err error) {
+	if err = makeDir(Dir(env)); err != nil {
+		return
+	}
+	path = filepath.Join(Dir(env), rfbAuthName)
+	err = writePrivate(path, EncryptPassword(password))
+
+	return
+}
+
+// OTPPath returns the password file x11vnc rereads with -passwdfile read:.
+func OTPPath(env *[]string) string {
+	return filepath.Join(Dir(env), otpName)
+}
+
+// IssueOTP creates a one-time password valid for ttl, revoking a previous one.
+func IssueOTP(env *[]string, viewOnly bool, ttl time.Duration) (otp OTP, err error) {
+	if otp.Password, err = randomPassword(); err != nil {
+		return
+	}
+	otp.ViewOnly, otp.ExpiresAt = viewOnly, time.Now().Add(ttl).Truncate(time.Second)
+	if err = makeDir(Dir(env)); err != nil {
+		return
+	}
+	if err = writeState(env, otpStateName, otpState(otp)); err != nil {
+		return
+	}
+	err = writeOTPFile(env)
+
+	return
+}
+
+// RevokeOTP invalidates the outstanding one-time password, e.g. after it was used.
+func RevokeOTP(env *[]string) (err error) {
+	os.Remove(filepath.Join(Dir(env), otpStateName))
+	if err = makeDir(Dir(env)); err != nil {
+		return
+	}
+
+	return writeOTPFile(env)
+}
+
+// ExpireOTP revokes the outstanding one-time password once it expired. revoked tells whether it did.
+func ExpireOTP(env *[]string, now time.Time) (revoked bool, err error) {
+	otp, found, err := readState(env, otpStateName)
+	if err != nil || !found || now.Before(otp.ExpiresAt) {
+		return false, err
+	}
+
+	return true, RevokeOTP(env)
+}
+
+// BridgePassword returns the password the web bridge logs in with if only one-time passwords are
+// admitted. It has a line of its own in the password file, so the sessions of the bridge leave the
+// one-time password alone; it never leaves the runtime dir.
+func BridgePassword(env *[]string, viewOnly bool) (password string, err error) {
+	bridge, found, err := readState(env, bridgeStateName)
+	if err != nil || (found && bridge.ViewOnly == viewOnly) {
+		return bridge.Password, err
+	}
+	if bridge.Password, err = randomPassword(); err != nil {
+		return
+	}
+	bridge.ViewOnly = viewOnly
+	if err = makeDir(Dir(env)); err != nil {
+		return
+	}
+	if err = writeState(env, bridgeStateName, bridge); err != nil {
+		return
+	}
+
+	return bridge.Password, writeOTPFile(env)
+}
+
+// writeOTPFile writes the outstanding one-time password and the one of the bridge to the file
+// x11vnc rereads per connection, view-only ones after its marker.
+func writeOTPFile(env *[]string) error {
+	// x11vnc must never find an empty file, it would not ask for a password at all
+	locked, err := randomPassword()
+	if err != nil {
+		return err
+	}
+	control, viewOnly := []string{locked}, []string{}
+	for _, name := range []string{otpStateName, bridgeStateName} {
+		state, found, err := readState(env, name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !found:
+		case state.ViewOnly:
+			viewOnly = append(viewOnly, state.Password)
+		default:
+			control = append(control, state.Password)
+		}
+	}
+	content := strings.Join(control, "\n") + "\n"
+	if len(viewOnly) > 0 {
+		content += "__BEGIN_VIEWONLY__\n" + strings.Join(viewOnly, "\n") + "\n"
+	}
+
+	return writePrivate(OTPPath(env), []byte(content))
+}
+
+func readState(env *[]string, name string) (state otpState, found bool, err error) {
+	raw, err := readPrivate(filepath.Join(Dir(env), name))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, false, nil
+	}
+	if err != nil {
+		return
+	}
+	// a damaged state is as good as none
+	found = json.Unmarshal(raw, &state) == nil && len(state.Password) > 0
+
+	return
+}
+
+func writeState(env *[]string, name string, state otpState) error {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return writePrivate(filepath.Join(Dir(env), name), raw)
+}
+
+func randomPassword() (string, error) {
+	password := make([]byte, passwordLength)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(otpAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		password[i] = otpAlphabet[n.Int64()]
+	}
+
+	return string(password), nil
+}
+
+// writePrivate replaces path atomically with content readable by the owner only. Dir belongs to the
+// user of the VNC server, so a link at path is replaced rather than followed and the file is given
+// to that user.
+func writePrivate(path string, content []byte) (err error) {
+	tmp := path + ".tmp"
+	if err = hlp.WriteFresh(tmp, content, 0600); err != nil {
+		return
+	}
+	if err = hlp.Adopt(tmp); err != nil {
+		return
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readPrivate reads path unless it is a link, see writePrivate.
+func readPrivate(path string) ([]byte, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// makeDir creates dir for the files of the VNC server, given to the owner of its parent.
+func makeDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	return hlp.Adopt(dir)
+}
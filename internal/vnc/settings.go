@@ -0,0 +1,214 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package vnc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPort = 5900
+	// ListenLocalhost only accepts connections from inside the container, e.g. through an SSH tunnel
+	ListenLocalhost = "localhost"
+	// ListenAll accepts connections on every interface, as needed for a published container port
+	ListenAll = "all"
+)
+
+// Settings configure access to the VNC server.
+type Settings struct {
+	// Listen is "localhost", "all", an IP address or an interface name. Defaults to "all" if a
+	// password is available and to "localhost" otherwise.
+	Listen string `json:"listen,omitempty"`
+	Port   int    `json:"port,omitempty"`
+	// ViewOnly disallows keyboard and mouse input of all clients
+	ViewOnly bool `json:"viewOnly,omitempty"`
+	// Allow lists the addresses (IPs, prefixes like "192.168.1." or CIDRs on octet boundaries) which may connect
+	Allow []string `json:"allow,omitempty"`
+	// OTP only admits one-time passwords created by 'avly -vnc-otp' instead of the password secret
+	OTP bool `json:"otp,omitempty"`
+	// ForceInsecure allows listening on other interfaces than loopback without any password
+	ForceInsecure bool `json:"forceInsecure,omitempty"`
+	// Web configures browser access through the control API
+	Web WebSettings `json:"web"`
+}
+
+// WebSettings configure the RFB-over-WebSocket bridge of the control API.
+type WebSettings struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// ViewOnly drops keyboard and mouse input of browsers, independent of ViewOnly of the VNC server
+	ViewOnly bool `json:"viewOnly,omitempty"`
+}
+
+var allowPrefixRegex = regexp.MustCompile(`^(\d{1,3}\.){1,3}$`)
+
+// Validate checks the settings which do not depend on the environment.
+func (s Settings) Validate() error {
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("vnc error: invalid port %d", s.Port)
+	}
+	if _, err := s.allowList(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WithDefaults fills unset fields. hasPassword tells whether a password secret is available.
+func (s Settings) WithDefaults(hasPassword bool) Settings {
+	if s.Port == 0 {
+		s.Port = DefaultPort
+	}
+	if len(s.Listen) == 0 {
+		s.Listen = ListenLocalhost
+		if hasPassword || s.OTP {
+			s.Listen = ListenAll
+		}
+	}
+
+	return s
+}
+
+// ListenAddr resolves Listen into the address passed to x11vnc; empty means all interfaces.
+func (s Settings) ListenAddr() (addr string, err error) {
+	switch s.Listen {
+	case "", ListenAll:
+		return "", nil
+	case ListenLocalhost:
+		return "127.0.0.1", nil
+	}
+	if ip := net.ParseIP(s.Listen); ip != nil {
+		return ip.String(), nil
+	}
+	iface, err := net.InterfaceByName(s.Listen)
+	if err != nil {
+		return "", fmt.Errorf("vnc error: %s is neither an address nor an interface", s.Listen)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("vnc error: interface %s has no IPv4 address", s.Listen)
+}
+
+// BridgeAddr returns the address the web bridge connects to.
+func (s Settings) BridgeAddr() (addr string, err error) {
+	host, err := s.ListenAddr()
+	if len(host) == 0 {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(s.Port)), err
+}
+
+// IsLocal tells whether the server is only reachable from inside the container.
+func (s Settings) IsLocal() bool {
+	addr, err := s.ListenAddr()
+	if err != nil || len(addr) == 0 {
+		return false
+	}
+
+	return net.ParseIP(addr).IsLoopback()
+}
+
+// allowList translates Allow into the host list of x11vnc's -allow, which only knows IPs and prefixes.
+func (s Settings) allowList() (hosts []string, err error) {
+	for _, entry := range s.Allow {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case net.ParseIP(entry) != nil, allowPrefixRegex.MatchString(entry):
+			hosts = append(hosts, entry)
+		case strings.Contains(entry, "/"):
+			ip, ipNet, errParse := net.ParseCIDR(entry)
+			if errParse != nil || ip.To4() == nil {
+				return nil, fmt.Errorf("vnc error: invalid allow entry %q", entry)
+			}
+			ones, _ := ipNet.Mask.Size()
+			if ones%8 != 0 || ones == 0 {
+				return nil, fmt.Errorf("vnc error: allow entry %q needs a mask of 8, 16, 24 or 32 bits", entry)
+			}
+			octets := strings.Split(ipNet.IP.String(), ".")
+			if ones == 32 {
+				hosts = append(hosts, ipNet.IP.String())
+			} else {
+				hosts = append(hosts, strings.Join(octets[:ones/8], ".")+".")
+			}
+		default:
+			return nil, fmt.Errorf("vnc error: invalid allow entry %q", entry)
+		}
+	}
+
+	return
+}
+
+// Auth is how clients authenticate, as resolved from settings and secrets.
+type Auth struct {
+	// RfbAuthPath is the password file of the password secret
+	RfbAuthPath string
+	// PasswdPath is the one-time password file, reread by x11vnc for each connection
+	PasswdPath string
+	// HookCmd is run by x11vnc after a client was accepted and after it disconnected
+	HookCmd string
+}
+
+// Args returns the access related arguments of x11vnc. The combination of no password and a
+// non-local listener is refused unless ForceInsecure is set.
+func (s Settings) Args(auth Auth) (args []string, err error) {
+	addr, err := s.ListenAddr()
+	if err != nil {
+		return
+	}
+	args = append(args, "-rfbport", strconv.Itoa(s.Port))
+	if len(addr) > 0 {
+		args = append(args, "-listen", addr)
+	}
+
+	switch {
+	case s.OTP:
+		if len(auth.PasswdPath) == 0 {
+			return nil, errors.New("vnc error: one-time passwords need a password file")
+		}
+		args = append(args, "-passwdfile", "read:"+auth.PasswdPath)
+	case len(auth.RfbAuthPath) > 0:
+		args = append(args, "-rfbauth", auth.RfbAuthPath)
+	case s.IsLocal() || s.ForceInsecure:
+		args = append(args, "-nopw")
+	default:
+		return nil, fmt.Errorf("vnc error: refusing to listen on %s without password, provide the vnc_password secret, use one-time passwords or listen on localhost", s.Listen)
+	}
+
+	if s.ViewOnly {
+		args = append(args, "-viewonly")
+	}
+	hosts, err := s.allowList()
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) > 0 {
+		if s.Web.Enabled {
+			bridge, _ := s.BridgeAddr()
+			host, _, _ := net.SplitHostPort(bridge)
+			hosts = append(hosts, host)
+		}
+		args = append(args, "-allow", strings.Join(hosts, ","))
+	}
+	if len(auth.HookCmd) > 0 {
+		args = append(args, "-afteraccept", auth.HookCmd, "-gone", auth.HookCmd)
+	}
+
+	return
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package vnc
+
+import (
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncryptPassword(t *testing.T) {
+	// as written by vncpasswd
+	if encrypted := hex.EncodeToString(EncryptPassword("password")); encrypted != "dbd83cfd727a1458" {
+		t.Errorf("Expected '%s' to be '%s'", encrypted, "dbd83cfd727a1458")
+	}
+	if decrypted := DecryptPassword(EncryptPassword("secret")); decrypted != "secret" {
+		t.Errorf("Expected '%s' to be '%s'", decrypted, "secret")
+	}
+}
+
+func TestArgsRefusesInsecureBind(t *testing.T) {
+	if _, err := (Settings{Listen: ListenAll}).WithDefaults(false).Args(Auth{}); err == nil {
+		t.Errorf("Expected listening on all interfaces without password to be refused")
+	}
+	if _, err := (Settings{Listen: ListenAll, ForceInsecure: true}).WithDefaults(false).Args(Auth{}); err != nil {
+		t.Errorf("Expected forced insecure bind to be allowed, got '%s'", err.Error())
+	}
+
+	args, err := Settings{}.WithDefaults(false).Args(Auth{})
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	if joined := strings.Join(args, " "); joined != "-rfbport 5900 -listen 127.0.0.1 -nopw" {
+		t.Errorf("Expected default without password to listen on localhost, got '%s'", joined)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	settings := Settings{ViewOnly: true, Allow: []string{"10.0.0.0/8", "192.168.1.", "172.16.5.4/32"}}.WithDefaults(true)
+
+	args, err := settings.Args(Auth{RfbAuthPath: "/dev/shm/vnc/passwd", HookCmd: "avly -vnc-hook"})
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	expected := "-rfbport 5900 -rfbauth /dev/shm/vnc/passwd -viewonly -allow 10.,192.168.1.,172.16.5.4 -afteraccept avly -vnc-hook -gone avly -vnc-hook"
+	if joined := strings.Join(args, " "); joined != expected {
+		t.Errorf("Expected '%s' to be '%s'", joined, expected)
+	}
+
+	if err := (Settings{Allow: []string{"10.1.0.0/12"}}).Validate(); err == nil {
+		t.Errorf("Expected mask off octet boundaries to be rejected")
+	}
+}
+
+func TestOTPLifecycle(t *testing.T) {
+	env := []string{"AVL_RUNTIME=" + t.TempDir()}
+
+	otp, err := IssueOTP(&env, true, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	raw, _ := os.ReadFile(OTPPath(&env))
+	if !strings.HasSuffix(string(raw), "__BEGIN_VIEWONLY__\n"+otp.Password+"\n") {
+		t.Errorf("Expected view-only password in '%s'", string(raw))
+	}
+	if revoked, _ := ExpireOTP(&env, time.Now()); revoked {
+		t.Errorf("Expected fresh password not to be revoked")
+	}
+	if revoked, _ := ExpireOTP(&env, time.Now().Add(2*time.Minute)); !revoked {
+		t.Errorf("Expected expired password to be revoked")
+	}
+	raw, _ = os.ReadFile(OTPPath(&env))
+	if strings.Contains(string(raw), otp.Password) || len(strings.TrimSpace(string(raw))) == 0 {
+		t.Errorf("Expected password file to be locked, got '%s'", string(raw))
+	}
+}
+
+func TestBridgePasswordOutlivesOTP(t *testing.T) {
+	env := []string{"AVL_RUNTIME=" + t.TempDir()}
+
+	otp, _ := IssueOTP(&env, false, time.Minute)
+	bridge, err := BridgePassword(&env, true)
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	raw, _ := os.ReadFile(OTPPath(&env))
+	if control, viewOnly, _ := strings.Cut(string(raw), "__BEGIN_VIEWONLY__\n"); !strings.Contains(control, otp.Password+"\n") || viewOnly != bridge+"\n" {
+		t.Errorf("Expected control password '%s' and view-only password '%s' in '%s'", otp.Password, bridge, string(raw))
+	}
+	if again, _ := BridgePassword(&env, true); again != bridge {
+		t.Errorf("Expected '%s' to be '%s'", again, bridge)
+	}
+
+	RevokeOTP(&env)
+	raw, _ = os.ReadFile(OTPPath(&env))
+	if strings.Contains(string(raw), otp.Password) || !strings.Contains(string(raw), bridge) {
+		t.Errorf("Expected only the password of the bridge to be left in '%s'", string(raw))
+	}
+}
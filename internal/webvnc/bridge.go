@@ -0,0 +1,123 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package webvnc
+
+import (
+	"embed"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"time"
+)
+
+//go:embed static
+var static embed.FS
+
+// Bridge proxies RFB over WebSocket (as websockify does for noVNC) to the local VNC server. It
+// authenticates against the VNC server itself, so browsers see a session without security; they
+// must be authenticated before they reach the bridge.
+type Bridge struct {
+	// Addr is host:port of the VNC server
+	Addr string
+	// Password returns the password for the VNC server for each new session; empty means none
+	Password func(viewOnly bool) (string, error)
+	// ViewOnly drops keyboard, pointer and clipboard input of browsers
+	ViewOnly bool
+	// Logf reports failed sessions
+	Logf func(msg string, a ...any)
+	// Mark is told the local address of each connection to the VNC server before it logs in, so the
+	// server can tell sessions of the bridge; unmark is called if the login failed
+	Mark func(local string) (unmark func())
+	// Audit is told about each established session by the browser's address; it returns the
+	// function called when the session ended
+	Audit func(source string, viewOnly bool) (ended func())
+}
+
+// Client returns the bundled web client.
+func Client() http.Handler {
+	root, _ := fs.Sub(static, "static")
+
+	return http.FileServer(http.FS(root))
+}
+
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	password, err := b.Password(b.ViewOnly)
+	if err != nil {
+		b.logf("web VNC: %s", err.Error())
+		return
+	}
+	server, err := net.DialTimeout("tcp", b.Addr, 10*time.Second)
+	if err != nil {
+		b.logf("web VNC: %s", err.Error())
+		return
+	}
+	defer server.Close()
+	unmark := func() {}
+	if b.Mark != nil {
+		unmark = b.Mark(server.LocalAddr().String())
+	}
+	server.SetDeadline(time.Now().Add(30 * time.Second))
+	if err = authenticateServer(server, password); err != nil {
+		unmark()
+		b.logf("web VNC: %s", err.Error())
+		return
+	}
+	server.SetDeadline(time.Time{})
+	if err = acceptClient(ws); err != nil {
+		b.logf("web VNC: %s", err.Error())
+		return
+	}
+	if b.Audit != nil {
+		source, _, _ := net.SplitHostPort(r.RemoteAddr)
+		defer b.Audit(source, b.ViewOnly)()
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		copyBuffered(ws, server)
+		done <- struct{}{}
+	}()
+	go func() {
+		if b.ViewOnly {
+			copyViewOnly(server, ws)
+		} else {
+			io.Copy(server, ws)
+		}
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// copyBuffered forwards everything read at once as a single message, keeping the number of
+// WebSocket frames low.
+func copyBuffered(dst io.Writer, src io.Reader) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, errWrite := dst.Write(buf[:n]); errWrite != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *Bridge) logf(msg string, a ...any) {
+	if b.Logf != nil {
+		b.Logf(msg, a...)
+	}
+}
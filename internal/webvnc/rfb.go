@@ -0,0 +1,203 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package webvnc
+
+import (
+	"bufio"
+	"crypto/des"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	rfbVersion      = "RFB 003.008\n"
+	securityNone    = 1
+	securityVNCAuth = 2
+
+	msgSetPixelFormat  = 0
+	msgSetEncodings    = 2
+	msgUpdateRequest   = 3
+	msgKeyEvent        = 4
+	msgPointerEvent    = 5
+	msgClientCutText   = 6
+	maxClientCutLength = 1 << 20
+)
+
+// authenticateServer runs the RFB 3.8 handshake with the VNC server up to a successful security
+// result, answering VNC authentication with password.
+func authenticateServer(conn io.ReadWriter, password string) (err error) {
+	version := make([]byte, len(rfbVersion))
+	if _, err = io.ReadFull(conn, version); err != nil {
+		return
+	}
+	if string(version[:4]) != "RFB " || string(version) < "RFB 003.007\n" {
+		return fmt.Errorf("rfb error: unsupported server version %q", version)
+	}
+	if _, err = conn.Write([]byte(rfbVersion)); err != nil {
+		return
+	}
+
+	var count [1]byte
+	if _, err = io.ReadFull(conn, count[:]); err != nil {
+		return
+	}
+	if count[0] == 0 {
+		return readFailure(conn)
+	}
+	types := make([]byte, count[0])
+	if _, err = io.ReadFull(conn, types); err != nil {
+		return
+	}
+	chosen := byte(0)
+	for _, securityType := range types {
+		if securityType == securityVNCAuth && len(password) > 0 {
+			chosen = securityVNCAuth
+			break
+		}
+		if securityType == securityNone {
+			chosen = securityNone
+		}
+	}
+	if chosen == 0 {
+		return fmt.Errorf("rfb error: no common security type in %v", types)
+	}
+	if _, err = conn.Write([]byte{chosen}); err != nil {
+		return
+	}
+	if chosen == securityVNCAuth {
+		challenge := make([]byte, 16)
+		if _, err = io.ReadFull(conn, challenge); err != nil {
+			return
+		}
+		if _, err = conn.Write(vncAuthResponse(password, challenge)); err != nil {
+			return
+		}
+	}
+
+	var result [4]byte
+	if _, err = io.ReadFull(conn, result[:]); err != nil {
+		return
+	}
+	if binary.BigEndian.Uint32(result[:]) != 0 {
+		return readFailure(conn)
+	}
+
+	return
+}
+
+// acceptClient runs the RFB 3.8 handshake with the browser, offering no security: the browser was
+// authenticated by token before.
+func acceptClient(conn io.ReadWriter) (err error) {
+	if _, err = conn.Write([]byte(rfbVersion)); err != nil {
+		return
+	}
+	version := make([]byte, len(rfbVersion))
+	if _, err = io.ReadFull(conn, version); err != nil {
+		return
+	}
+	if string(version) != rfbVersion {
+		return fmt.Errorf("rfb error: unsupported client version %q", version)
+	}
+	if _, err = conn.Write([]byte{1, securityNone}); err != nil {
+		return
+	}
+	var chosen [1]byte
+	if _, err = io.ReadFull(conn, chosen[:]); err != nil {
+		return
+	}
+	if chosen[0] != securityNone {
+		return fmt.Errorf("rfb error: client chose security type %d", chosen[0])
+	}
+	_, err = conn.Write([]byte{0, 0, 0, 0})
+
+	return
+}
+
+// vncAuthResponse encrypts the challenge with the password as DES key, each byte's bits reversed.
+func vncAuthResponse(password string, challenge []byte) []byte {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key {
+		var reversed byte
+		for bit := 0; bit < 8; bit++ {
+			reversed = reversed<<1 | (b>>bit)&1
+		}
+		key[i] = reversed
+	}
+	block, _ := des.NewCipher(key)
+	response := make([]byte, len(challenge))
+	for i := 0; i+8 <= len(challenge); i += 8 {
+		block.Encrypt(response[i:i+8], challenge[i:i+8])
+	}
+
+	return response
+}
+
+func readFailure(conn io.Reader) error {
+	var length [4]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return errors.New("rfb error: server refused the connection")
+	}
+	reason := make([]byte, binary.BigEndian.Uint32(length[:])%1024)
+	io.ReadFull(conn, reason)
+
+	return fmt.Errorf("rfb error: server refused the connection: %s", reason)
+}
+
+// copyViewOnly forwards the client messages of src to dst, dropping keyboard, pointer and
+// clipboard input. Unknown messages end the session, as their length is unknown.
+func copyViewOnly(dst io.Writer, src io.Reader) (err error) {
+	in := bufio.NewReader(src)
+	for {
+		var msgType byte
+		if msgType, err = in.ReadByte(); err != nil {
+			return
+		}
+		var length int
+		switch msgType {
+		case msgSetPixelFormat:
+			length = 20
+		case msgUpdateRequest:
+			length = 10
+		case msgKeyEvent:
+			length = 8
+		case msgPointerEvent:
+			length = 6
+		case msgSetEncodings:
+			head, errPeek := in.Peek(3)
+			if errPeek != nil {
+				return errPeek
+			}
+			length = 4 + 4*int(binary.BigEndian.Uint16(head[1:3]))
+		case msgClientCutText:
+			head, errPeek := in.Peek(7)
+			if errPeek != nil {
+				return errPeek
+			}
+			textLength := binary.BigEndian.Uint32(head[3:7])
+			if textLength > maxClientCutLength {
+				return errors.New("rfb error: clipboard text too large")
+			}
+			length = 8 + int(textLength)
+		default:
+			return fmt.Errorf("rfb error: unsupported client message %d", msgType)
+		}
+		msg := make([]byte, length)
+		msg[0] = msgType
+		if _, err = io.ReadFull(in, msg[1:]); err != nil {
+			return
+		}
+		if msgType == msgKeyEvent || msgType == msgPointerEvent || msgType == msgClientCutText {
+			continue
+		}
+		if _, err = dst.Write(msg); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Minimal RFB 3.8 client for the avly bridge: no security (the bridge authenticates), 32 bit true
+// color with Raw, CopyRect and DesktopSize encodings.
+(function () {
+  "use strict";
+
+  var canvas = document.getElementById("screen");
+  var ctx = canvas.getContext("2d");
+  var stateLabel = document.getElementById("state");
+
+  var token = new URLSearchParams(location.hash.slice(1)).get("token") || "";
+  var url = new URL("websockify", location.href);
+  url.protocol = location.protocol === "https:" ? "wss:" : "ws:";
+  url.search = "token=" + encodeURIComponent(token);
+  url.hash = "";
+
+  var ws = new WebSocket(url.toString(), ["binary"]);
+  ws.binaryType = "arraybuffer";
+
+  var buf = new Uint8Array(0);
+  var pos = 0;
+  var state = "version";
+  var width = 0, height = 0;
+  var rectsLeft = 0, rect = null;
+  var buttons = 0;
+
+  function setState(text) { stateLabel.textContent = text; }
+
+  function available() { return buf.length - pos; }
+
+  function u8() { return buf[pos++]; }
+
+  function u16() { var v = (buf[pos] << 8) | buf[pos + 1]; pos += 2; return v; }
+
+  function u32() { var v = ((buf[pos] << 24) >>> 0) + ((buf[pos + 1] << 16) | (buf[pos + 2] << 8) | buf[pos + 3]); pos += 4; return v; }
+
+  function s32() { return u32() | 0; }
+
+  function bytes(n) { var v = buf.subarray(pos, pos + n); pos += n; return v; }
+
+  function send(arr) { if (ws.readyState === WebSocket.OPEN) { ws.send(new Uint8Array(arr)); } }
+
+  function be16(v) { return [(v >> 8) & 0xff, v & 0xff]; }
+
+  function be32(v) { return [(v >>> 24) & 0xff, (v >> 16) & 0xff, (v >> 8) & 0xff, v & 0xff]; }
+
+  function requestUpdate(incremental) {
+    send([3, incremental ? 1 : 0].concat(be16(0), be16(0), be16(width), be16(height)));
+  }
+
+  function resize(w, h) {
+    width = w; height = h;
+    canvas.width = w; canvas.height = h;
+  }
+
+  ws.onopen = function () { setState("handshake..."); };
+  ws.onclose = function () { setState("disconnected"); };
+  ws.onerror = function () { setState("connection failed"); };
+  ws.onmessage = function (e) {
+    var chunk = new Uint8Array(e.data);
+    var rest = buf.subarray(pos);
+    var joined = new Uint8Array(rest.length + chunk.length);
+    joined.set(rest, 0);
+    joined.set(chunk, rest.length);
+    buf = joined; pos = 0;
+    while (step()) { /* consume */ }
+  };
+
+  // step handles one protocol unit; false means more data is needed
+  function step() {
+    switch (state) {
+      case "version":
+        if (available() < 12) { return false; }
+        bytes(12);
+        send(Array.from("RFB 003.008\n", function (c) { return c.charCodeAt(0); }));
+        state = "security";
+        return true;
+      case "security":
+        if (available() < 1 || available() < 1 + buf[pos]) { return false; }
+        var types = bytes(u8());
+        if (Array.prototype.indexOf.call(types, 1) < 0) { fail("no usable security type"); return false; }
+        send([1]);
+        state = "securityResult";
+        return true;
+      case "securityResult":
+        if (available() < 4) { return false; }
+        if (u32() !== 0) { fail("refused"); return false; }
+        send([1]); // ClientInit, shared
+        state = "serverInit";
+        return true;
+      case "serverInit":
+        if (available() < 24) { return false; }
+        var nameLength = (buf[pos + 20] << 24) | (buf[pos + 21] << 16) | (buf[pos + 22] << 8) | buf[pos + 23];
+        if (available() < 24 + nameLength) { return false; }
+        var w = u16(), h = u16();
+        bytes(16 + 4);
+        var name = new TextDecoder().decode(bytes(nameLength));
+        resize(w, h);
+        // 32 bpp, depth 24, little endian, true color, 255 per channel, R G B X in memory
+        send([0, 0, 0, 0, 32, 24, 0, 1].concat(be16(255), be16(255), be16(255), [0, 8, 16, 0, 0, 0]));
+        send([2, 0].concat(be16(3), be32(0), be32(1), be32(-223)));
+        requestUpdate(false);
+        setState(name);
+        state = "message";
+        canvas.focus();
+        return true;
+      case "message":
+        return message();
+      case "rect":
+        return nextRect();
+    }
+    return false;
+  }
+
+  function message() {
+    if (available() < 1) { return false; }
+    switch (buf[pos]) {
+      case 0: // FramebufferUpdate
+        if (available() < 4) { return false; }
+        pos += 2;
+        rectsLeft = u16();
+        state = "rect";
+        return true;
+      case 1: // SetColourMapEntries
+        if (available() < 6) { return false; }
+        var colors = (buf[pos + 4] << 8) | buf[pos + 5];
+        if (available() < 6 + 6 * colors) { return false; }
+        pos += 6 + 6 * colors;
+        return true;
+      case 2: // Bell
+        pos += 1;
+        return true;
+      case 3: // ServerCutText
+        if (available() < 8) { return false; }
+        var textLength = ((buf[pos + 4] << 24) >>> 0) + ((buf[pos + 5] << 16) | (buf[pos + 6] << 8) | buf[pos + 7]);
+        if (available() < 8 + textLength) { return false; }
+        pos += 8 + textLength;
+        return true;
+    }
+    fail("unknown message " + buf[pos]);
+    return false;
+  }
+
+  function nextRect() {
+    if (rectsLeft === 0) {
+      state = "message";
+      requestUpdate(true);
+      return true;
+    }
+    if (rect === null) {
+      if (available() < 12) { return false; }
+      rect = { x: u16(), y: u16(), w: u16(), h: u16(), encoding: s32() };
+    }
+    switch (rect.encoding) {
+      case 0: // Raw
+        var size = rect.w * rect.h * 4;
+        if (available() < size) { return false; }
+        var pixels = bytes(size);
+        if (size > 0) {
+          var img = ctx.createImageData(rect.w, rect.h);
+          img.data.set(pixels);
+          for (var i = 3; i < size; i += 4) { img.data[i] = 255; }
+          ctx.putImageData(img, rect.x, rect.y);
+        }
+        break;
+      case 1: // CopyRect
+        if (available() < 4) { return false; }
+        var sx = u16(), sy = u16();
+        ctx.drawImage(canvas, sx, sy, rect.w, rect.h, rect.x, rect.y, rect.w, rect.h);
+        break;
+      case -223: // DesktopSize
+        resize(rect.w, rect.h);
+        break;
+      default:
+        fail("unsupported encoding " + rect.encoding);
+        return false;
+    }
+    rect = null;
+    rectsLeft--;
+    return true;
+  }
+
+  function fail(reason) {
+    setState("error: " + reason);
+    ws.close();
+  }
+
+  // input
+
+  function pointer(e) {
+    var bounds = canvas.getBoundingClientRect();
+    var x = Math.max(0, Math.min(width - 1, Math.round((e.clientX - bounds.left) * width / bounds.width)));
+    var y = Math.max(0, Math.min(height - 1, Math.round((e.clientY - bounds.top) * height / bounds.height)));
+    return be16(x).concat(be16(y));
+  }
+
+  var buttonBits = [1, 2, 4];
+
+  canvas.addEventListener("mousemove", function (e) { send([5, buttons].concat(pointer(e))); });
+  canvas.addEventListener("mousedown", function (e) {
+    buttons |= buttonBits[e.button] || 0;
+    send([5, buttons].concat(pointer(e)));
+    canvas.focus();
+    e.preventDefault();
+  });
+  canvas.addEventListener("mouseup", function (e) {
+    buttons &= ~(buttonBits[e.button] || 0);
+    send([5, buttons].concat(pointer(e)));
+    e.preventDefault();
+  });
+  canvas.addEventListener("wheel", function (e) {
+    var bit = e.deltaY < 0 ? 8 : 16;
+    send([5, buttons | bit].concat(pointer(e)));
+    send([5, buttons].concat(pointer(e)));
+    e.preventDefault();
+  }, { passive: false });
+  canvas.addEventListener("contextmenu", function (e) { e.preventDefault(); });
+
+  var keysyms = {
+    Backspace: 0xff08, Tab: 0xff09, Enter: 0xff0d, Escape: 0xff1b, Delete: 0xffff,
+    Home: 0xff50, ArrowLeft: 0xff51, ArrowUp: 0xff52, ArrowRight: 0xff53, ArrowDown: 0xff54,
+    PageUp: 0xff55, PageDown: 0xff56, End: 0xff57, Insert: 0xff63,
+    Shift: 0xffe1, Control: 0xffe3, Alt: 0xffe9, Meta: 0xffe7, CapsLock: 0xffe5
+  };
+  for (var f = 1; f <= 12; f++) { keysyms["F" + f] = 0xffbd + f; }
+
+  function keysym(e) {
+    if (keysyms[e.key]) { return keysyms[e.key]; }
+    if (e.key.length === 1 || (e.key.length === 2 && e.key.codePointAt(0) > 0xffff)) {
+      var cp = e.key.codePointAt(0);
+      return cp < 0x100 ? cp : 0x01000000 | cp;
+    }
+    return 0;
+  }
+
+  function key(down) {
+    return function (e) {
+      var sym = keysym(e);
+      if (sym === 0) { return; }
+      send([4, down ? 1 : 0, 0, 0].concat(be32(sym)));
+      e.preventDefault();
+    };
+  }
+
+  canvas.addEventListener("keydown", key(true));
+  canvas.addEventListener("keyup", key(false));
+})();
@@ -0,0 +1,224 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package webvnc
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maxFrameSize bounds client frames; RFB client messages are small except for clipboard text
+	maxFrameSize = 1 << 20
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// wsConn is the server side of a WebSocket connection (RFC 6455) carrying a byte stream in binary
+// messages, as websockify does for noVNC.
+type wsConn struct {
+	conn    net.Conn
+	rw      *bufio.ReadWriter
+	pending []byte
+	writeMu sync.Mutex
+}
+
+// upgrade answers the WebSocket handshake of r and takes over its connection. The "binary"
+// subprotocol is selected if the client offers it. Browsers send the Origin of the page opening the
+// WebSocket; any page but the bundled client is refused, as it would take over the terminal with the
+// browser's access to the bridge.
+func upgrade(w http.ResponseWriter, r *http.Request) (ws *wsConn, err error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("websocket error: no upgrade request")
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin websocket refused", http.StatusForbidden)
+		return nil, fmt.Errorf("websocket error: refused origin %q", r.Header.Get("Origin"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if len(key) == 0 || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return nil, errors.New("websocket error: unsupported handshake")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket error: connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if headerContains(r.Header, "Sec-WebSocket-Protocol", "binary") {
+		response += "Sec-WebSocket-Protocol: binary\r\n"
+	}
+	if _, err = rw.WriteString(response + "\r\n"); err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// sameOrigin tells whether r comes from a page served by the same host, or not from a browser at all.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+
+	return err == nil && len(u.Host) > 0 && strings.EqualFold(u.Host, r.Host)
+}
+
+// Read returns the payload of binary (or text) messages as a continuous stream.
+func (c *wsConn) Read(p []byte) (n int, err error) {
+	for len(c.pending) == 0 {
+		if c.pending, err = c.readMessage(); err != nil {
+			return
+		}
+	}
+	n = copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return
+}
+
+// Write sends p as a single binary message.
+func (c *wsConn) Write(p []byte) (n int, err error) {
+	if err = c.writeFrame(opBinary, p); err != nil {
+		return
+	}
+
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	c.writeFrame(opClose, nil)
+	return c.conn.Close()
+}
+
+func (c *wsConn) readMessage() (payload []byte, err error) {
+	for {
+		var header [2]byte
+		if _, err = io.ReadFull(c.rw, header[:]); err != nil {
+			return
+		}
+		fin, opcode, masked := header[0]&0x80 != 0, header[0]&0x0f, header[1]&0x80 != 0
+		length := uint64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+				return
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if !masked {
+			return nil, errors.New("websocket error: client frames must be masked")
+		}
+		if length > maxFrameSize || uint64(len(payload))+length > maxFrameSize {
+			return nil, errors.New("websocket error: frame too large")
+		}
+		var mask [4]byte
+		if _, err = io.ReadFull(c.rw, mask[:]); err != nil {
+			return
+		}
+		data := make([]byte, length)
+		if _, err = io.ReadFull(c.rw, data); err != nil {
+			return
+		}
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case opPing:
+			if err = c.writeFrame(opPong, data); err != nil {
+				return
+			}
+		case opPong:
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, io.EOF
+		case opBinary, opText, opContinuation:
+			payload = append(payload, data...)
+			if fin {
+				return
+			}
+		default:
+			return nil, errors.New("websocket error: unknown opcode")
+		}
+	}
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) (err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xffff:
+		header = append(header, 126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		header = append(append(header, 127), ext[:]...)
+	}
+	if _, err = c.rw.Write(header); err != nil {
+		return
+	}
+	if _, err = c.rw.Write(payload); err != nil {
+		return
+	}
+
+	return c.rw.Flush()
+}
+
+func headerContains(header http.Header, key, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,171 @@
+// Copyright (C) 2022 The Avly Trader Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package webvnc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeVNCServer accepts one connection requiring VNC authentication with password and then echoes
+// client messages back.
+func fakeVNCServer(t *testing.T, password string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(rfbVersion))
+		io.ReadFull(conn, make([]byte, 12))
+		conn.Write([]byte{1, securityVNCAuth})
+		io.ReadFull(conn, make([]byte, 1))
+		challenge := []byte("0123456789abcdef")
+		conn.Write(challenge)
+		response := make([]byte, 16)
+		io.ReadFull(conn, response)
+		if !bytes.Equal(response, vncAuthResponse(password, challenge)) {
+			conn.Write([]byte{0, 0, 0, 1, 0, 0, 0, 0})
+			return
+		}
+		conn.Write([]byte{0, 0, 0, 0})
+		io.Copy(conn, conn)
+	}()
+
+	return listener.Addr().String()
+}
+
+// dialBridge performs a WebSocket handshake against url by hand.
+func dialBridge(t *testing.T, serverURL string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: avly\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Protocol: binary\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got '%s'", err.Error())
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Expected switching protocols, got '%s'", resp.Status)
+	}
+
+	return conn, reader
+}
+
+func writeMasked(conn net.Conn, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x82, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+// readStream reads n payload bytes from unmasked server frames.
+func readStream(t *testing.T, reader *bufio.Reader, n int) []byte {
+	var payload []byte
+	for len(payload) < n {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			t.Fatalf("Expected frame, got '%s'", err.Error())
+		}
+		length := int(header[1] & 0x7f)
+		if length == 126 {
+			ext := make([]byte, 2)
+			io.ReadFull(reader, ext)
+			length = int(binary.BigEndian.Uint16(ext))
+		}
+		data := make([]byte, length)
+		io.ReadFull(reader, data)
+		payload = append(payload, data...)
+	}
+
+	return payload
+}
+
+func TestBridge(t *testing.T) {
+	marked := make(chan string, 1)
+	bridge := &Bridge{
+		Addr:     fakeVNCServer(t, "secret"),
+		Password: func(bool) (string, error) { return "secret", nil },
+		ViewOnly: true,
+		Mark: func(local string) func() {
+			marked <- local
+			return func() { t.Errorf("Expected %s not to be unmarked", local) }
+		},
+	}
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	conn, reader := dialBridge(t, server.URL)
+	defer conn.Close()
+
+	if version := readStream(t, reader, 12); string(version) != rfbVersion {
+		t.Fatalf("Expected version '%q', got '%q'", rfbVersion, version)
+	}
+	writeMasked(conn, []byte(rfbVersion))
+	if security := readStream(t, reader, 2); !bytes.Equal(security, []byte{1, securityNone}) {
+		t.Fatalf("Expected security type none, got '%v'", security)
+	}
+	writeMasked(conn, []byte{securityNone})
+	if result := readStream(t, reader, 4); !bytes.Equal(result, []byte{0, 0, 0, 0}) {
+		t.Fatalf("Expected security result ok, got '%v'", result)
+	}
+	if local := <-marked; !strings.HasPrefix(local, "127.0.0.1:") {
+		t.Errorf("Expected '%s' to be the local address of the connection to the server", local)
+	}
+
+	// a pointer event is dropped, the update request passes the view-only filter and is echoed
+	writeMasked(conn, []byte{msgPointerEvent, 1, 0, 10, 0, 10})
+	request := []byte{msgUpdateRequest, 1, 0, 0, 0, 0, 5, 86, 3, 0}
+	writeMasked(conn, request)
+	if echoed := readStream(t, reader, len(request)); !bytes.Equal(echoed, request) {
+		t.Errorf("Expected '%v' to be '%v'", echoed, request)
+	}
+}
+
+func TestCopyViewOnlyRejectsUnknownMessages(t *testing.T) {
+	var out bytes.Buffer
+	if err := copyViewOnly(&out, bytes.NewReader([]byte{200, 0, 0})); err == nil {
+		t.Errorf("Expected unknown message to end the session")
+	}
+}
+
+func TestUpgradeRefusesForeignOrigin(t *testing.T) {
+	cases := map[string]bool{"": true, "http://avly:7300": true, "https://evil.example": false, "null": false}
+	for origin, allowed := range cases {
+		req := httptest.NewRequest(http.MethodGet, "http://avly:7300/vnc/websockify", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if len(origin) > 0 {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		upgrade(rec, req)
+		// the recorder cannot be hijacked, so an allowed handshake fails later
+		if refused := rec.Code == http.StatusForbidden; refused == allowed {
+			t.Errorf("%q: Expected code '%d' to refuse only foreign origins", origin, rec.Code)
+		}
+	}
+}